@@ -0,0 +1,97 @@
+// Package bus is the message broker between the pipeline stages. Kafka
+// (Redpanda) is what the cluster runs and NATS JetStream suits smaller
+// deployments. The in-memory bus connects stages within one process, which
+// only tests do, so bus.kind does not offer it.
+//
+// Whatever the backend, messages are *kgo.Records: a stage reads Topic,
+// Value and Headers the same way everywhere, so trace context and the DLQ
+// headers travel unchanged. Partition and Offset are the backend's closest
+// equivalents (0 and the stream sequence on NATS).
+//
+// Delivery is at-least-once: a record that is not acked is redelivered after
+// a restart (Kafka, NATS) or lost with the process (memory). On Kafka, a Txn
+// makes a stage exactly-once.
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/exploravis/config"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// ErrClosed is returned by Poll once the subscription has been closed.
+var ErrClosed = errors.New("bus: subscription closed")
+
+// Bus publishes records and hands out group subscriptions.
+type Bus interface {
+	Publisher
+
+	// Subscribe joins group as a consumer of topics. Subscriptions in the
+	// same group share the records between them; every group gets all of
+	// them.
+	Subscribe(group string, topics ...string) (Subscription, error)
+
+	// Ping reports an error while the broker cannot be reached to publish.
+	Ping(ctx context.Context) error
+
+	// Close releases the publishing side; subscriptions are closed on
+	// their own.
+	Close()
+}
+
+// Publisher is the publishing half of a Bus, which a Txn also provides.
+type Publisher interface {
+	// Publish sends rec to rec.Topic without blocking and calls done
+	// exactly once, when the record is durable or delivery has failed.
+	// Records with the same key reach one group member in publish order;
+	// on NATS, whose streams have no partitions, only while the group has
+	// a single member.
+	Publish(ctx context.Context, rec *kgo.Record, done func(*kgo.Record, error))
+
+	// Flush waits until every published record's done has been called, or
+	// ctx is done.
+	Flush(ctx context.Context) error
+}
+
+// Subscription is one member of a consumer group.
+type Subscription interface {
+	// Poll blocks until records are available or ctx is done, and returns
+	// at most max of them, or as many as are buffered if max <= 0. A
+	// non-nil error other than ErrClosed or ctx's is transient: log it and
+	// poll again. Records may come with an error.
+	Poll(ctx context.Context, max int) ([]*kgo.Record, error)
+
+	// Ack marks a polled record processed. Records can be acked in any
+	// order; an unacked record holds back the commit of later ones on
+	// backends that commit offsets, so a crash redelivers it.
+	Ack(rec *kgo.Record)
+
+	// Pause stops delivering records from partition of topic until
+	// ResumeAll. Records already polled are unaffected.
+	Pause(topic string, partition int32)
+	ResumeAll()
+
+	// Joined reports an error while the subscription is not an active
+	// member of its group, e.g. during a rebalance.
+	Joined(ctx context.Context) error
+
+	// Close commits what was acked and leaves the group, so its share of
+	// the records goes to the remaining members straight away.
+	Close(ctx context.Context)
+}
+
+// New connects to the bus selected by b. k is only used for Kafka.
+func New(b config.Bus, k config.Kafka) (Bus, error) {
+	switch b.Kind {
+	case "kafka":
+		return NewKafka(k)
+	case "nats":
+		return NewNATS(b.NATS)
+	case "memory":
+		return NewMemory(), nil
+	}
+	return nil, fmt.Errorf("bus: unknown kind %q", b.Kind)
+}
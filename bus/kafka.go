@@ -0,0 +1,246 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/exploravis/config"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Kafka is the bus over Kafka or Redpanda. One client publishes for the
+// whole process; each subscription has its own group client.
+type Kafka struct {
+	k  config.Kafka
+	cl *kgo.Client
+}
+
+func NewKafka(k config.Kafka) (*Kafka, error) {
+	opts, err := k.ClientOpts()
+	if err != nil {
+		return nil, err
+	}
+	cl, err := kgo.NewClient(append(opts,
+		kgo.DialTimeout(5*time.Second),
+		kgo.ProduceRequestTimeout(5*time.Second),
+		kgo.RecordPartitioner(spreadPartitioner{}),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Kafka{k: k, cl: cl}, nil
+}
+
+// spreadPartitioner keeps keyed records together, hashing the key like
+// Kafka's default partitioner, and spreads unkeyed ones round-robin so every
+// consumer gets work.
+type spreadPartitioner struct{}
+
+func (spreadPartitioner) ForTopic(topic string) kgo.TopicPartitioner {
+	return &spreadTopicPartitioner{
+		keyed:   kgo.StickyKeyPartitioner(nil).ForTopic(topic),
+		unkeyed: kgo.RoundRobinPartitioner().ForTopic(topic),
+	}
+}
+
+type spreadTopicPartitioner struct {
+	keyed, unkeyed kgo.TopicPartitioner
+}
+
+func (p *spreadTopicPartitioner) RequiresConsistency(r *kgo.Record) bool {
+	return r.Key != nil
+}
+
+func (p *spreadTopicPartitioner) Partition(r *kgo.Record, n int) int {
+	if r.Key != nil {
+		return p.keyed.Partition(r, n)
+	}
+	return p.unkeyed.Partition(r, n)
+}
+
+func (b *Kafka) Publish(ctx context.Context, rec *kgo.Record, done func(*kgo.Record, error)) {
+	b.cl.Produce(ctx, rec, done)
+}
+
+func (b *Kafka) Flush(ctx context.Context) error {
+	return b.cl.Flush(ctx)
+}
+
+func (b *Kafka) Ping(ctx context.Context) error {
+	return b.cl.Ping(ctx)
+}
+
+func (b *Kafka) Close() {
+	b.cl.Close()
+}
+
+// Subscribe starts a group consumer reading from the earliest offset the
+// group has not committed. It skips records of aborted transactions.
+func (b *Kafka) Subscribe(group string, topics ...string) (Subscription, error) {
+	opts, err := b.k.ClientOpts()
+	if err != nil {
+		return nil, err
+	}
+	s := &kafkaSubscription{parts: map[string]map[int32]*partition{}}
+	cl, err := kgo.NewClient(append(opts,
+		kgo.DialTimeout(5*time.Second),
+		kgo.ConsumeTopics(topics...),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.AutoCommitMarks(),
+		kgo.OnPartitionsRevoked(s.revoked),
+		kgo.OnPartitionsLost(s.lost),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	s.cl = cl
+	return s, nil
+}
+
+// kafkaSubscription commits a partition's offset only past a contiguous
+// run of acked records, so a crash redelivers everything still in flight.
+// It marks offsets and lets the client's AutoCommitMarks loop commit them,
+// plus a blocking commit when partitions are revoked.
+type kafkaSubscription struct {
+	cl *kgo.Client
+
+	mu    sync.Mutex
+	parts map[string]map[int32]*partition
+}
+
+type partition struct {
+	pending []int64 // polled offsets, oldest first
+	done    map[int64]bool
+}
+
+func (s *kafkaSubscription) Poll(ctx context.Context, max int) ([]*kgo.Record, error) {
+	fetches := s.cl.PollRecords(ctx, max)
+	if fetches.IsClientClosed() {
+		return nil, ErrClosed
+	}
+	var errs []error
+	fetches.EachError(func(_ string, _ int32, err error) {
+		errs = append(errs, err)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	recs := fetches.Records()
+	s.track(recs)
+	return recs, errors.Join(errs...)
+}
+
+// track registers polled records in poll order, before any can be acked.
+func (s *kafkaSubscription) track(recs []*kgo.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rec := range recs {
+		topic := s.parts[rec.Topic]
+		if topic == nil {
+			topic = map[int32]*partition{}
+			s.parts[rec.Topic] = topic
+		}
+		p := topic[rec.Partition]
+		if p == nil {
+			p = &partition{done: map[int64]bool{}}
+			topic[rec.Partition] = p
+		}
+		p.pending = append(p.pending, rec.Offset)
+	}
+}
+
+func (s *kafkaSubscription) Ack(rec *kgo.Record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The partition was revoked while this record was in flight; whoever
+	// owns it now will redeliver it.
+	p := s.parts[rec.Topic][rec.Partition]
+	if p == nil {
+		return
+	}
+
+	next, ok := p.ack(rec.Offset)
+	if !ok {
+		return
+	}
+	s.cl.MarkCommitOffsets(map[string]map[int32]kgo.EpochOffset{
+		rec.Topic: {rec.Partition: {Epoch: rec.LeaderEpoch, Offset: next}},
+	})
+}
+
+// ack marks offset done and returns the offset to commit if that completes
+// a run at the start of pending; offsets after a gap wait for it to fill.
+func (p *partition) ack(offset int64) (next int64, ok bool) {
+	if len(p.pending) == 0 || offset < p.pending[0] {
+		return 0, false // already committed past it
+	}
+	p.done[offset] = true
+	for len(p.pending) > 0 && p.done[p.pending[0]] {
+		delete(p.done, p.pending[0])
+		next, ok = p.pending[0]+1, true
+		p.pending = p.pending[1:]
+	}
+	return next, ok
+}
+
+func (s *kafkaSubscription) forget(lost map[string][]int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for topic, parts := range lost {
+		for _, part := range parts {
+			delete(s.parts[topic], part)
+		}
+	}
+}
+
+func (s *kafkaSubscription) revoked(ctx context.Context, cl *kgo.Client, revoked map[string][]int32) {
+	if err := cl.CommitMarkedOffsets(ctx); err != nil {
+		log.Printf("[WARN] Commit on revoke failed: %v", err)
+	}
+	s.forget(revoked)
+}
+
+func (s *kafkaSubscription) lost(_ context.Context, _ *kgo.Client, lost map[string][]int32) {
+	s.forget(lost)
+}
+
+func (s *kafkaSubscription) Pause(topic string, partition int32) {
+	s.cl.PauseFetchPartitions(map[string][]int32{topic: {partition}})
+}
+
+func (s *kafkaSubscription) ResumeAll() {
+	s.cl.ResumeFetchPartitions(s.cl.PauseFetchPartitions(nil))
+}
+
+func (s *kafkaSubscription) Joined(context.Context) error {
+	return groupJoined(s.cl)
+}
+
+// groupJoined checks cl has a generation, which it lacks until its first
+// join and between losing its partitions and rejoining.
+func groupJoined(cl *kgo.Client) error {
+	if _, gen := cl.GroupMetadata(); gen < 0 {
+		return errors.New("not a member of the consumer group")
+	}
+	return nil
+}
+
+// Close commits the offsets marked so far and leaves the group, so
+// partitions are reassigned straight away rather than after the session
+// timeout.
+func (s *kafkaSubscription) Close(ctx context.Context) {
+	if err := s.cl.CommitMarkedOffsets(ctx); err != nil {
+		log.Printf("[WARN] Final offset commit failed: %v", err)
+	}
+	if err := s.cl.LeaveGroupContext(ctx); err != nil {
+		log.Printf("[WARN] Leaving consumer group failed: %v", err)
+	}
+	s.cl.Close()
+}
@@ -0,0 +1,95 @@
+package bus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestPartitionAck(t *testing.T) {
+	tests := []struct {
+		name    string
+		polled  []int64
+		acks    []int64
+		commits []string // per ack: the offset to commit, or "-"
+	}{
+		{
+			name:    "in order",
+			polled:  []int64{0, 1, 2},
+			acks:    []int64{0, 1, 2},
+			commits: []string{"1", "2", "3"},
+		},
+		{
+			name:    "gap holds back later acks",
+			polled:  []int64{0, 1, 2, 3},
+			acks:    []int64{1, 3, 0, 2},
+			commits: []string{"-", "-", "2", "4"},
+		},
+		{
+			name:    "reverse order commits once",
+			polled:  []int64{5, 6, 7},
+			acks:    []int64{7, 6, 5},
+			commits: []string{"-", "-", "8"},
+		},
+		{
+			// Transaction markers and compaction leave holes in the offsets
+			// polled; the commit skips past them.
+			name:    "offsets need not be consecutive",
+			polled:  []int64{10, 11, 14, 20},
+			acks:    []int64{11, 10, 20, 14},
+			commits: []string{"-", "12", "-", "21"},
+		},
+		{
+			name:    "duplicate ack is harmless",
+			polled:  []int64{0, 1},
+			acks:    []int64{0, 0, 1},
+			commits: []string{"1", "-", "2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &partition{pending: append([]int64(nil), tt.polled...), done: map[int64]bool{}}
+			for i, off := range tt.acks {
+				got := "-"
+				if next, ok := p.ack(off); ok {
+					got = fmt.Sprint(next)
+				}
+				if got != tt.commits[i] {
+					t.Errorf("ack %d: commit %s, want %s", off, got, tt.commits[i])
+				}
+			}
+			if len(p.pending) != 0 || len(p.done) != 0 {
+				t.Errorf("left pending %v, done %v", p.pending, p.done)
+			}
+		})
+	}
+}
+
+func TestTrackAndForget(t *testing.T) {
+	s := &kafkaSubscription{parts: map[string]map[int32]*partition{}}
+	s.track([]*kgo.Record{
+		{Topic: "a", Partition: 0, Offset: 3},
+		{Topic: "b", Partition: 1, Offset: 7},
+		{Topic: "a", Partition: 0, Offset: 4},
+		{Topic: "a", Partition: 2, Offset: 0},
+	})
+	if got := s.parts["a"][0].pending; fmt.Sprint(got) != "[3 4]" {
+		t.Errorf("a/0 pending = %v, want poll order", got)
+	}
+	if got := s.parts["b"][1].pending; fmt.Sprint(got) != "[7]" {
+		t.Errorf("b/1 pending = %v", got)
+	}
+
+	s.forget(map[string][]int32{"a": {0}})
+	if s.parts["a"][0] != nil {
+		t.Error("revoked partition still tracked")
+	}
+	if s.parts["a"][2] == nil || s.parts["b"][1] == nil {
+		t.Error("forgot partitions that were not revoked")
+	}
+
+	// A record of a revoked partition acked afterwards is dropped without
+	// touching the client.
+	s.Ack(&kgo.Record{Topic: "a", Partition: 0, Offset: 3})
+}
@@ -0,0 +1,210 @@
+package bus
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// memoryBatch caps the records one Poll returns.
+const memoryBatch = 500
+
+// Memory is a bus within one process, for tests and single-binary runs.
+// Every topic is one partition held in memory until every group with a
+// member has read it; nothing survives a restart and Ack is a no-op. Each
+// Poll hands out its own copies, so consumers cannot see each other's
+// changes to a record.
+type Memory struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	topics map[string]*memoryTopic
+}
+
+type memoryTopic struct {
+	base    int64 // offset of records[0]
+	records []*kgo.Record
+	groups  map[string]int64 // next offset per group
+	members map[string]int   // open subscriptions per group
+}
+
+func NewMemory() *Memory {
+	m := &Memory{topics: map[string]*memoryTopic{}}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+func (m *Memory) topic(name string) *memoryTopic {
+	t := m.topics[name]
+	if t == nil {
+		t = &memoryTopic{groups: map[string]int64{}, members: map[string]int{}}
+		m.topics[name] = t
+	}
+	return t
+}
+
+// Publish stores a copy of rec and calls done before returning.
+func (m *Memory) Publish(_ context.Context, rec *kgo.Record, done func(*kgo.Record, error)) {
+	m.mu.Lock()
+	t := m.topic(rec.Topic)
+	rec.Offset = t.base + int64(len(t.records))
+	rec.Timestamp = time.Now()
+	t.records = append(t.records, copyRecord(rec))
+	m.cond.Broadcast()
+	m.mu.Unlock()
+
+	done(rec, nil)
+}
+
+func (m *Memory) Flush(context.Context) error { return nil }
+
+func (m *Memory) Ping(context.Context) error { return nil }
+
+func (m *Memory) Close() {}
+
+// Subscribe starts a group at the oldest record still held, the same as a
+// new Kafka group starting from the earliest offset. A group whose members
+// have all closed is forgotten, and starts there again.
+func (m *Memory) Subscribe(group string, topics ...string) (Subscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, name := range topics {
+		t := m.topic(name)
+		if _, ok := t.groups[group]; !ok {
+			t.groups[group] = t.base
+		}
+		t.members[group]++
+	}
+	return &memorySubscription{m: m, group: group, topics: topics, paused: map[string]bool{}}, nil
+}
+
+type memorySubscription struct {
+	m      *Memory
+	group  string
+	topics []string
+
+	// Guarded by m.mu.
+	paused map[string]bool
+	closed bool
+}
+
+func (s *memorySubscription) Poll(ctx context.Context, max int) ([]*kgo.Record, error) {
+	if max <= 0 || max > memoryBatch {
+		max = memoryBatch
+	}
+	m := s.m
+	stop := context.AfterFunc(ctx, func() {
+		m.mu.Lock()
+		m.cond.Broadcast()
+		m.mu.Unlock()
+	})
+	defer stop()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		if s.closed {
+			return nil, ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var recs []*kgo.Record
+		for _, name := range s.topics {
+			if s.paused[name] {
+				continue
+			}
+			t := m.topics[name]
+			next := t.groups[s.group]
+			from := int(next - t.base)
+			n := min(len(t.records)-from, max-len(recs))
+			if n <= 0 {
+				continue
+			}
+			for _, rec := range t.records[from : from+n] {
+				recs = append(recs, copyRecord(rec))
+			}
+			t.groups[s.group] = next + int64(n)
+			t.trim()
+		}
+		if len(recs) > 0 {
+			return recs, nil
+		}
+		m.cond.Wait()
+	}
+}
+
+// copyRecord copies rec down to its bytes.
+func copyRecord(rec *kgo.Record) *kgo.Record {
+	c := *rec
+	c.Key = bytes.Clone(rec.Key)
+	c.Value = bytes.Clone(rec.Value)
+	if rec.Headers != nil {
+		c.Headers = make([]kgo.RecordHeader, len(rec.Headers))
+		for i, h := range rec.Headers {
+			c.Headers[i] = kgo.RecordHeader{Key: h.Key, Value: bytes.Clone(h.Value)}
+		}
+	}
+	return &c
+}
+
+// trim drops records every group has read.
+func (t *memoryTopic) trim() {
+	low := t.base + int64(len(t.records))
+	for _, next := range t.groups {
+		low = min(low, next)
+	}
+	if n := int(low - t.base); n > 0 {
+		clear(t.records[:n])
+		t.records = t.records[n:]
+		t.base = low
+	}
+}
+
+func (s *memorySubscription) Ack(*kgo.Record) {}
+
+// Pause stops delivering the whole topic; memory topics have one partition.
+func (s *memorySubscription) Pause(topic string, _ int32) {
+	s.m.mu.Lock()
+	s.paused[topic] = true
+	s.m.mu.Unlock()
+}
+
+func (s *memorySubscription) ResumeAll() {
+	s.m.mu.Lock()
+	s.paused = map[string]bool{}
+	s.m.cond.Broadcast()
+	s.m.mu.Unlock()
+}
+
+func (s *memorySubscription) Joined(context.Context) error {
+	s.m.mu.Lock()
+	defer s.m.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	return nil
+}
+
+// Close leaves the group. Once its last member has left, the group no
+// longer holds records back from trim.
+func (s *memorySubscription) Close(context.Context) {
+	m := s.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	for _, name := range s.topics {
+		t := m.topics[name]
+		if t.members[s.group]--; t.members[s.group] <= 0 {
+			delete(t.members, s.group)
+			delete(t.groups, s.group)
+			t.trim()
+		}
+	}
+	m.cond.Broadcast()
+}
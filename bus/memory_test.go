@@ -0,0 +1,179 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func publish(t *testing.T, m *Memory, topic string, values ...string) {
+	t.Helper()
+	for _, v := range values {
+		m.Publish(context.Background(), &kgo.Record{Topic: topic, Value: []byte(v)}, func(_ *kgo.Record, err error) {
+			if err != nil {
+				t.Fatalf("publish %q: %v", v, err)
+			}
+		})
+	}
+}
+
+func poll(t *testing.T, sub Subscription, max int) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	recs, err := sub.Poll(ctx, max)
+	if err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	var values []string
+	for _, rec := range recs {
+		values = append(values, string(rec.Value))
+	}
+	return values
+}
+
+func subscribe(t *testing.T, m *Memory, group string, topics ...string) Subscription {
+	t.Helper()
+	sub, err := m.Subscribe(group, topics...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func TestMemoryGroups(t *testing.T) {
+	m := NewMemory()
+	a := subscribe(t, m, "a", "t")
+	b := subscribe(t, m, "b", "t")
+	publish(t, m, "t", "1", "2", "3")
+
+	if got := poll(t, a, 2); fmt.Sprint(got) != "[1 2]" {
+		t.Errorf("a got %v, want the first two", got)
+	}
+	if got := poll(t, a, 0); fmt.Sprint(got) != "[3]" {
+		t.Errorf("a got %v, want the rest", got)
+	}
+	if got := poll(t, b, 0); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("b got %v, want every record", got)
+	}
+}
+
+func TestMemoryCopiesPerGroup(t *testing.T) {
+	m := NewMemory()
+	a := subscribe(t, m, "a", "t")
+	b := subscribe(t, m, "b", "t")
+	rec := &kgo.Record{Topic: "t", Value: []byte("v"), Headers: []kgo.RecordHeader{{Key: "h", Value: []byte("x")}}}
+	m.Publish(context.Background(), rec, func(*kgo.Record, error) {})
+	rec.Value[0] = 'P'
+
+	ctx := context.Background()
+	ra, _ := a.Poll(ctx, 0)
+	ra[0].Value[0] = 'A'
+	ra[0].Headers[0].Value[0] = 'A'
+	ra[0].Headers = append(ra[0].Headers, kgo.RecordHeader{Key: "extra"})
+
+	rb, _ := b.Poll(ctx, 0)
+	if got := rb[0]; string(got.Value) != "v" || len(got.Headers) != 1 || string(got.Headers[0].Value) != "x" {
+		t.Errorf("b got value %q, headers %v; changes by the publisher or group a leaked", got.Value, got.Headers)
+	}
+}
+
+func TestMemoryTrim(t *testing.T) {
+	tests := []struct {
+		name string
+		// closeB leaves group b, the one that has read nothing.
+		closeB bool
+		// secondB adds another member to b that stays open.
+		secondB bool
+		want    int
+	}{
+		{name: "slow group holds records", want: 3},
+		{name: "closed group releases them", closeB: true, want: 0},
+		{name: "open member keeps its group", closeB: true, secondB: true, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemory()
+			a := subscribe(t, m, "a", "t")
+			b := subscribe(t, m, "b", "t")
+			if tt.secondB {
+				subscribe(t, m, "b", "t")
+			}
+			publish(t, m, "t", "1", "2", "3")
+			poll(t, a, 0)
+			if tt.closeB {
+				b.Close(context.Background())
+			}
+
+			m.mu.Lock()
+			held := len(m.topics["t"].records)
+			m.mu.Unlock()
+			if held != tt.want {
+				t.Errorf("%d records held, want %d", held, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRejoinAfterClose(t *testing.T) {
+	m := NewMemory()
+	a := subscribe(t, m, "a", "t")
+	b := subscribe(t, m, "b", "t")
+	publish(t, m, "t", "1", "2")
+	poll(t, a, 0)
+	b.Close(context.Background())
+	b.Close(context.Background()) // a second close must not leave again
+
+	publish(t, m, "t", "3")
+	b = subscribe(t, m, "b", "t")
+	if got := poll(t, b, 0); fmt.Sprint(got) != "[3]" {
+		t.Errorf("rejoined b got %v, want what is still held", got)
+	}
+}
+
+func TestMemoryPause(t *testing.T) {
+	m := NewMemory()
+	sub := subscribe(t, m, "g", "x", "y")
+	publish(t, m, "x", "x1")
+	publish(t, m, "y", "y1")
+
+	sub.Pause("x", 0)
+	if got := poll(t, sub, 0); fmt.Sprint(got) != "[y1]" {
+		t.Errorf("paused poll got %v, want y only", got)
+	}
+	sub.ResumeAll()
+	if got := poll(t, sub, 0); fmt.Sprint(got) != "[x1]" {
+		t.Errorf("resumed poll got %v, want x", got)
+	}
+}
+
+func TestMemoryPollUnblocks(t *testing.T) {
+	m := NewMemory()
+	sub := subscribe(t, m, "g", "t")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := sub.Poll(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("poll on an empty topic = %v, want the deadline", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := sub.Poll(context.Background(), 0)
+		errc <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	sub.Close(context.Background())
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrClosed) {
+			t.Errorf("poll after close = %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("close did not wake a blocked poll")
+	}
+}
@@ -0,0 +1,396 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/exploravis/config"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// keyHeader carries a record's key, which NATS messages don't have.
+const keyHeader = "Exploravis-Key"
+
+// NATS is the bus over NATS JetStream. Each topic is a stream of the same
+// name bound to the subject of the same name; each group is a durable pull
+// consumer on it, so its members share the messages.
+type NATS struct {
+	cfg config.NATS
+	nc  *nats.Conn
+	js  jetstream.JetStream
+
+	mu      sync.Mutex
+	streams map[string]bool
+}
+
+func NewNATS(cfg config.NATS) (*NATS, error) {
+	opts := []nats.Option{nats.Name("exploravis"), nats.MaxReconnects(-1)}
+	if cfg.CredsFile != "" {
+		opts = append(opts, nats.UserCredentials(cfg.CredsFile))
+	}
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+	nc, err := nats.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return &NATS{cfg: cfg, nc: nc, js: js, streams: map[string]bool{}}, nil
+}
+
+// ensureStream creates topic's stream the first time this process uses it.
+func (b *NATS) ensureStream(ctx context.Context, topic string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.streams[topic] {
+		return nil
+	}
+	_, err := b.js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:     topic,
+		Subjects: []string{topic},
+		MaxAge:   b.cfg.MaxAge,
+		Storage:  jetstream.FileStorage,
+	})
+	if err != nil {
+		return fmt.Errorf("creating stream %s: %w", topic, err)
+	}
+	b.streams[topic] = true
+	return nil
+}
+
+func (b *NATS) Publish(ctx context.Context, rec *kgo.Record, done func(*kgo.Record, error)) {
+	if err := b.ensureStream(ctx, rec.Topic); err != nil {
+		done(rec, err)
+		return
+	}
+	msg := nats.NewMsg(rec.Topic)
+	msg.Data = rec.Value
+	for _, h := range rec.Headers {
+		msg.Header.Add(h.Key, string(h.Value))
+	}
+	if rec.Key != nil {
+		msg.Header.Set(keyHeader, string(rec.Key))
+	}
+
+	fut, err := b.js.PublishMsgAsync(msg)
+	if err != nil {
+		done(rec, err)
+		return
+	}
+	go func() {
+		select {
+		case ack := <-fut.Ok():
+			rec.Offset = int64(ack.Sequence)
+			rec.Timestamp = time.Now()
+			done(rec, nil)
+		case err := <-fut.Err():
+			done(rec, err)
+		}
+	}()
+}
+
+func (b *NATS) Flush(ctx context.Context) error {
+	select {
+	case <-b.js.PublishAsyncComplete():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Ping round-trips to the server, which also fails while reconnecting. The
+// round trip needs a deadline, so one is added if ctx has none.
+func (b *NATS) Ping(ctx context.Context) error {
+	if !b.nc.IsConnected() {
+		return fmt.Errorf("nats connection %s", b.nc.Status())
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+	return b.nc.FlushWithContext(ctx)
+}
+
+func (b *NATS) Close() {
+	b.nc.Close()
+}
+
+// Read calls fn with the records of topic's stream in order, from sequence
+// from (or the first one, if from <= 0) up to the last one stored when Read
+// was called, until fn returns false. It reads outside any group and acks
+// nothing, so the stream is left as it was.
+func (b *NATS) Read(ctx context.Context, topic string, from int64, fn func(*kgo.Record) bool) error {
+	stream, err := b.js.Stream(ctx, topic)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("stream %s: %w", topic, err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		return fmt.Errorf("stream %s: %w", topic, err)
+	}
+	last := info.State.LastSeq
+	if info.State.Msgs == 0 || (from > 0 && uint64(from) > last) {
+		return nil
+	}
+
+	cfg := jetstream.OrderedConsumerConfig{DeliverPolicy: jetstream.DeliverAllPolicy}
+	if from > 0 {
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = uint64(from)
+	}
+	c, err := stream.OrderedConsumer(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", topic, err)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		batch, err := c.Fetch(natsBatch, jetstream.FetchMaxWait(natsFetchWait))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", topic, err)
+		}
+		n := 0
+		for msg := range batch.Messages() {
+			n++
+			rec := natsRecord(topic, msg)
+			if uint64(rec.Offset) > last || !fn(rec) || uint64(rec.Offset) == last {
+				return nil
+			}
+		}
+		if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+			return fmt.Errorf("reading %s: %w", topic, err)
+		}
+		if n == 0 {
+			// Nothing came: done if what was left has expired meanwhile.
+			info, err := stream.Info(ctx)
+			if err != nil {
+				return fmt.Errorf("stream %s: %w", topic, err)
+			}
+			if info.State.Msgs == 0 || info.State.FirstSeq > last {
+				return nil
+			}
+		}
+	}
+}
+
+func (b *NATS) Subscribe(group string, topics ...string) (Subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s := &natsSubscription{
+		consumers: map[string]jetstream.Consumer{},
+		inFlight:  map[*kgo.Record]jetstream.Msg{},
+		paused:    map[string]bool{},
+		stop:      make(chan struct{}),
+	}
+	for _, topic := range topics {
+		if err := b.ensureStream(ctx, topic); err != nil {
+			return nil, err
+		}
+		c, err := b.js.CreateOrUpdateConsumer(ctx, topic, jetstream.ConsumerConfig{
+			Durable:       group,
+			AckPolicy:     jetstream.AckExplicitPolicy,
+			AckWait:       b.cfg.AckWait,
+			DeliverPolicy: jetstream.DeliverAllPolicy,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating consumer %s on %s: %w", group, topic, err)
+		}
+		s.consumers[topic] = c
+	}
+	go s.keepAlive(b.cfg.AckWait / 2)
+	return s, nil
+}
+
+// natsSubscription acks each record on its own; JetStream redelivers any
+// message not acked within AckWait, to whichever member fetches next. While
+// the subscription is open it keeps resetting that timer for the records it
+// holds, like an unacked Kafka offset, so a record that waits in a queue or
+// takes longer than AckWait to process is only redelivered once this member
+// is gone.
+type natsSubscription struct {
+	consumers map[string]jetstream.Consumer
+	stop      chan struct{}
+
+	mu       sync.Mutex
+	inFlight map[*kgo.Record]jetstream.Msg
+	paused   map[string]bool
+	closed   bool
+}
+
+// keepAlive marks every polled, unacked message in progress each interval
+// until Close.
+func (s *natsSubscription) keepAlive(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+		}
+		s.mu.Lock()
+		msgs := make([]jetstream.Msg, 0, len(s.inFlight))
+		for _, msg := range s.inFlight {
+			msgs = append(msgs, msg)
+		}
+		s.mu.Unlock()
+		for _, msg := range msgs {
+			// A lost one only means a redelivery.
+			_ = msg.InProgress()
+		}
+	}
+}
+
+// natsFetchWait bounds each fetch, so Poll notices ctx and Close promptly.
+const natsFetchWait = time.Second
+
+// natsBatch caps the records one Poll returns.
+const natsBatch = 500
+
+func (s *natsSubscription) Poll(ctx context.Context, max int) ([]*kgo.Record, error) {
+	if max <= 0 || max > natsBatch {
+		max = natsBatch
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		closed := s.closed
+		var active []string
+		for topic := range s.consumers {
+			if !s.paused[topic] {
+				active = append(active, topic)
+			}
+		}
+		s.mu.Unlock()
+		if closed {
+			return nil, ErrClosed
+		}
+		if len(active) == 0 {
+			time.Sleep(natsFetchWait)
+			continue
+		}
+
+		var (
+			recs []*kgo.Record
+			errs []error
+		)
+		for _, topic := range active {
+			if len(recs) == max {
+				break
+			}
+			batch, err := s.consumers[topic].Fetch(max-len(recs), jetstream.FetchMaxWait(natsFetchWait/time.Duration(len(active))))
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			for msg := range batch.Messages() {
+				recs = append(recs, s.record(topic, msg))
+			}
+			if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+				errs = append(errs, err)
+			}
+		}
+		if len(recs) > 0 || len(errs) > 0 {
+			return recs, errors.Join(errs...)
+		}
+	}
+}
+
+// natsRecord converts msg of topic's stream.
+func natsRecord(topic string, msg jetstream.Msg) *kgo.Record {
+	rec := &kgo.Record{Topic: topic, Value: msg.Data()}
+	for key, values := range msg.Headers() {
+		for _, v := range values {
+			if key == keyHeader {
+				rec.Key = []byte(v)
+				continue
+			}
+			rec.Headers = append(rec.Headers, kgo.RecordHeader{Key: key, Value: []byte(v)})
+		}
+	}
+	if meta, err := msg.Metadata(); err == nil {
+		rec.Offset = int64(meta.Sequence.Stream)
+		rec.Timestamp = meta.Timestamp
+	}
+	return rec
+}
+
+// record converts msg and remembers it for Ack.
+func (s *natsSubscription) record(topic string, msg jetstream.Msg) *kgo.Record {
+	rec := natsRecord(topic, msg)
+	s.mu.Lock()
+	s.inFlight[rec] = msg
+	s.mu.Unlock()
+	return rec
+}
+
+func (s *natsSubscription) Ack(rec *kgo.Record) {
+	s.mu.Lock()
+	msg, ok := s.inFlight[rec]
+	delete(s.inFlight, rec)
+	s.mu.Unlock()
+	if ok {
+		// A lost ack only means a redelivery.
+		_ = msg.Ack()
+	}
+}
+
+// Pause stops fetching the whole topic; JetStream streams have no
+// partitions.
+func (s *natsSubscription) Pause(topic string, _ int32) {
+	s.mu.Lock()
+	s.paused[topic] = true
+	s.mu.Unlock()
+}
+
+func (s *natsSubscription) ResumeAll() {
+	s.mu.Lock()
+	s.paused = map[string]bool{}
+	s.mu.Unlock()
+}
+
+// Joined checks the group's durable consumers still exist on the server.
+func (s *natsSubscription) Joined(ctx context.Context) error {
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+	for topic, c := range s.consumers {
+		if _, err := c.Info(ctx); err != nil {
+			return fmt.Errorf("consumer on %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// Close stops polling. The durable consumer stays, so unacked messages are
+// redelivered to the group once their AckWait passes.
+func (s *natsSubscription) Close(context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.stop)
+	}
+}
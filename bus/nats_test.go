@@ -0,0 +1,203 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/exploravis/config"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// newTestNATS connects a bus to an in-process JetStream server.
+func newTestNATS(t *testing.T, ackWait time.Duration) *NATS {
+	t.Helper()
+	srv, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+	t.Cleanup(srv.Shutdown)
+	if !srv.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	b, err := NewNATS(config.NATS{URL: srv.ClientURL(), MaxAge: time.Hour, AckWait: ackWait})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Close)
+	return b
+}
+
+func natsPublish(t *testing.T, b *NATS, rec *kgo.Record) {
+	t.Helper()
+	errc := make(chan error, 1)
+	b.Publish(context.Background(), rec, func(_ *kgo.Record, err error) { errc <- err })
+	if err := <-errc; err != nil {
+		t.Fatalf("publish %q: %v", rec.Value, err)
+	}
+}
+
+// natsPoll polls for up to wait and returns what came, which may be nothing.
+func natsPoll(t *testing.T, sub Subscription, wait time.Duration) []*kgo.Record {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+	recs, err := sub.Poll(ctx, 0)
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("poll: %v", err)
+	}
+	return recs
+}
+
+// A record held longer than AckWait stays with its member; it only goes to
+// another once that member closes without acking it.
+func TestNATSKeepsPolledRecords(t *testing.T) {
+	b := newTestNATS(t, time.Second)
+	holder, err := b.Subscribe("g", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := b.Subscribe("g", "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close(context.Background())
+	natsPublish(t, b, &kgo.Record{Topic: "jobs", Value: []byte("long scan")})
+
+	if recs := natsPoll(t, holder, 3*time.Second); len(recs) != 1 {
+		t.Fatalf("holder got %d records", len(recs))
+	}
+	if recs := natsPoll(t, other, 3*time.Second); len(recs) != 0 {
+		t.Fatalf("redelivered %q while its holder was still working on it", recs[0].Value)
+	}
+
+	holder.Close(context.Background())
+	recs := natsPoll(t, other, 5*time.Second)
+	if len(recs) != 1 || string(recs[0].Value) != "long scan" {
+		t.Fatalf("after the holder closed, got %v", recs)
+	}
+	other.Ack(recs[0])
+}
+
+// Publish creates the stream on first use, and a record comes back from it
+// with its key and headers.
+func TestNATSPublishAndAck(t *testing.T) {
+	b := newTestNATS(t, time.Minute)
+	sub, err := b.Subscribe("g", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close(context.Background())
+	other, err := b.Subscribe("other", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close(context.Background())
+
+	sent := &kgo.Record{Topic: "results", Key: []byte("10.0.0.1"), Value: []byte("v1"), Headers: []kgo.RecordHeader{
+		{Key: "traceparent", Value: []byte("00-abc")},
+		{Key: "dlq.reason", Value: []byte("process")},
+	}}
+	natsPublish(t, b, sent)
+	if sent.Offset != 1 {
+		t.Errorf("published at sequence %d, want 1", sent.Offset)
+	}
+	natsPublish(t, b, &kgo.Record{Topic: "results", Value: []byte("v2")})
+
+	recs := natsPoll(t, sub, 5*time.Second)
+	if len(recs) != 2 {
+		t.Fatalf("got %d records, want 2", len(recs))
+	}
+	got := recs[0]
+	if got.Topic != "results" || string(got.Key) != "10.0.0.1" || string(got.Value) != "v1" || got.Offset != 1 {
+		t.Errorf("got %+v", got)
+	}
+	headers := map[string]string{}
+	for _, h := range got.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	if len(headers) != 2 || headers["traceparent"] != "00-abc" || headers["dlq.reason"] != "process" {
+		t.Errorf("headers = %v; the key travels outside them", headers)
+	}
+	if recs[1].Key != nil {
+		t.Errorf("unkeyed record came back with key %q", recs[1].Key)
+	}
+	for _, rec := range recs {
+		sub.Ack(rec)
+	}
+
+	// Another group gets every record too.
+	if recs := natsPoll(t, other, 5*time.Second); len(recs) != 2 {
+		t.Errorf("other group got %d records", len(recs))
+	}
+
+	// Acked records are not redelivered to the group, even to a new member.
+	sub.Close(context.Background())
+	again, err := b.Subscribe("g", "results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close(context.Background())
+	if recs := natsPoll(t, again, 2*time.Second); len(recs) != 0 {
+		t.Errorf("acked records redelivered: %d", len(recs))
+	}
+	if err := again.Joined(context.Background()); err != nil {
+		t.Errorf("Joined = %v", err)
+	}
+	if err := b.Ping(context.Background()); err != nil {
+		t.Errorf("Ping = %v", err)
+	}
+}
+
+func TestNATSRead(t *testing.T) {
+	b := newTestNATS(t, time.Minute)
+	for i := 1; i <= 5; i++ {
+		natsPublish(t, b, &kgo.Record{Topic: "enrich_dlq", Value: []byte(fmt.Sprint(i))})
+	}
+
+	read := func(from int64, stopAfter int) string {
+		t.Helper()
+		var got []string
+		err := b.Read(context.Background(), "enrich_dlq", from, func(rec *kgo.Record) bool {
+			got = append(got, fmt.Sprintf("%d:%s", rec.Offset, rec.Value))
+			// Written while reading: past the end seen at the start.
+			natsPublish(t, b, &kgo.Record{Topic: "enrich_dlq", Value: []byte("late")})
+			return stopAfter <= 0 || len(got) < stopAfter
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fmt.Sprint(got)
+	}
+	if got := read(0, 0); got != "[1:1 2:2 3:3 4:4 5:5]" {
+		t.Errorf("read all = %s", got)
+	}
+	if got := read(4, 0); got != "[4:4 5:5 6:late 7:late 8:late 9:late 10:late]" {
+		t.Errorf("read from 4 = %s", got)
+	}
+	if got := read(2, 2); got != "[2:2 3:3]" {
+		t.Errorf("read stopped after two = %s", got)
+	}
+	if got := read(1000, 0); got != "[]" {
+		t.Errorf("read past the end = %s", got)
+	}
+
+	err := b.Read(context.Background(), "missing_dlq", 0, func(*kgo.Record) bool {
+		t.Error("read a record of a stream that does not exist")
+		return true
+	})
+	if err != nil {
+		t.Errorf("Read of a missing stream = %v", err)
+	}
+}
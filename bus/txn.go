@@ -0,0 +1,187 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Txn is a Kafka group subscription that consumes, publishes and commits in
+// transactions, so a batch's output and its offsets land together or not at
+// all. Every Poll that returns records begins a transaction; End finishes it
+// before the next Poll.
+//
+// Publishing happens within the transaction, so it must only be done between
+// a Poll and its End. Consumers of the output should read committed records
+// only, as Subscribe does.
+type Txn struct {
+	s *kgo.GroupTransactSession
+
+	mu     sync.Mutex
+	open   bool
+	batch  map[*kgo.Record]bool // polled and not yet acked
+	acked  chan struct{}        // closed when batch empties
+	failed error                // first publish failure in the transaction
+}
+
+// txnEndTimeout bounds committing or aborting, which End must not give up
+// on halfway.
+const txnEndTimeout = 30 * time.Second
+
+// Transact joins group as a transactional consumer of topics. txnID must be
+// unique to this process; the group's generation fences off zombies, so it
+// need not be stable across restarts.
+func (b *Kafka) Transact(txnID, group string, topics ...string) (*Txn, error) {
+	opts, err := b.k.ClientOpts()
+	if err != nil {
+		return nil, err
+	}
+	s, err := kgo.NewGroupTransactSession(append(opts,
+		kgo.DialTimeout(5*time.Second),
+		kgo.TransactionalID(txnID),
+		kgo.RecordPartitioner(spreadPartitioner{}),
+		kgo.ConsumeTopics(topics...),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.RequireStableFetchOffsets(),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Txn{s: s}, nil
+}
+
+func (t *Txn) Poll(ctx context.Context, max int) ([]*kgo.Record, error) {
+	t.mu.Lock()
+	open := t.open
+	t.mu.Unlock()
+	if open {
+		return nil, errors.New("bus: poll with a transaction still open")
+	}
+
+	fetches := t.s.PollRecords(ctx, max)
+	if fetches.IsClientClosed() {
+		return nil, ErrClosed
+	}
+	var errs []error
+	fetches.EachError(func(_ string, _ int32, err error) {
+		errs = append(errs, err)
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	recs := fetches.Records()
+	if len(recs) == 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := t.s.Begin(); err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.open = true
+	t.failed = nil
+	t.batch = make(map[*kgo.Record]bool, len(recs))
+	for _, rec := range recs {
+		t.batch[rec] = true
+	}
+	t.acked = make(chan struct{})
+	t.mu.Unlock()
+	return recs, errors.Join(errs...)
+}
+
+// Ack marks a record of the open transaction processed. Its offset is
+// committed by End, with the rest of the batch.
+func (t *Txn) Ack(rec *kgo.Record) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.batch[rec] {
+		return
+	}
+	delete(t.batch, rec)
+	if len(t.batch) == 0 {
+		close(t.acked)
+	}
+}
+
+// Publish produces rec in the open transaction. A failure dooms the
+// transaction: End aborts it.
+func (t *Txn) Publish(ctx context.Context, rec *kgo.Record, done func(*kgo.Record, error)) {
+	t.s.Produce(ctx, rec, func(rec *kgo.Record, err error) {
+		if err != nil {
+			t.mu.Lock()
+			if t.failed == nil {
+				t.failed = err
+			}
+			t.mu.Unlock()
+		}
+		done(rec, err)
+	})
+}
+
+func (t *Txn) Flush(ctx context.Context) error {
+	return t.s.Client().Flush(ctx)
+}
+
+// End finishes the open transaction. With commit, it first waits for every
+// record of the batch to be acked, or ctx to be done, and commits if they all
+// were and nothing failed to publish. Otherwise it aborts, and the batch is
+// polled again. It reports whether the transaction committed; an error means
+// the session is unusable.
+func (t *Txn) End(ctx context.Context, commit bool) (bool, error) {
+	t.mu.Lock()
+	open, acked := t.open, t.acked
+	t.mu.Unlock()
+	if !open {
+		return false, nil
+	}
+
+	if commit {
+		select {
+		case <-acked:
+		case <-ctx.Done():
+			commit = false
+		}
+	}
+	t.mu.Lock()
+	if t.failed != nil {
+		commit = false
+	}
+	t.open = false
+	t.batch = nil
+	t.mu.Unlock()
+
+	endCtx, cancel := context.WithTimeout(context.Background(), txnEndTimeout)
+	defer cancel()
+	return t.s.End(endCtx, kgo.TransactionEndTry(commit))
+}
+
+func (t *Txn) Pause(topic string, partition int32) {
+	t.s.Client().PauseFetchPartitions(map[string][]int32{topic: {partition}})
+}
+
+func (t *Txn) ResumeAll() {
+	cl := t.s.Client()
+	cl.ResumeFetchPartitions(cl.PauseFetchPartitions(nil))
+}
+
+func (t *Txn) Joined(context.Context) error {
+	return groupJoined(t.s.Client())
+}
+
+// Close aborts a transaction still open and leaves the group. Offsets are
+// only ever committed by End.
+func (t *Txn) Close(ctx context.Context) {
+	if _, err := t.End(ctx, false); err != nil {
+		log.Printf("[WARN] Aborting open transaction failed: %v", err)
+	}
+	if err := t.s.Client().LeaveGroupContext(ctx); err != nil {
+		log.Printf("[WARN] Leaving consumer group failed: %v", err)
+	}
+	t.s.Close()
+}
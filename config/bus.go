@@ -0,0 +1,60 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Bus selects the message broker the stages talk through. The Kafka section
+// is only used when Kind is kafka.
+type Bus struct {
+	Kind string `yaml:"kind" env:"BUS" default:"kafka" usage:"message bus: kafka or nats"`
+	NATS NATS   `yaml:"nats"`
+}
+
+// Validate rejects the in-memory bus along with unknown kinds: every binary
+// that reads this section runs one stage, and a bus private to its process
+// would never carry anything to or from the others.
+func (b *Bus) Validate() error {
+	switch b.Kind {
+	case "kafka", "nats":
+		return nil
+	case "memory":
+		return errors.New("bus.kind memory only connects stages within one process; use kafka or nats")
+	}
+	return fmt.Errorf("bus.kind %q is not kafka or nats", b.Kind)
+}
+
+// ValidateTxn rejects kafka.txn.exactly_once on a bus without transactions.
+// Stages that support exactly-once call it from their own Validate.
+func (b *Bus) ValidateTxn(k Kafka) error {
+	if k.Txn.ExactlyOnce && b.Kind != "kafka" {
+		return fmt.Errorf("kafka.txn.exactly_once needs bus.kind kafka, not %s", b.Kind)
+	}
+	return nil
+}
+
+// NATS is the JetStream connection used when bus.kind is nats. Each topic
+// becomes a stream of the same name, created on first use.
+type NATS struct {
+	URL       string        `yaml:"url" env:"NATS_URL" default:"nats://nats.nats.svc.cluster.local:4222" usage:"comma-separated NATS server URLs"`
+	CredsFile string        `yaml:"creds_file" env:"NATS_CREDS_FILE" usage:"NATS credentials file (JWT and NKey seed)"`
+	Token     string        `yaml:"token" env:"NATS_TOKEN" usage:"NATS auth token" secret:"true"`
+	MaxAge    time.Duration `yaml:"max_age" env:"NATS_STREAM_MAX_AGE" default:"168h" usage:"how long streams created by the bus keep messages"`
+	AckWait   time.Duration `yaml:"ack_wait" env:"NATS_ACK_WAIT" default:"5m" usage:"redeliver a message not acked within this long once the member holding it is gone"`
+}
+
+func (n *NATS) Validate() error {
+	var errs []error
+	if n.URL == "" {
+		errs = append(errs, errors.New("bus.nats.url is required"))
+	}
+	if n.CredsFile != "" && n.Token != "" {
+		errs = append(errs, errors.New("bus.nats.creds_file and bus.nats.token are mutually exclusive"))
+	}
+	if n.MaxAge <= 0 || n.AckWait <= 0 {
+		errs = append(errs, errors.New("bus.nats.max_age and bus.nats.ack_wait must be positive"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,349 @@
+// Package config loads the settings of every exploravis binary the same way.
+//
+// A binary describes its settings as a struct. Each leaf field is named by
+// the path of its yaml tags (kafka.seeds) and is filled, in increasing
+// precedence, from:
+//
+//  1. the value already in the struct when Load is called, or else its
+//     `default` tag, so a binary can default a shared section differently;
+//  2. the YAML file given by --config or CONFIG_FILE, if any;
+//  3. the environment variables in its `env` tag, first one set wins;
+//  4. the command-line flag named after its path (--kafka.seeds).
+//
+// Supported field types are string, bool, int, int64, float64,
+// time.Duration and []string (comma-separated outside YAML). After loading,
+// `required:"true"` fields must be non-empty and every struct implementing
+// Validator is checked. --print-config prints the result as YAML and exits,
+// with `secret:"true"` fields redacted.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Validator is implemented by config structs (or sections of them) that
+// need checks beyond required fields.
+type Validator interface {
+	Validate() error
+}
+
+// field is one leaf setting of a config struct.
+type field struct {
+	path     string
+	env      []string
+	def      string
+	usage    string
+	required bool
+	secret   bool
+	value    reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func fields(v reflect.Value, prefix string) ([]field, error) {
+	var out []field
+	t := v.Type()
+	for i := range t.NumField() {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(sf.Name)
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+			sub, err := fields(fv, path)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, sub...)
+			continue
+		}
+		if !supported(fv) {
+			return nil, fmt.Errorf("config: %s has unsupported type %s", path, fv.Type())
+		}
+
+		f := field{
+			path:     path,
+			def:      sf.Tag.Get("default"),
+			usage:    sf.Tag.Get("usage"),
+			required: sf.Tag.Get("required") == "true",
+			secret:   sf.Tag.Get("secret") == "true",
+			value:    fv,
+		}
+		if env := sf.Tag.Get("env"); env != "" {
+			f.env = strings.Split(env, ",")
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
+
+func supported(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return v.Type().Elem().Kind() == reflect.String
+	}
+	return false
+}
+
+func set(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(s, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	}
+	return nil
+}
+
+func format(v reflect.Value) string {
+	if v.Type() == durationType {
+		return time.Duration(v.Int()).String()
+	}
+	if v.Kind() == reflect.Slice {
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// rawFlag only records what was passed; values are applied after the YAML
+// file and environment so flags win regardless of parse order.
+type rawFlag struct {
+	set    bool
+	val    string
+	secret bool
+	isBool bool
+}
+
+func (r *rawFlag) String() string {
+	if r.secret && r.val != "" {
+		return redacted
+	}
+	return r.val
+}
+
+func (r *rawFlag) Set(s string) error {
+	r.set, r.val = true, s
+	return nil
+}
+
+// IsBoolFlag lets bool settings be passed as a bare --flag.
+func (r *rawFlag) IsBoolFlag() bool { return r.isBool }
+
+// Load fills cfg, a pointer to a config struct, from args (usually
+// os.Args[1:]) and the environment. It returns flag.ErrHelp after printing
+// usage for -h. --print-config prints the loaded config to stdout and exits.
+func Load(name string, cfg any, args []string) error {
+	rv := reflect.ValueOf(cfg)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("config: Load needs a pointer to a struct")
+	}
+	fs, err := fields(rv.Elem(), "")
+	if err != nil {
+		return err
+	}
+
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML config file (env CONFIG_FILE)")
+	printConfig := flags.Bool("print-config", false, "print the effective config as YAML and exit")
+	raw := make([]*rawFlag, len(fs))
+	for i, f := range fs {
+		if !f.value.IsZero() {
+			fs[i].def = format(f.value)
+		} else if f.def != "" {
+			if err := set(f.value, f.def); err != nil {
+				return fmt.Errorf("config: bad default for %s: %w", f.path, err)
+			}
+		}
+		raw[i] = &rawFlag{val: fs[i].def, secret: f.secret, isBool: f.value.Kind() == reflect.Bool}
+		flags.Var(raw[i], f.path, describe(f))
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(*configFile, cfg); err != nil {
+			return err
+		}
+	}
+	for _, f := range fs {
+		for _, env := range f.env {
+			if s, ok := os.LookupEnv(env); ok && s != "" {
+				if err := set(f.value, s); err != nil {
+					return fmt.Errorf("config: %s=%q: %w", env, s, err)
+				}
+				break
+			}
+		}
+	}
+	for i, f := range fs {
+		if raw[i].set {
+			if err := set(f.value, raw[i].val); err != nil {
+				return fmt.Errorf("config: --%s=%q: %w", f.path, raw[i].val, err)
+			}
+		}
+	}
+
+	if err := validate(rv.Elem(), fs); err != nil {
+		return err
+	}
+	if *printConfig {
+		if err := Print(os.Stdout, name, cfg); err != nil {
+			return err
+		}
+		os.Exit(0)
+	}
+	return nil
+}
+
+func describe(f field) string {
+	usage := f.usage
+	if len(f.env) > 0 {
+		usage += " (env " + strings.Join(f.env, ", ") + ")"
+	}
+	if f.required {
+		usage += " (required)"
+	}
+	return strings.TrimSpace(usage)
+}
+
+func loadFile(path string, cfg any) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+func validate(v reflect.Value, fs []field) error {
+	var errs []error
+	for _, f := range fs {
+		if f.required && f.value.IsZero() {
+			errs = append(errs, fmt.Errorf("%s is required", f.path))
+		}
+	}
+	walkValidators(v, func(val Validator) {
+		if err := val.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	})
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("config: invalid:\n%w", err)
+	}
+	return nil
+}
+
+// walkValidators calls fn for v and every nested struct that implements
+// Validator, innermost first.
+func walkValidators(v reflect.Value, fn func(Validator)) {
+	for i := range v.NumField() {
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct && fv.Type() != durationType && v.Type().Field(i).IsExported() {
+			walkValidators(fv, fn)
+		}
+	}
+	if v.CanAddr() {
+		if val, ok := v.Addr().Interface().(Validator); ok {
+			fn(val)
+		}
+	}
+}
+
+const redacted = "<redacted>"
+
+// Print writes cfg as YAML, in the same shape --config accepts, with secret
+// fields redacted.
+func Print(w io.Writer, name string, cfg any) error {
+	cp := reflect.New(reflect.TypeOf(cfg).Elem())
+	cp.Elem().Set(reflect.ValueOf(cfg).Elem())
+	fs, err := fields(cp.Elem(), "")
+	if err != nil {
+		return err
+	}
+	for _, f := range fs {
+		if f.secret && !f.value.IsZero() && f.value.Kind() == reflect.String {
+			f.value.SetString(redacted)
+		}
+	}
+
+	b, err := yaml.Marshal(cp.Interface())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "# effective config for %s\n%s", name, b)
+	return err
+}
+
+// MustLoad is Load for main: it exits on error.
+func MustLoad(name string, cfg any) {
+	err := Load(name, cfg, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,201 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Name    string        `yaml:"name" env:"TEST_NAME,TEST_LEGACY_NAME" default:"def" usage:"a name"`
+	Count   int           `yaml:"count" env:"TEST_COUNT" default:"1"`
+	Big     int64         `yaml:"big"`
+	Ratio   float64       `yaml:"ratio" default:"0.5"`
+	On      bool          `yaml:"on"`
+	Wait    time.Duration `yaml:"wait" default:"5s"`
+	Seeds   []string      `yaml:"seeds" env:"TEST_SEEDS"`
+	Section testSection   `yaml:"section"`
+	Token   string        `yaml:"token" secret:"true"`
+	Skipped string        `yaml:"-"`
+	hidden  string
+}
+
+type testSection struct {
+	Topic string `yaml:"topic" required:"true" default:"t"`
+	Level int    `yaml:"level"`
+}
+
+func (s *testSection) Validate() error {
+	if s.Level < 0 {
+		return errors.New("section.level must not be negative")
+	}
+	return nil
+}
+
+func writeFile(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := writeFile(t, "name: file\ncount: 2\nsection:\n  level: 3\n")
+	tests := []struct {
+		name   string
+		preset string
+		env    map[string]string
+		args   []string
+		want   string
+	}{
+		{name: "default tag", want: "def"},
+		{name: "preset beats default", preset: "preset", want: "preset"},
+		{name: "file beats preset", preset: "preset", args: []string{"--config", file}, want: "file"},
+		{name: "CONFIG_FILE", env: map[string]string{"CONFIG_FILE": file}, want: "file"},
+		{name: "env beats file", env: map[string]string{"TEST_NAME": "env"}, args: []string{"--config", file}, want: "env"},
+		{name: "first env set wins", env: map[string]string{"TEST_LEGACY_NAME": "legacy"}, want: "legacy"},
+		{name: "empty env is unset", env: map[string]string{"TEST_NAME": "", "TEST_LEGACY_NAME": "legacy"}, want: "legacy"},
+		{name: "flag beats env", env: map[string]string{"TEST_NAME": "env"}, args: []string{"--name=flag"}, want: "flag"},
+		{name: "flag before --config still wins", args: []string{"--name=flag", "--config", file}, want: "flag"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			cfg := testConfig{Name: tt.preset}
+			if err := Load("test", &cfg, tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Name != tt.want {
+				t.Errorf("name = %q, want %q", cfg.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadTypes(t *testing.T) {
+	t.Setenv("TEST_SEEDS", " a:9092, ,b:9092 ")
+	var cfg testConfig
+	err := Load("test", &cfg, []string{
+		"--count=7", "--big=9000000000", "--ratio=0.25", "--on", "--wait=1m30s", "--section.topic=scans",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{
+		Name:    "def",
+		Count:   7,
+		Big:     9000000000,
+		Ratio:   0.25,
+		On:      true,
+		Wait:    90 * time.Second,
+		Seeds:   []string{"a:9092", "b:9092"},
+		Section: testSection{Topic: "scans"},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("loaded %+v, want %+v", cfg, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  any
+		env  map[string]string
+		args []string
+		file string
+		want string
+	}{
+		{name: "not a pointer", cfg: testConfig{}, want: "pointer to a struct"},
+		{name: "unsupported type", cfg: &struct {
+			M map[string]string `yaml:"m"`
+		}{}, want: "m has unsupported type"},
+		{name: "bad flag value", cfg: &testConfig{}, args: []string{"--count=many"}, want: `--count="many"`},
+		{name: "bad env value", cfg: &testConfig{}, env: map[string]string{"TEST_COUNT": "x"}, want: `TEST_COUNT="x"`},
+		{name: "unknown flag", cfg: &testConfig{}, args: []string{"--nope"}, want: "not defined"},
+		{name: "missing file", cfg: &testConfig{}, args: []string{"--config", "/nonexistent.yaml"}, want: "no such file"},
+		{name: "unknown yaml key", cfg: &testConfig{}, file: "typo: 1\n", want: "field typo not found"},
+		{name: "required", cfg: &testConfig{}, args: []string{"--section.topic="}, want: "section.topic is required"},
+		{name: "validator", cfg: &testConfig{}, args: []string{"--section.level=-1"}, want: "section.level must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append(args, "--config", writeFile(t, tt.file))
+			}
+			err := Load("test", tt.cfg, args)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadEmptyFile(t *testing.T) {
+	var cfg testConfig
+	if err := Load("test", &cfg, []string{"--config", writeFile(t, "")}); err != nil {
+		t.Fatalf("empty file: %v", err)
+	}
+	if cfg.Name != "def" {
+		t.Errorf("name = %q, want the default", cfg.Name)
+	}
+}
+
+func TestPrintRedactsSecrets(t *testing.T) {
+	cfg := testConfig{Name: "n", Token: "hunter2", Section: testSection{Topic: "t"}}
+	var buf bytes.Buffer
+	if err := Print(&buf, "test", &cfg); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "hunter2") || !strings.Contains(out, "token: "+redacted) {
+		t.Errorf("secret not redacted:\n%s", out)
+	}
+	if !strings.HasPrefix(out, "# effective config for test\n") || !strings.Contains(out, "topic: t") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+	if cfg.Token != "hunter2" {
+		t.Error("Print changed the config it printed")
+	}
+}
+
+func TestSectionValidators(t *testing.T) {
+	tests := []struct {
+		name string
+		v    Validator
+		ok   bool
+	}{
+		{"json producer", &Producer{Encoding: "json"}, true},
+		{"protobuf producer", &Producer{Encoding: "protobuf"}, true},
+		{"unknown encoding", &Producer{Encoding: "avro"}, false},
+		{"workers", &Workers{Count: 1, QueueSize: 1}, true},
+		{"no workers", &Workers{Count: 0, QueueSize: 1}, false},
+		{"runtime", &Runtime{ShutdownTimeout: time.Second, StallTimeout: time.Second}, true},
+		{"no shutdown timeout", &Runtime{StallTimeout: time.Second}, false},
+		{"tracing", &Tracing{Exporter: "file", SampleRatio: 1}, true},
+		{"bad exporter", &Tracing{Exporter: "zipkin"}, false},
+		{"bad ratio", &Tracing{Exporter: "none", SampleRatio: 2}, false},
+		{"kafka bus", &Bus{Kind: "kafka"}, true},
+		{"memory bus", &Bus{Kind: "memory"}, false},
+		{"bad bus", &Bus{Kind: "rabbitmq"}, false},
+		{"nats", &NATS{URL: "nats://x", MaxAge: time.Hour, AckWait: time.Minute}, true},
+		{"nats with creds and token", &NATS{URL: "nats://x", CredsFile: "c", Token: "t", MaxAge: time.Hour, AckWait: time.Minute}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.v.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: Validate() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// Kafka is the broker connection shared by every binary. KAFKA_BROKER is
+// still honoured for the orchestrator's older deployments.
+type Kafka struct {
+	Seeds []string  `yaml:"seeds" env:"KAFKA_SEEDS,KAFKA_BROKER" default:"redpanda-0.redpanda.kafka.svc.cluster.local:9093" usage:"comma-separated seed brokers"`
+	TLS   KafkaTLS  `yaml:"tls"`
+	SASL  KafkaSASL `yaml:"sasl"`
+	Txn   KafkaTxn  `yaml:"txn"`
+}
+
+// KafkaTLS encrypts broker connections. Setting any file turns it on.
+type KafkaTLS struct {
+	Enabled            bool   `yaml:"enabled" env:"KAFKA_TLS" usage:"connect to brokers over TLS"`
+	CAFile             string `yaml:"ca_file" env:"KAFKA_TLS_CA_FILE" usage:"PEM CA bundle to verify brokers with (default system roots)"`
+	CertFile           string `yaml:"cert_file" env:"KAFKA_TLS_CERT_FILE" usage:"PEM client certificate for mutual TLS"`
+	KeyFile            string `yaml:"key_file" env:"KAFKA_TLS_KEY_FILE" usage:"PEM client key for mutual TLS"`
+	ServerName         string `yaml:"server_name" env:"KAFKA_TLS_SERVER_NAME" usage:"name to verify broker certificates against (default the broker host)"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" env:"KAFKA_TLS_INSECURE_SKIP_VERIFY" usage:"do not verify broker certificates"`
+}
+
+func (t *KafkaTLS) on() bool {
+	return t.Enabled || t.CAFile != "" || t.CertFile != ""
+}
+
+// KafkaSASL authenticates to the brokers.
+type KafkaSASL struct {
+	Mechanism string `yaml:"mechanism" env:"KAFKA_SASL_MECHANISM" usage:"SASL mechanism: PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512 (empty disables SASL)"`
+	Username  string `yaml:"username" env:"KAFKA_SASL_USERNAME" usage:"SASL username"`
+	Password  string `yaml:"password" env:"KAFKA_SASL_PASSWORD" usage:"SASL password" secret:"true"`
+}
+
+// KafkaTxn makes a stage exactly-once: it consumes, produces and commits its
+// offsets in Kafka transactions. Only stages that support it read these.
+type KafkaTxn struct {
+	ExactlyOnce bool   `yaml:"exactly_once" env:"KAFKA_EXACTLY_ONCE" usage:"process each record exactly once using Kafka transactions (kafka bus only)"`
+	IDPrefix    string `yaml:"id_prefix" env:"KAFKA_TRANSACTIONAL_ID_PREFIX" usage:"transactional id prefix; the host name is appended (default the stage name)"`
+}
+
+// ID returns the transactional id for stage: the prefix and the host name,
+// which is unique per pod.
+func (t *KafkaTxn) ID(stage string) string {
+	prefix := t.IDPrefix
+	if prefix == "" {
+		prefix = stage
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = fmt.Sprint(os.Getpid())
+	}
+	return prefix + "-" + host
+}
+
+func (k *Kafka) Validate() error {
+	var errs []error
+	if len(k.Seeds) == 0 {
+		errs = append(errs, errors.New("kafka.seeds must list at least one broker"))
+	}
+	if (k.TLS.CertFile == "") != (k.TLS.KeyFile == "") {
+		errs = append(errs, errors.New("kafka.tls.cert_file and kafka.tls.key_file must be set together"))
+	}
+	switch strings.ToUpper(k.SASL.Mechanism) {
+	case "":
+	case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+		if k.SASL.Username == "" || k.SASL.Password == "" {
+			errs = append(errs, errors.New("kafka.sasl.username and kafka.sasl.password are required with a SASL mechanism"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("kafka.sasl.mechanism %q is not PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512", k.SASL.Mechanism))
+	}
+	return errors.Join(errs...)
+}
+
+// ClientOpts returns the seed, TLS and SASL options for a kgo client. Every
+// Kafka client in the project starts from these.
+func (k *Kafka) ClientOpts() ([]kgo.Opt, error) {
+	opts := []kgo.Opt{kgo.SeedBrokers(k.Seeds...)}
+
+	if k.TLS.on() {
+		tc, err := k.TLS.config()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, kgo.DialTLSConfig(tc))
+	}
+
+	plainAuth := plain.Auth{User: k.SASL.Username, Pass: k.SASL.Password}
+	scramAuth := scram.Auth{User: k.SASL.Username, Pass: k.SASL.Password}
+	switch strings.ToUpper(k.SASL.Mechanism) {
+	case "PLAIN":
+		opts = append(opts, kgo.SASL(plainAuth.AsMechanism()))
+	case "SCRAM-SHA-256":
+		opts = append(opts, kgo.SASL(scramAuth.AsSha256Mechanism()))
+	case "SCRAM-SHA-512":
+		opts = append(opts, kgo.SASL(scramAuth.AsSha512Mechanism()))
+	}
+	return opts, nil
+}
+
+func (t *KafkaTLS) config() (*tls.Config, error) {
+	tc := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CAFile != "" {
+		pem, err := os.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka.tls.ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("kafka.tls.ca_file: no certificates in %s", t.CAFile)
+		}
+		tc.RootCAs = pool
+	}
+	if t.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("kafka.tls: loading client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
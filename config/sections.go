@@ -0,0 +1,93 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Elastic is the Elasticsearch connection.
+type Elastic struct {
+	URL string `yaml:"url" env:"ELASTIC_URL" default:"http://elasticsearch-cluster-master.elasticsearch.svc:9200" usage:"Elasticsearch URL" required:"true"`
+}
+
+// Consumer is a worker's input topic and group.
+type Consumer struct {
+	Topic string `yaml:"topic" usage:"topic to consume" required:"true"`
+	Group string `yaml:"group" usage:"consumer group" required:"true"`
+}
+
+// Producer is a stage's output topic and wire encoding.
+type Producer struct {
+	Topic    string `yaml:"topic" usage:"topic to produce to" required:"true"`
+	Encoding string `yaml:"encoding" env:"WIRE_ENCODING" default:"json" usage:"wire encoding of produced messages: json or protobuf"`
+}
+
+func (p *Producer) Validate() error {
+	switch p.Encoding {
+	case "json", "protobuf", "proto":
+		return nil
+	}
+	return fmt.Errorf("producer.encoding %q is not json or protobuf", p.Encoding)
+}
+
+// Control is the topic stages report their progress on.
+type Control struct {
+	Topic string `yaml:"topic" env:"CONTROL_TOPIC" default:"scan_control" usage:"topic chunk-complete events are published to" required:"true"`
+}
+
+// Workers sizes a worker's goroutine pool and in-memory job queue.
+type Workers struct {
+	Count     int `yaml:"count" env:"WORKER_COUNT" default:"8" usage:"worker goroutines"`
+	QueueSize int `yaml:"queue_size" env:"QUEUE_SIZE" default:"2000" usage:"in-memory job queue size"`
+}
+
+func (w *Workers) Validate() error {
+	var errs []error
+	if w.Count < 1 {
+		errs = append(errs, errors.New("workers.count must be at least 1"))
+	}
+	if w.QueueSize < 1 {
+		errs = append(errs, errors.New("workers.queue_size must be at least 1"))
+	}
+	return errors.Join(errs...)
+}
+
+// Runtime holds the process-level knobs every worker shares.
+type Runtime struct {
+	MetricsAddr     string        `yaml:"metrics_addr" env:"METRICS_ADDR" default:":2112" usage:"listen address for /metrics and the /healthz and /readyz probes"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"25s" usage:"drain deadline after SIGTERM"`
+	StallTimeout    time.Duration `yaml:"stall_timeout" env:"STALL_TIMEOUT" default:"2m" usage:"fail /healthz when the poll loop makes no progress for this long"`
+}
+
+func (r *Runtime) Validate() error {
+	if r.ShutdownTimeout <= 0 {
+		return errors.New("runtime.shutdown_timeout must be positive")
+	}
+	if r.StallTimeout <= 0 {
+		return errors.New("runtime.stall_timeout must be positive")
+	}
+	return nil
+}
+
+// Tracing selects where OpenTelemetry spans are exported. The OTLP exporter
+// takes its endpoint and headers from the standard OTEL_EXPORTER_OTLP_*
+// variables. Trace context is propagated through Kafka either way.
+type Tracing struct {
+	Exporter    string  `yaml:"exporter" env:"TRACE_EXPORTER" default:"none" usage:"span exporter: none, otlp or file"`
+	File        string  `yaml:"file" env:"TRACE_FILE" default:"traces.jsonl" usage:"file spans are appended to when exporter is file"`
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACE_SAMPLE_RATIO" default:"1" usage:"fraction of new traces sampled; traces started upstream follow their parent"`
+}
+
+func (t *Tracing) Validate() error {
+	var errs []error
+	switch t.Exporter {
+	case "none", "otlp", "file":
+	default:
+		errs = append(errs, fmt.Errorf("tracing.exporter %q is not none, otlp or file", t.Exporter))
+	}
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+	return errors.Join(errs...)
+}
@@ -1,5 +1,5 @@
 import axios from 'axios';
-import type { HealthResponse } from '../types/health';
+import type { HealthResponse, HealthSummary } from '../types/health';
 
 const API_URL: string = import.meta.env.VITE_API_URL || 'http://api.dev-exploravis.mywire.org';
 
@@ -9,4 +9,9 @@ export const healthApi = {
     return data;
   },
 
+  // Statuses only, for polling from the status page.
+  getHealthSummary: async (): Promise<HealthSummary> => {
+    const { data } = await axios.get(`${API_URL}/health`, { params: { summary: 1 } });
+    return data;
+  },
 };
@@ -5,6 +5,11 @@ export interface HealthResponse {
   status: 'healthy' | 'degraded' | 'down';
 }
 
+export interface HealthSummary {
+  status: 'ok' | 'degraded' | 'down';
+  components: Record<string, 'up' | 'down' | 'disabled' | 'ok' | 'degraded' | 'unknown'>;
+}
+
 export interface ElasticsearchHealth {
   active_primary_shards: number;
   active_shards: number;
@@ -46,15 +51,59 @@ export interface KubernetesHealth {
   message?: string;
   error?: string;
   nodes?: KubernetesNode[];
+  nodes_error?: string;
+  workloads?: KubernetesWorkload[];
+  workloads_error?: string;
   status: 'ok' | 'down' | 'error';
 }
 
 export interface KubernetesNode {
+  allocatable_cpu: string;
+  allocatable_memory: string;
+  architecture: string;
   capacity_cpu: string;
   capacity_memory: string;
+  created: string;
   external_ip: string;
   internal_ip: string;
+  kubelet_version: string;
   name: string;
-  node: any;
+  os_image: string;
+  pressure: string[];
+  roles: string[];
   status: 'True' | 'False';
+  unschedulable: boolean;
+}
+
+export interface KubernetesWorkload {
+  name: string;
+  status: 'ok' | 'degraded' | 'down';
+  desired: number;
+  ready: number;
+  available: number;
+  updated: number;
+  unavailable: number;
+  restarts: number;
+  pods: KubernetesPod[];
+  events: KubernetesWarning[];
+  error?: string;
+}
+
+export interface KubernetesPod {
+  name: string;
+  phase: string;
+  ready: boolean;
+  restarts: number;
+  node?: string;
+  waiting?: string;
+  last_termination?: string;
+  started?: string;
+}
+
+export interface KubernetesWarning {
+  object: string;
+  reason: string;
+  message: string;
+  count: number;
+  last: string;
 }
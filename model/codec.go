@@ -0,0 +1,122 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Encoding selects the wire format a producer writes. Consumers don't need
+// to be told: Unmarshal recognises both.
+type Encoding string
+
+const (
+	EncodingJSON     Encoding = "json"
+	EncodingProtobuf Encoding = "protobuf"
+)
+
+// ParseEncoding validates an encoding name; empty means JSON.
+func ParseEncoding(s string) (Encoding, error) {
+	switch Encoding(strings.ToLower(strings.TrimSpace(s))) {
+	case "", EncodingJSON:
+		return EncodingJSON, nil
+	case EncodingProtobuf, "proto":
+		return EncodingProtobuf, nil
+	}
+	return "", fmt.Errorf("unknown wire encoding %q", s)
+}
+
+// EncodingFromEnv reads WIRE_ENCODING, falling back to JSON.
+func EncodingFromEnv() Encoding {
+	enc, err := ParseEncoding(os.Getenv("WIRE_ENCODING"))
+	if err != nil {
+		return EncodingJSON
+	}
+	return enc
+}
+
+// Message is implemented by every pipeline message in this package.
+type Message interface {
+	setVersion()
+	version() int
+	upgrade(raw []byte) error
+	marshalProto() []byte
+	unmarshalProto(b []byte) error
+}
+
+// Marshal stamps v with SchemaVersion and encodes it.
+func Marshal(v Message, enc Encoding) ([]byte, error) {
+	v.setVersion()
+	switch enc {
+	case EncodingProtobuf:
+		return v.marshalProto(), nil
+	case EncodingJSON, "":
+		return json.Marshal(v)
+	}
+	return nil, fmt.Errorf("unknown wire encoding %q", enc)
+}
+
+// Unmarshal decodes JSON or protobuf into v and upgrades older schema
+// versions. JSON payloads always start with '{'; a protobuf message never
+// does, since that byte would be the deprecated group wire type.
+func Unmarshal(b []byte, v Message) error {
+	trimmed := bytes.TrimLeft(b, " \t\r\n")
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, v); err != nil {
+			return err
+		}
+		if v.version() < SchemaVersion {
+			return v.upgrade(trimmed)
+		}
+		return nil
+	}
+	// Protobuf was introduced with version 2, nothing to upgrade.
+	return v.unmarshalProto(b)
+}
+
+func (m *ScanRequest) setVersion()       { m.SchemaVersion = SchemaVersion }
+func (m *HostPorts) setVersion()         { m.SchemaVersion = SchemaVersion }
+func (m *ServiceScanResult) setVersion() { m.SchemaVersion = SchemaVersion }
+func (m *ChunkComplete) setVersion()     { m.SchemaVersion = SchemaVersion }
+
+func (m *ScanRequest) version() int       { return m.SchemaVersion }
+func (m *HostPorts) version() int         { return m.SchemaVersion }
+func (m *ServiceScanResult) version() int { return m.SchemaVersion }
+func (m *ChunkComplete) version() int     { return m.SchemaVersion }
+
+// Version 1 requests and host lists had the same fields, only unversioned.
+func (m *ScanRequest) upgrade([]byte) error {
+	m.SchemaVersion = SchemaVersion
+	return nil
+}
+
+func (m *HostPorts) upgrade([]byte) error {
+	m.SchemaVersion = SchemaVersion
+	return nil
+}
+
+// ChunkComplete was introduced with version 2.
+func (m *ChunkComplete) upgrade([]byte) error {
+	m.SchemaVersion = SchemaVersion
+	return nil
+}
+
+// Version 1 results came from per-worker copies of the struct: HTTPS
+// results reported the body size as http.body_len.
+func (m *ServiceScanResult) upgrade(raw []byte) error {
+	if m.HTTP != nil && m.HTTP.ContentLength == 0 {
+		var v1 struct {
+			HTTP struct {
+				BodyLen int `json:"body_len"`
+			} `json:"http"`
+		}
+		if err := json.Unmarshal(raw, &v1); err != nil {
+			return err
+		}
+		m.HTTP.ContentLength = v1.HTTP.BodyLen
+	}
+	m.SchemaVersion = SchemaVersion
+	return nil
+}
@@ -0,0 +1,445 @@
+package model
+
+import (
+	"bufio"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func fullResult() *ServiceScanResult {
+	return &ServiceScanResult{
+		ScanID:    "scan-1",
+		IP:        "192.0.2.10",
+		Port:      443,
+		Timestamp: 1700000000,
+		Protocol:  "https",
+		Service:   "nginx",
+		Banner:    "HTTP/1.1 200 OK",
+		TLS: &TLSInfo{
+			Version:            "TLS 1.3",
+			CipherSuite:        "TLS_AES_128_GCM_SHA256",
+			HandshakeOK:        true,
+			NegotiatedProtocol: "h2",
+			ALPN:               "h2",
+			Certificate: &Certificate{
+				Subject:   "CN=example.org",
+				Issuer:    "CN=Example CA",
+				DNSNames:  []string{"example.org", "www.example.org"},
+				Serial:    "0a1b",
+				NotBefore: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+				NotAfter:  time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+				SigAlg:    "SHA256-RSA",
+				PublicKey: "RSA 2048",
+			},
+		},
+		HTTP: &HTTPInfo{
+			StatusCode:    200,
+			StatusLine:    "200 OK",
+			Headers:       map[string]string{"Server": "nginx", "Content-Type": "text/html"},
+			Title:         "Welcome",
+			BodyPreview:   "<html>",
+			BodyHash:      "abc123",
+			ContentLength: 612,
+			Tags:          []string{"nginx", "default-page"},
+		},
+		SSH: &SSHInfo{
+			Version:            "SSH-2.0-OpenSSH_9.6",
+			KEXAlgorithms:      []string{"curve25519-sha256"},
+			ServerHostKeyAlgos: []string{"ssh-ed25519"},
+			EncAlgosC2S:        []string{"aes128-ctr"},
+			EncAlgosS2C:        []string{"aes128-ctr"},
+			MACAlgosC2S:        []string{"hmac-sha2-256"},
+			MACAlgosS2C:        []string{"hmac-sha2-256"},
+			CompressionC2S:     []string{"none"},
+			CompressionS2C:     []string{"none"},
+			LanguagesC2S:       []string{"en"},
+			LanguagesS2C:       []string{"en"},
+		},
+		RawTCP: "raw",
+		// JSON numbers decode as float64, so that is what meta holds.
+		Meta: map[string]any{
+			"geo": map[string]any{"country": "NL", "location": map[string]any{"lat": 52.37, "lon": 4.89}},
+			"asn": float64(64496),
+		},
+	}
+}
+
+// messages has every message type, fully populated and empty, and edge
+// values such as negative integers.
+func messages() map[string]func() Message {
+	return map[string]func() Message{
+		"scan request": func() Message {
+			return &ScanRequest{ScanID: "scan-1", IPRange: "192.0.2.0/24", Ports: "22,80,443"}
+		},
+		"empty scan request": func() Message { return &ScanRequest{} },
+		"host ports": func() Message {
+			return &HostPorts{ScanID: "scan-1", Host: "192.0.2.10", Ports: "22,443", Timestamp: 1700000000, Chunk: "192.0.2.0/24"}
+		},
+		"host ports without chunk": func() Message {
+			return &HostPorts{ScanID: "scan-1", Host: "192.0.2.10", Ports: "80"}
+		},
+		"chunk complete": func() Message {
+			return &ChunkComplete{
+				ScanID: "scan-1", Chunk: "192.0.2.0/24", Stage: "scanner",
+				HostsScanned: 256, HostsUp: 12, Ports: 30, Errors: 1,
+				Error: "context deadline exceeded", DurationMS: 61000, Timestamp: 1700000000,
+			}
+		},
+		"negative values": func() Message {
+			return &ChunkComplete{ScanID: "scan-1", Stage: "banner", Errors: -1, DurationMS: -5, Timestamp: -1}
+		},
+		"full result":  func() Message { return fullResult() },
+		"empty result": func() Message { return &ServiceScanResult{} },
+		"result with empty nested messages": func() Message {
+			return &ServiceScanResult{ScanID: "scan-1", TLS: &TLSInfo{Certificate: &Certificate{}}, HTTP: &HTTPInfo{}, SSH: &SSHInfo{}}
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, build := range messages() {
+		for _, enc := range []Encoding{EncodingJSON, EncodingProtobuf} {
+			t.Run(name+"/"+string(enc), func(t *testing.T) {
+				in := build()
+				b, err := Marshal(in, enc)
+				if err != nil {
+					t.Fatalf("Marshal: %v", err)
+				}
+				out := reflect.New(reflect.TypeOf(in).Elem()).Interface().(Message)
+				if err := Unmarshal(b, out); err != nil {
+					t.Fatalf("Unmarshal: %v", err)
+				}
+				if out.version() != SchemaVersion {
+					t.Errorf("version = %d, want %d", out.version(), SchemaVersion)
+				}
+				if !reflect.DeepEqual(in, out) {
+					t.Errorf("round trip changed the message\n in: %+v\nout: %+v", in, out)
+				}
+			})
+		}
+	}
+}
+
+func TestProtoIsDeterministic(t *testing.T) {
+	a, _ := Marshal(fullResult(), EncodingProtobuf)
+	for range 20 {
+		b, _ := Marshal(fullResult(), EncodingProtobuf)
+		if string(a) != string(b) {
+			t.Fatal("the same result encoded to different bytes")
+		}
+	}
+}
+
+func TestUnmarshalDetectsEncoding(t *testing.T) {
+	for name, build := range messages() {
+		t.Run(name, func(t *testing.T) {
+			b, err := Marshal(build(), EncodingProtobuf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b) > 0 && b[0] == '{' {
+				t.Fatalf("protobuf encoding starts with '{': %x", b)
+			}
+		})
+	}
+
+	tests := []struct {
+		name    string
+		payload []byte
+		want    HostPorts
+		wantErr bool
+	}{
+		{
+			name:    "json",
+			payload: []byte(`{"schema_version":2,"scan_id":"s","host":"192.0.2.1","ports":"80"}`),
+			want:    HostPorts{SchemaVersion: 2, ScanID: "s", Host: "192.0.2.1", Ports: "80"},
+		},
+		{
+			name:    "json with leading whitespace",
+			payload: []byte(" \r\n\t{\"schema_version\":2,\"scan_id\":\"s\"}"),
+			want:    HostPorts{SchemaVersion: 2, ScanID: "s"},
+		},
+		{
+			name: "protobuf",
+			payload: func() []byte {
+				b, _ := Marshal(&HostPorts{ScanID: "s", Host: "192.0.2.1", Ports: "80"}, EncodingProtobuf)
+				return b
+			}(),
+			want: HostPorts{SchemaVersion: 2, ScanID: "s", Host: "192.0.2.1", Ports: "80"},
+		},
+		{
+			name:    "broken json is an error, not protobuf",
+			payload: []byte(`{"scan_id": `),
+			wantErr: true,
+		},
+		{
+			name:    "truncated protobuf",
+			payload: []byte{0x12, 0x05, 's'},
+			wantErr: true,
+		},
+		{
+			name: "unknown protobuf fields are skipped",
+			payload: func() []byte {
+				b, _ := Marshal(&HostPorts{ScanID: "s"}, EncodingProtobuf)
+				b = protowire.AppendTag(b, 99, protowire.BytesType)
+				return protowire.AppendString(b, "from a newer producer")
+			}(),
+			want: HostPorts{SchemaVersion: 2, ScanID: "s"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got HostPorts
+			err := Unmarshal(tt.payload, &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnmarshalUpgradesV1(t *testing.T) {
+	t.Run("result with http.body_len", func(t *testing.T) {
+		var got ServiceScanResult
+		v1 := `{"scan_id":"s","ip":"192.0.2.1","port":443,"protocol":"https","http":{"status_code":200,"body_len":1234}}`
+		if err := Unmarshal([]byte(v1), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.SchemaVersion != SchemaVersion {
+			t.Errorf("version = %d, want %d", got.SchemaVersion, SchemaVersion)
+		}
+		if got.HTTP == nil || got.HTTP.ContentLength != 1234 || got.HTTP.StatusCode != 200 {
+			t.Errorf("http = %+v, want content_length 1234 and status 200", got.HTTP)
+		}
+	})
+	t.Run("result with content_length keeps it", func(t *testing.T) {
+		var got ServiceScanResult
+		v1 := `{"scan_id":"s","http":{"content_length":10,"body_len":99}}`
+		if err := Unmarshal([]byte(v1), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.HTTP.ContentLength != 10 {
+			t.Errorf("content_length = %d, want 10", got.HTTP.ContentLength)
+		}
+	})
+	t.Run("result without http", func(t *testing.T) {
+		var got ServiceScanResult
+		if err := Unmarshal([]byte(`{"scan_id":"s","port":22,"protocol":"ssh"}`), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.HTTP != nil || got.SchemaVersion != SchemaVersion {
+			t.Errorf("got %+v", got)
+		}
+	})
+	t.Run("request", func(t *testing.T) {
+		var got ScanRequest
+		if err := Unmarshal([]byte(`{"scan_id":"s","ip_range":"192.0.2.0/24","ports":"80"}`), &got); err != nil {
+			t.Fatal(err)
+		}
+		want := ScanRequest{SchemaVersion: SchemaVersion, ScanID: "s", IPRange: "192.0.2.0/24", Ports: "80"}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+	t.Run("host ports", func(t *testing.T) {
+		var got HostPorts
+		if err := Unmarshal([]byte(`{"scan_id":"s","host":"192.0.2.1","ports":"22","timestamp":5}`), &got); err != nil {
+			t.Fatal(err)
+		}
+		want := HostPorts{SchemaVersion: SchemaVersion, ScanID: "s", Host: "192.0.2.1", Ports: "22", Timestamp: 5}
+		if got != want {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	})
+}
+
+// TestProtoMatchesSchema decodes the hand-written encoding with the protobuf
+// runtime against scan.proto, and the runtime's encoding with ours, so the
+// two cannot drift apart.
+func TestProtoMatchesSchema(t *testing.T) {
+	schema := loadSchema(t)
+	for name, build := range messages() {
+		t.Run(name, func(t *testing.T) {
+			in := build()
+			in.setVersion()
+			typeName := reflect.TypeOf(in).Elem().Name()
+			desc := schema.Messages().ByName(protoreflect.Name(typeName))
+			if desc == nil {
+				t.Fatalf("scan.proto has no message %s", typeName)
+			}
+			dyn := dynamicpb.NewMessage(desc)
+			if err := proto.Unmarshal(in.marshalProto(), dyn); err != nil {
+				t.Fatalf("protobuf runtime rejects our encoding: %v", err)
+			}
+			checkNoUnknown(t, dyn)
+
+			b, err := proto.MarshalOptions{Deterministic: true}.Marshal(dyn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := reflect.New(reflect.TypeOf(in).Elem()).Interface().(Message)
+			if err := out.unmarshalProto(b); err != nil {
+				t.Fatalf("decoding the runtime's encoding: %v", err)
+			}
+			if !reflect.DeepEqual(in, out) {
+				t.Errorf("runtime round trip changed the message\n in: %+v\nout: %+v", in, out)
+			}
+		})
+	}
+}
+
+// checkNoUnknown fails if m or a message in it has fields the schema does
+// not declare, or declares with another wire type.
+func checkNoUnknown(t *testing.T, m protoreflect.Message) {
+	t.Helper()
+	if len(m.GetUnknown()) > 0 {
+		t.Errorf("%s: fields not in scan.proto: %x", m.Descriptor().FullName(), m.GetUnknown())
+	}
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if fd.Message() == nil {
+			return true
+		}
+		if fd.IsList() {
+			for i := range v.List().Len() {
+				checkNoUnknown(t, v.List().Get(i).Message())
+			}
+			return true
+		}
+		checkNoUnknown(t, v.Message())
+		return true
+	})
+}
+
+var (
+	messageLine = regexp.MustCompile(`^message (\w+) \{$`)
+	fieldLine   = regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+// loadSchema builds descriptors from scan.proto. It only understands what
+// the file uses: scalar, message and repeated fields, and nested messages.
+func loadSchema(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	f, err := os.Open("scan.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	const pkg = "exploravis.model.v2"
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("scan.proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+	}
+	scalars := map[string]descriptorpb.FieldDescriptorProto_Type{
+		"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+		"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+		"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+		"bytes":  descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+	}
+	var stack []*descriptorpb.DescriptorProto
+	var names []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "syntax") || strings.HasPrefix(line, "package"):
+		case messageLine.MatchString(line):
+			msg := &descriptorpb.DescriptorProto{Name: proto.String(messageLine.FindStringSubmatch(line)[1])}
+			if len(stack) == 0 {
+				file.MessageType = append(file.MessageType, msg)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.NestedType = append(parent.NestedType, msg)
+			}
+			stack = append(stack, msg)
+			names = append(names, msg.GetName())
+		case line == "}":
+			stack, names = stack[:len(stack)-1], names[:len(names)-1]
+		case fieldLine.MatchString(line):
+			m := fieldLine.FindStringSubmatch(line)
+			num, _ := strconv.Atoi(m[4])
+			field := &descriptorpb.FieldDescriptorProto{
+				Name:     proto.String(m[3]),
+				JsonName: proto.String(m[3]),
+				Number:   proto.Int32(int32(num)),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			}
+			if m[1] != "" {
+				field.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+			}
+			if typ, ok := scalars[m[2]]; ok {
+				field.Type = typ.Enum()
+			} else {
+				field.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+				field.TypeName = proto.String(resolve(file, pkg, names, m[2]))
+			}
+			msg := stack[len(stack)-1]
+			msg.Field = append(msg.Field, field)
+		default:
+			t.Fatalf("scan.proto: line not understood by loadSchema: %q", line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("scan.proto: %v", err)
+	}
+	return fd
+}
+
+// resolve finds a message type named in scope: nested in the enclosing
+// messages first, then at the top level.
+func resolve(file *descriptorpb.FileDescriptorProto, pkg string, scope []string, name string) string {
+	for i := len(scope); i > 0; i-- {
+		if nestedIn(file.MessageType, scope[:i], name) {
+			return "." + pkg + "." + strings.Join(scope[:i], ".") + "." + name
+		}
+	}
+	return "." + pkg + "." + name
+}
+
+func nestedIn(msgs []*descriptorpb.DescriptorProto, path []string, name string) bool {
+	for _, msg := range msgs {
+		if msg.GetName() != path[0] {
+			continue
+		}
+		if len(path) > 1 {
+			return nestedIn(msg.NestedType, path[1:], name)
+		}
+		for _, nested := range msg.NestedType {
+			if nested.GetName() == name {
+				return true
+			}
+		}
+	}
+	return false
+}
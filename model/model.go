@@ -0,0 +1,148 @@
+// Package model defines the messages exchanged between the Exploravis
+// pipeline stages:
+//
+//	orchestrator --ScanRequest--> ip_scan_request
+//	scanner-worker --HostPorts--> ip_scan_result
+//	banner-worker --ServiceScanResult--> not_enriched_finished_scan
+//	enrich-meta-worker --ServiceScanResult--> finished_scan
+//
+// scanner-worker and banner-worker also publish a ChunkComplete on the
+// control topic (scan_control) as they finish each chunk.
+//
+// Every message carries a schema_version. Consumers must accept any version
+// up to SchemaVersion; Unmarshal upgrades older payloads in place so the
+// rest of the code only ever sees the current shape.
+package model
+
+import "time"
+
+// SchemaVersion is the version written by this build.
+//
+//	1 - implicit (no schema_version field), untyped tls/http/ssh maps
+//	2 - typed TLS/HTTP/SSH, http.body_len folded into http.content_length
+const SchemaVersion = 2
+
+// ScanRequest asks scanner-worker to port-scan one CIDR block.
+type ScanRequest struct {
+	SchemaVersion int    `json:"schema_version"`
+	ScanID        string `json:"scan_id"`
+	IPRange       string `json:"ip_range"`
+	Ports         string `json:"ports"`
+}
+
+// HostPorts is one host with its open ports, as found by scanner-worker.
+// Ports is a comma separated list. Chunk is the IPRange of the request the
+// host was found by; empty from older scanners.
+type HostPorts struct {
+	SchemaVersion int    `json:"schema_version"`
+	ScanID        string `json:"scan_id"`
+	Host          string `json:"host"`
+	Ports         string `json:"ports"`
+	Timestamp     int64  `json:"timestamp"`
+	Chunk         string `json:"chunk,omitempty"`
+}
+
+// ChunkComplete reports that a stage has finished one chunk of a scan, the
+// IPRange of one ScanRequest. A scan is complete once banner-worker has
+// reported every chunk the orchestrator split it into.
+//
+// scanner-worker publishes it on the control topic and, as a marker under
+// HeaderMessage, on ip_scan_result after the chunk's hosts, with the same
+// key. banner-worker sees the marker after every host of the chunk and
+// publishes its own once their ports are grabbed. HostsScanned is only set
+// by the scanner; for the banner, Ports counts the ports grabbed and Errors
+// the grabs that failed.
+//
+// A banner-worker from before markers decodes one as a HostPorts with no
+// host and grabs nothing useful, so banner-worker must be rolled out before
+// scanner-worker when upgrading to a build with markers.
+type ChunkComplete struct {
+	SchemaVersion int    `json:"schema_version"`
+	ScanID        string `json:"scan_id"`
+	Chunk         string `json:"chunk"`
+	Stage         string `json:"stage"`
+	HostsScanned  int    `json:"hosts_scanned,omitempty"`
+	HostsUp       int    `json:"hosts_up"`
+	Ports         int    `json:"ports"`
+	Errors        int    `json:"errors"`
+	Error         string `json:"error,omitempty"`
+	DurationMS    int64  `json:"duration_ms"`
+	Timestamp     int64  `json:"timestamp"`
+}
+
+// HeaderMessage marks records on a data topic that are not the topic's
+// usual message; its value says which one. Consumers skip values they do
+// not know.
+const (
+	HeaderMessage        = "exploravis-message"
+	MessageChunkComplete = "chunk_complete"
+)
+
+// ChunkKey is the record key of everything belonging to one chunk, so that
+// it all lands on one partition, in order.
+func ChunkKey(scanID, chunk string) string {
+	return scanID + "/" + chunk
+}
+
+// ServiceScanResult is the banner grab of a single ip:port, enriched with
+// Meta by the later stages.
+type ServiceScanResult struct {
+	SchemaVersion int            `json:"schema_version"`
+	ScanID        string         `json:"scan_id"`
+	IP            string         `json:"ip"`
+	Port          int            `json:"port"`
+	Timestamp     int64          `json:"timestamp"`
+	Protocol      string         `json:"protocol"`
+	Service       string         `json:"service,omitempty"`
+	Banner        string         `json:"banner,omitempty"`
+	TLS           *TLSInfo       `json:"tls,omitempty"`
+	HTTP          *HTTPInfo      `json:"http,omitempty"`
+	SSH           *SSHInfo       `json:"ssh,omitempty"`
+	RawTCP        string         `json:"raw_tcp,omitempty"`
+	Meta          map[string]any `json:"meta,omitempty"`
+}
+
+type TLSInfo struct {
+	Version            string       `json:"version,omitempty"`
+	CipherSuite        string       `json:"cipher_suite,omitempty"`
+	HandshakeOK        bool         `json:"handshake_ok"`
+	NegotiatedProtocol string       `json:"negotiated_protocol,omitempty"`
+	ALPN               string       `json:"alpn,omitempty"`
+	Certificate        *Certificate `json:"certificate,omitempty"`
+}
+
+type Certificate struct {
+	Subject   string    `json:"subject,omitempty"`
+	Issuer    string    `json:"issuer,omitempty"`
+	DNSNames  []string  `json:"dns_names,omitempty"`
+	Serial    string    `json:"serial,omitempty"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	SigAlg    string    `json:"sig_alg,omitempty"`
+	PublicKey string    `json:"public_key,omitempty"`
+}
+
+type HTTPInfo struct {
+	StatusCode    int               `json:"status_code,omitempty"`
+	StatusLine    string            `json:"status_line,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	Title         string            `json:"title,omitempty"`
+	BodyPreview   string            `json:"body_preview,omitempty"`
+	BodyHash      string            `json:"body_hash,omitempty"`
+	ContentLength int               `json:"content_length"`
+	Tags          []string          `json:"tags,omitempty"`
+}
+
+type SSHInfo struct {
+	Version            string   `json:"version,omitempty"`
+	KEXAlgorithms      []string `json:"kex_algorithms,omitempty"`
+	ServerHostKeyAlgos []string `json:"server_host_key_algos,omitempty"`
+	EncAlgosC2S        []string `json:"enc_algos_c2s,omitempty"`
+	EncAlgosS2C        []string `json:"enc_algos_s2c,omitempty"`
+	MACAlgosC2S        []string `json:"mac_algos_c2s,omitempty"`
+	MACAlgosS2C        []string `json:"mac_algos_s2c,omitempty"`
+	CompressionC2S     []string `json:"compression_c2s,omitempty"`
+	CompressionS2C     []string `json:"compression_s2c,omitempty"`
+	LanguagesC2S       []string `json:"languages_c2s,omitempty"`
+	LanguagesS2C       []string `json:"languages_s2c,omitempty"`
+}
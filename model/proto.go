@@ -0,0 +1,526 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Hand-written protobuf codec for the messages described in scan.proto.
+// Keep field numbers in sync with that file; never reuse a number.
+// TestProtoMatchesSchema decodes this encoding against it with the protobuf
+// runtime, and the runtime's encoding with this.
+
+// ------------------------
+// Encoding helpers
+// ------------------------
+
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func appendStrings(b []byte, num protowire.Number, ss []string) []byte {
+	for _, s := range ss {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	return b
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	if msg == nil {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// ------------------------
+// Decoding helpers
+// ------------------------
+
+// eachField walks the top-level fields of a message. fn returns the number
+// of bytes it consumed, or 0 to have the field skipped.
+func eachField(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		used := fn(num, typ, b)
+		if used < 0 {
+			return fmt.Errorf("field %d: %w", num, protowire.ParseError(used))
+		}
+		if used == 0 {
+			used = protowire.ConsumeFieldValue(num, typ, b)
+			if used < 0 {
+				return fmt.Errorf("field %d: %w", num, protowire.ParseError(used))
+			}
+		}
+		b = b[used:]
+	}
+	return nil
+}
+
+func consumeString(typ protowire.Type, b []byte, dst *string) int {
+	if typ != protowire.BytesType {
+		return 0
+	}
+	v, n := protowire.ConsumeString(b)
+	if n >= 0 {
+		*dst = v
+	}
+	return n
+}
+
+func consumeStrings(typ protowire.Type, b []byte, dst *[]string) int {
+	var s string
+	n := consumeString(typ, b, &s)
+	if n > 0 {
+		*dst = append(*dst, s)
+	}
+	return n
+}
+
+func consumeInt(typ protowire.Type, b []byte, dst *int64) int {
+	if typ != protowire.VarintType {
+		return 0
+	}
+	v, n := protowire.ConsumeVarint(b)
+	if n >= 0 {
+		*dst = int64(v)
+	}
+	return n
+}
+
+func consumeIntField(typ protowire.Type, b []byte, dst *int) int {
+	var v int64
+	n := consumeInt(typ, b, &v)
+	if n > 0 {
+		*dst = int(v)
+	}
+	return n
+}
+
+func consumeBool(typ protowire.Type, b []byte, dst *bool) int {
+	var v int64
+	n := consumeInt(typ, b, &v)
+	if n > 0 {
+		*dst = v != 0
+	}
+	return n
+}
+
+func consumeMessage(typ protowire.Type, b []byte, decode func([]byte) error) int {
+	if typ != protowire.BytesType {
+		return 0
+	}
+	v, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n
+	}
+	if err := decode(v); err != nil {
+		return -1
+	}
+	return n
+}
+
+// ------------------------
+// ScanRequest
+// ------------------------
+
+func (m *ScanRequest) marshalProto() []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(m.SchemaVersion))
+	b = appendString(b, 2, m.ScanID)
+	b = appendString(b, 3, m.IPRange)
+	b = appendString(b, 4, m.Ports)
+	return b
+}
+
+func (m *ScanRequest) unmarshalProto(b []byte) error {
+	*m = ScanRequest{}
+	return eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeIntField(typ, b, &m.SchemaVersion)
+		case 2:
+			return consumeString(typ, b, &m.ScanID)
+		case 3:
+			return consumeString(typ, b, &m.IPRange)
+		case 4:
+			return consumeString(typ, b, &m.Ports)
+		}
+		return 0
+	})
+}
+
+// ------------------------
+// HostPorts
+// ------------------------
+
+func (m *HostPorts) marshalProto() []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(m.SchemaVersion))
+	b = appendString(b, 2, m.ScanID)
+	b = appendString(b, 3, m.Host)
+	b = appendString(b, 4, m.Ports)
+	b = appendInt(b, 5, m.Timestamp)
+	b = appendString(b, 6, m.Chunk)
+	return b
+}
+
+func (m *HostPorts) unmarshalProto(b []byte) error {
+	*m = HostPorts{}
+	return eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeIntField(typ, b, &m.SchemaVersion)
+		case 2:
+			return consumeString(typ, b, &m.ScanID)
+		case 3:
+			return consumeString(typ, b, &m.Host)
+		case 4:
+			return consumeString(typ, b, &m.Ports)
+		case 5:
+			return consumeInt(typ, b, &m.Timestamp)
+		case 6:
+			return consumeString(typ, b, &m.Chunk)
+		}
+		return 0
+	})
+}
+
+// ------------------------
+// ChunkComplete
+// ------------------------
+
+func (m *ChunkComplete) marshalProto() []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(m.SchemaVersion))
+	b = appendString(b, 2, m.ScanID)
+	b = appendString(b, 3, m.Chunk)
+	b = appendString(b, 4, m.Stage)
+	b = appendInt(b, 5, int64(m.HostsScanned))
+	b = appendInt(b, 6, int64(m.HostsUp))
+	b = appendInt(b, 7, int64(m.Ports))
+	b = appendInt(b, 8, int64(m.Errors))
+	b = appendString(b, 9, m.Error)
+	b = appendInt(b, 10, m.DurationMS)
+	b = appendInt(b, 11, m.Timestamp)
+	return b
+}
+
+func (m *ChunkComplete) unmarshalProto(b []byte) error {
+	*m = ChunkComplete{}
+	return eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeIntField(typ, b, &m.SchemaVersion)
+		case 2:
+			return consumeString(typ, b, &m.ScanID)
+		case 3:
+			return consumeString(typ, b, &m.Chunk)
+		case 4:
+			return consumeString(typ, b, &m.Stage)
+		case 5:
+			return consumeIntField(typ, b, &m.HostsScanned)
+		case 6:
+			return consumeIntField(typ, b, &m.HostsUp)
+		case 7:
+			return consumeIntField(typ, b, &m.Ports)
+		case 8:
+			return consumeIntField(typ, b, &m.Errors)
+		case 9:
+			return consumeString(typ, b, &m.Error)
+		case 10:
+			return consumeInt(typ, b, &m.DurationMS)
+		case 11:
+			return consumeInt(typ, b, &m.Timestamp)
+		}
+		return 0
+	})
+}
+
+// ------------------------
+// ServiceScanResult
+// ------------------------
+
+func (m *ServiceScanResult) marshalProto() []byte {
+	var b []byte
+	b = appendInt(b, 1, int64(m.SchemaVersion))
+	b = appendString(b, 2, m.ScanID)
+	b = appendString(b, 3, m.IP)
+	b = appendInt(b, 4, int64(m.Port))
+	b = appendInt(b, 5, m.Timestamp)
+	b = appendString(b, 6, m.Protocol)
+	b = appendString(b, 7, m.Service)
+	b = appendString(b, 8, m.Banner)
+	if m.TLS != nil {
+		b = appendMessage(b, 9, m.TLS.marshalProto())
+	}
+	if m.HTTP != nil {
+		b = appendMessage(b, 10, m.HTTP.marshalProto())
+	}
+	if m.SSH != nil {
+		b = appendMessage(b, 11, m.SSH.marshalProto())
+	}
+	b = appendString(b, 12, m.RawTCP)
+	// Meta is open-ended (each enricher adds its own keys), so it travels as
+	// embedded JSON rather than a fixed message.
+	if len(m.Meta) > 0 {
+		if meta, err := json.Marshal(m.Meta); err == nil {
+			b = appendMessage(b, 13, meta)
+		}
+	}
+	return b
+}
+
+func (m *ServiceScanResult) unmarshalProto(b []byte) error {
+	*m = ServiceScanResult{}
+	return eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeIntField(typ, b, &m.SchemaVersion)
+		case 2:
+			return consumeString(typ, b, &m.ScanID)
+		case 3:
+			return consumeString(typ, b, &m.IP)
+		case 4:
+			return consumeIntField(typ, b, &m.Port)
+		case 5:
+			return consumeInt(typ, b, &m.Timestamp)
+		case 6:
+			return consumeString(typ, b, &m.Protocol)
+		case 7:
+			return consumeString(typ, b, &m.Service)
+		case 8:
+			return consumeString(typ, b, &m.Banner)
+		case 9:
+			m.TLS = &TLSInfo{}
+			return consumeMessage(typ, b, m.TLS.unmarshalProto)
+		case 10:
+			m.HTTP = &HTTPInfo{}
+			return consumeMessage(typ, b, m.HTTP.unmarshalProto)
+		case 11:
+			m.SSH = &SSHInfo{}
+			return consumeMessage(typ, b, m.SSH.unmarshalProto)
+		case 12:
+			return consumeString(typ, b, &m.RawTCP)
+		case 13:
+			return consumeMessage(typ, b, func(v []byte) error { return json.Unmarshal(v, &m.Meta) })
+		}
+		return 0
+	})
+}
+
+func (m *TLSInfo) marshalProto() []byte {
+	b := []byte{}
+	b = appendString(b, 1, m.Version)
+	b = appendString(b, 2, m.CipherSuite)
+	b = appendBool(b, 3, m.HandshakeOK)
+	b = appendString(b, 4, m.NegotiatedProtocol)
+	b = appendString(b, 5, m.ALPN)
+	if m.Certificate != nil {
+		b = appendMessage(b, 6, m.Certificate.marshalProto())
+	}
+	return b
+}
+
+func (m *TLSInfo) unmarshalProto(b []byte) error {
+	return eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Version)
+		case 2:
+			return consumeString(typ, b, &m.CipherSuite)
+		case 3:
+			return consumeBool(typ, b, &m.HandshakeOK)
+		case 4:
+			return consumeString(typ, b, &m.NegotiatedProtocol)
+		case 5:
+			return consumeString(typ, b, &m.ALPN)
+		case 6:
+			m.Certificate = &Certificate{}
+			return consumeMessage(typ, b, m.Certificate.unmarshalProto)
+		}
+		return 0
+	})
+}
+
+func (m *Certificate) marshalProto() []byte {
+	b := []byte{}
+	b = appendString(b, 1, m.Subject)
+	b = appendString(b, 2, m.Issuer)
+	b = appendStrings(b, 3, m.DNSNames)
+	b = appendString(b, 4, m.Serial)
+	if !m.NotBefore.IsZero() {
+		b = appendInt(b, 5, m.NotBefore.Unix())
+	}
+	if !m.NotAfter.IsZero() {
+		b = appendInt(b, 6, m.NotAfter.Unix())
+	}
+	b = appendString(b, 7, m.SigAlg)
+	b = appendString(b, 8, m.PublicKey)
+	return b
+}
+
+func (m *Certificate) unmarshalProto(b []byte) error {
+	var notBefore, notAfter int64
+	err := eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeString(typ, b, &m.Subject)
+		case 2:
+			return consumeString(typ, b, &m.Issuer)
+		case 3:
+			return consumeStrings(typ, b, &m.DNSNames)
+		case 4:
+			return consumeString(typ, b, &m.Serial)
+		case 5:
+			return consumeInt(typ, b, &notBefore)
+		case 6:
+			return consumeInt(typ, b, &notAfter)
+		case 7:
+			return consumeString(typ, b, &m.SigAlg)
+		case 8:
+			return consumeString(typ, b, &m.PublicKey)
+		}
+		return 0
+	})
+	if notBefore != 0 {
+		m.NotBefore = time.Unix(notBefore, 0).UTC()
+	}
+	if notAfter != 0 {
+		m.NotAfter = time.Unix(notAfter, 0).UTC()
+	}
+	return err
+}
+
+func (m *HTTPInfo) marshalProto() []byte {
+	b := []byte{}
+	b = appendInt(b, 1, int64(m.StatusCode))
+	b = appendString(b, 2, m.StatusLine)
+	// Sorted so the same result always encodes to the same bytes.
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var h []byte
+		h = appendString(h, 1, k)
+		h = appendString(h, 2, m.Headers[k])
+		b = appendMessage(b, 3, h)
+	}
+	b = appendString(b, 4, m.Title)
+	b = appendString(b, 5, m.BodyPreview)
+	b = appendString(b, 6, m.BodyHash)
+	b = appendInt(b, 7, int64(m.ContentLength))
+	b = appendStrings(b, 8, m.Tags)
+	return b
+}
+
+func (m *HTTPInfo) unmarshalProto(b []byte) error {
+	return eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		switch num {
+		case 1:
+			return consumeIntField(typ, b, &m.StatusCode)
+		case 2:
+			return consumeString(typ, b, &m.StatusLine)
+		case 3:
+			return consumeMessage(typ, b, func(v []byte) error {
+				var key, val string
+				err := eachField(v, func(num protowire.Number, typ protowire.Type, b []byte) int {
+					switch num {
+					case 1:
+						return consumeString(typ, b, &key)
+					case 2:
+						return consumeString(typ, b, &val)
+					}
+					return 0
+				})
+				if m.Headers == nil {
+					m.Headers = map[string]string{}
+				}
+				m.Headers[key] = val
+				return err
+			})
+		case 4:
+			return consumeString(typ, b, &m.Title)
+		case 5:
+			return consumeString(typ, b, &m.BodyPreview)
+		case 6:
+			return consumeString(typ, b, &m.BodyHash)
+		case 7:
+			return consumeIntField(typ, b, &m.ContentLength)
+		case 8:
+			return consumeStrings(typ, b, &m.Tags)
+		}
+		return 0
+	})
+}
+
+func (m *SSHInfo) marshalProto() []byte {
+	b := []byte{}
+	b = appendString(b, 1, m.Version)
+	b = appendStrings(b, 2, m.KEXAlgorithms)
+	b = appendStrings(b, 3, m.ServerHostKeyAlgos)
+	b = appendStrings(b, 4, m.EncAlgosC2S)
+	b = appendStrings(b, 5, m.EncAlgosS2C)
+	b = appendStrings(b, 6, m.MACAlgosC2S)
+	b = appendStrings(b, 7, m.MACAlgosS2C)
+	b = appendStrings(b, 8, m.CompressionC2S)
+	b = appendStrings(b, 9, m.CompressionS2C)
+	b = appendStrings(b, 10, m.LanguagesC2S)
+	b = appendStrings(b, 11, m.LanguagesS2C)
+	return b
+}
+
+func (m *SSHInfo) unmarshalProto(b []byte) error {
+	lists := map[protowire.Number]*[]string{
+		2:  &m.KEXAlgorithms,
+		3:  &m.ServerHostKeyAlgos,
+		4:  &m.EncAlgosC2S,
+		5:  &m.EncAlgosS2C,
+		6:  &m.MACAlgosC2S,
+		7:  &m.MACAlgosS2C,
+		8:  &m.CompressionC2S,
+		9:  &m.CompressionS2C,
+		10: &m.LanguagesC2S,
+		11: &m.LanguagesS2C,
+	}
+	return eachField(b, func(num protowire.Number, typ protowire.Type, b []byte) int {
+		if num == 1 {
+			return consumeString(typ, b, &m.Version)
+		}
+		if dst, ok := lists[num]; ok {
+			return consumeStrings(typ, b, dst)
+		}
+		return 0
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/exploravis/bus"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newBus() bus.Bus {
+	if cfg.Bus.Kind == "kafka" {
+		log.Println("Kafka brokers:", cfg.Kafka.Seeds)
+	}
+	log.Printf("Connecting to the %s bus...", cfg.Bus.Kind)
+	b, err := bus.New(cfg.Bus, cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Bus client error: %v", err)
+	}
+	log.Println("Connected")
+	return b
+}
+
+// onKafka reports whether the pipeline runs on Kafka. Topic provisioning,
+// the Kafka health check and result streaming need its admin API.
+func onKafka() bool {
+	return cfg.Bus.Kind == "kafka"
+}
+
+// produceScanRequest sends one subnet request under ctx's trace; the
+// scanner-worker continues it from the record's headers.
+func produceScanRequest(ctx context.Context, b bus.Bus, payload []byte, key string) {
+	record := &kgo.Record{
+		Topic: cfg.Topics.ScanRequests,
+		Key:   []byte(key),
+		Value: payload,
+	}
+	system := semconv.MessagingSystemKey.String(cfg.Bus.Kind)
+	if onKafka() {
+		system = semconv.MessagingSystemKafka
+	}
+	ctx, span := tracer.Start(ctx, record.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			system,
+			semconv.MessagingDestinationName(record.Topic),
+			attribute.String("scan.ip_range", key),
+		),
+	)
+	otel.GetTextMapPropagator().Inject(ctx, recordHeaders{record})
+
+	log.Println("Producing scan request...")
+	b.Publish(context.Background(), record, func(r *kgo.Record, err error) {
+		recordsProduced.WithLabelValues(r.Topic, produceOutcome(err)).Inc()
+		endSpan(span, err)
+		if err != nil {
+			log.Printf("failed to produce record: %v", err)
+		} else {
+			log.Println("Scan request produced successfully")
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/exploravis/config"
+)
+
+// Config is everything the orchestrator reads at startup. See package
+// config for how flags, env and --config are merged.
+type Config struct {
+	Bus       config.Bus      `yaml:"bus"`
+	Kafka     config.Kafka    `yaml:"kafka"`
+	Elastic   config.Elastic  `yaml:"elastic"`
+	HTTP      HTTPConfig      `yaml:"http"`
+	Topics    TopicsConfig    `yaml:"topics"`
+	Stats     StatsConfig     `yaml:"stats"`
+	Scans     ScansConfig     `yaml:"scans"`
+	Health    HealthConfig    `yaml:"health"`
+	Provision ProvisionConfig `yaml:"provision"`
+	Tracing   config.Tracing  `yaml:"tracing"`
+}
+
+type HTTPConfig struct {
+	Port string `yaml:"port" env:"PORT" default:"8089" usage:"API listen port"`
+}
+
+type TopicsConfig struct {
+	ScanRequests  string `yaml:"scan_requests" default:"ip_scan_request" usage:"topic scan requests are produced to" required:"true"`
+	HostResults   string `yaml:"host_results" default:"ip_scan_result" usage:"topic the scanner produces open ports to" required:"true"`
+	BannerResults string `yaml:"banner_results" default:"not_enriched_finished_scan" usage:"topic the banner worker produces grabbed services to" required:"true"`
+	Results       string `yaml:"results" default:"finished_scan" usage:"topic of enriched results streamed to clients" required:"true"`
+	Control       string `yaml:"control" env:"CONTROL_TOPIC" default:"scan_control" usage:"topic the scanner and banner workers publish chunk completions to" required:"true"`
+	WireEncoding  string `yaml:"wire_encoding" env:"WIRE_ENCODING" default:"json" usage:"wire encoding of scan requests: json or protobuf"`
+}
+
+func (t *TopicsConfig) Validate() error {
+	switch t.WireEncoding {
+	case "json", "protobuf", "proto":
+		return nil
+	}
+	return errors.New("topics.wire_encoding must be json or protobuf")
+}
+
+type StatsConfig struct {
+	CacheTTL time.Duration `yaml:"cache_ttl" env:"STATS_CACHE_TTL" default:"1m" usage:"how long /stats responses are cached"`
+}
+
+func (s *StatsConfig) Validate() error {
+	if s.CacheTTL <= 0 {
+		return errors.New("stats.cache_ttl must be positive")
+	}
+	return nil
+}
+
+// ScansConfig is the ILM policy of the scans indices. Values are ES
+// durations and sizes (30d, 25gb), passed through as-is.
+type ScansConfig struct {
+	RolloverMaxAge  string `yaml:"rollover_max_age" env:"SCANS_ROLLOVER_MAX_AGE" default:"30d" usage:"roll the scans write index over after this age"`
+	RolloverMaxSize string `yaml:"rollover_max_size" env:"SCANS_ROLLOVER_MAX_SIZE" default:"25gb" usage:"roll the scans write index over at this primary shard size"`
+	Retention       string `yaml:"retention" env:"SCANS_RETENTION" usage:"delete rolled-over scans indices after this age (empty keeps them)"`
+}
+
+type HealthConfig struct {
+	K8sAPI         string `yaml:"k8s_api" env:"K8S_METRICS_API" usage:"Kubernetes API URL (default in-cluster)"`
+	K8sBearerToken string `yaml:"k8s_bearer_token" env:"K8S_BEARER_TOKEN" usage:"Kubernetes API token (default service account token)" secret:"true"`
+	K8sCAPath      string `yaml:"k8s_ca_path" env:"K8S_CA_PATH" usage:"Kubernetes API CA bundle (default service account CA)"`
+
+	CacheTTL     time.Duration `yaml:"cache_ttl" env:"HEALTH_CACHE_TTL" default:"10s" usage:"how long a component's /health result is served before it is checked again"`
+	ESTimeout    time.Duration `yaml:"es_timeout" env:"HEALTH_ES_TIMEOUT" default:"3s" usage:"deadline of the Elasticsearch health check"`
+	KafkaTimeout time.Duration `yaml:"kafka_timeout" env:"HEALTH_KAFKA_TIMEOUT" default:"5s" usage:"deadline of the Kafka health check"`
+	K8sTimeout   time.Duration `yaml:"k8s_timeout" env:"HEALTH_K8S_TIMEOUT" default:"5s" usage:"deadline of the Kubernetes health check"`
+
+	Namespace   string        `yaml:"namespace" env:"HEALTH_K8S_NAMESPACE" default:"exploravis" usage:"namespace whose deployments, pods and events /health reports"`
+	EventWindow time.Duration `yaml:"event_window" env:"HEALTH_K8S_EVENT_WINDOW" default:"1h" usage:"how far back /health reports warning events of the workloads"`
+
+	Groups      []string      `yaml:"groups" env:"HEALTH_GROUPS" default:"scanner-group,banner-scanner-group,meta-enrich-group,es-worker-group-1" usage:"consumer groups of the pipeline stages, in order, whose lag /health reports"`
+	LagInterval time.Duration `yaml:"lag_interval" env:"HEALTH_LAG_INTERVAL" default:"15s" usage:"how often consumer group lag is sampled"`
+	LagWindow   time.Duration `yaml:"lag_window" env:"HEALTH_LAG_WINDOW" default:"5m" usage:"how far back rates and lag trends look"`
+}
+
+func (h *HealthConfig) Validate() error {
+	var errs []error
+	if h.CacheTTL <= 0 || h.ESTimeout <= 0 || h.KafkaTimeout <= 0 || h.K8sTimeout <= 0 {
+		errs = append(errs, errors.New("health.cache_ttl and the health check timeouts must be positive"))
+	}
+	if h.Namespace == "" || h.EventWindow <= 0 {
+		errs = append(errs, errors.New("health.namespace must be set and health.event_window positive"))
+	}
+	if h.LagInterval <= 0 || h.LagWindow < h.LagInterval {
+		errs = append(errs, errors.New("health.lag_interval must be positive and no longer than health.lag_window"))
+	}
+	return errors.Join(errs...)
+}
+
+// ProvisionConfig is what `orchestrator provision` creates the pipeline
+// topics with, and what startup checks existing topics against.
+type ProvisionConfig struct {
+	Partitions        int           `yaml:"partitions" env:"TOPIC_PARTITIONS" default:"12" usage:"partitions of newly created pipeline topics"`
+	ReplicationFactor int           `yaml:"replication_factor" env:"TOPIC_REPLICATION_FACTOR" default:"-1" usage:"replication factor of new topics (-1 = broker default)"`
+	Retention         time.Duration `yaml:"retention" env:"TOPIC_RETENTION" default:"168h" usage:"retention.ms of pipeline topics"`
+	DLQRetention      time.Duration `yaml:"dlq_retention" env:"DLQ_TOPIC_RETENTION" default:"720h" usage:"retention.ms of dead-letter topics"`
+	Parallelism       int           `yaml:"parallelism" env:"WORKER_PARALLELISM" default:"3" usage:"most consumers any stage runs in one group; topics need at least this many partitions"`
+}
+
+func (p *ProvisionConfig) Validate() error {
+	var errs []error
+	if p.Partitions < 1 {
+		errs = append(errs, errors.New("provision.partitions must be at least 1"))
+	}
+	if p.ReplicationFactor == 0 || p.ReplicationFactor < -1 {
+		errs = append(errs, errors.New("provision.replication_factor must be positive or -1"))
+	}
+	if p.Retention <= 0 || p.DLQRetention <= 0 {
+		errs = append(errs, errors.New("provision.retention and provision.dlq_retention must be positive"))
+	}
+	if p.Parallelism < 1 {
+		errs = append(errs, errors.New("provision.parallelism must be at least 1"))
+	} else if p.Partitions < p.Parallelism {
+		errs = append(errs, errors.New("provision.partitions must be at least provision.parallelism"))
+	}
+	return errors.Join(errs...)
+}
+
+var cfg Config
@@ -11,29 +11,15 @@ import (
 	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/exploravis/model"
 	// "github.com/elastic/go-elasticsearch/v8/esapi"
 )
 
-type ServiceScanResult struct {
-	ScanID    string         `json:"scan_id,omitempty"`
-	IP        string         `json:"ip"`
-	Port      int            `json:"port"`
-	Timestamp int64          `json:"timestamp"`
-	Protocol  string         `json:"protocol"`
-	Service   string         `json:"service,omitempty"`
-	Banner    string         `json:"banner,omitempty"`
-	TLS       map[string]any `json:"tls,omitempty"`
-	HTTP      map[string]any `json:"http,omitempty"`
-	SSH       map[string]any `json:"ssh,omitempty"`
-	RawTCP    string         `json:"raw_tcp,omitempty"`
-	Meta      map[string]any `json:"meta,omitempty"`
-}
-
 type ScanResponse struct {
-	Total   int                 `json:"total"`
-	Results []ServiceScanResult `json:"results"`
-	Aggs    map[string]any      `json:"aggs,omitempty"`
-	TookMS  int                 `json:"took_ms"`
+	Total   int                       `json:"total"`
+	Results []model.ServiceScanResult `json:"results"`
+	Aggs    map[string]any            `json:"aggs,omitempty"`
+	TookMS  int                       `json:"took_ms"`
 }
 
 // ------------------------
@@ -68,8 +54,100 @@ func buildESQuery(params map[string][]string) map[string]any {
 		}
 	}
 
+	query, tr := buildFilterQuery(params, time.Now())
+
+	// ------------------------
+	// Aggregations
+	// ------------------------
+	defaultAggs := map[string]any{
+		"top_ports":        map[string]any{"terms": map[string]any{"field": "port", "size": 10}},
+		"top_http_servers": map[string]any{"terms": map[string]any{"field": "http.headers.server.keyword", "size": 12}},
+		"by_country":       map[string]any{"terms": map[string]any{"field": "meta.geo.country.keyword", "size": 100}},
+		"top_orgs":         map[string]any{"terms": map[string]any{"field": "meta.asn.org.keyword", "size": 10}},
+	}
+
+	aggs := defaultAggs
+	if v := params["aggs"]; len(v) > 0 && v[0] == "none" {
+		aggs = nil
+	}
+
+	// Exposure over time
+	if v := params["date_histogram"]; len(v) > 0 {
+		if interval, ok := parseHistogramInterval(v[0]); ok {
+			if aggs == nil {
+				aggs = map[string]any{}
+			}
+			aggs["date_histogram"] = timestampHistogram(interval, tr)
+		}
+	}
+
+	// Map clusters for the sidebar, one bucket per geohash cell
+	if v := params["geohash_grid"]; len(v) > 0 {
+		if precision, err := strconv.Atoi(v[0]); err == nil && precision >= 1 && precision <= 12 {
+			if aggs == nil {
+				aggs = map[string]any{}
+			}
+			aggs["geo_grid"] = map[string]any{
+				"geohash_grid": map[string]any{"field": "meta.geo.location", "precision": precision, "size": 10000},
+				"aggs": map[string]any{
+					"centroid": map[string]any{"geo_centroid": map[string]any{"field": "meta.geo.location"}},
+				},
+			}
+		}
+	}
+
+	// ------------------------
+	// Build final ES body
+	// ------------------------
+	body := map[string]any{
+		"size":  size,
+		"from":  from,
+		"query": query,
+		"sort": []map[string]any{
+			{sortField: map[string]any{"order": sortOrder}},
+		},
+		"highlight": map[string]any{
+			"pre_tags":  []string{"<em>"},
+			"post_tags": []string{"</em>"},
+			"fields":    map[string]any{"banner": map[string]any{}},
+		},
+	}
+
+	if aggs != nil {
+		body["aggs"] = aggs
+	}
+
+	return body
+}
+
+// timestampHistogram buckets documents by timestamp. The field is stored as
+// epoch seconds, so this is a numeric histogram with the interval in seconds.
+func timestampHistogram(interval int64, tr timeRange) map[string]any {
+	hist := map[string]any{
+		"field":         "timestamp",
+		"interval":      interval,
+		"min_doc_count": 0,
+	}
+	if tr.HasFrom && tr.HasTo && tr.From <= tr.To {
+		hist["extended_bounds"] = map[string]any{"min": tr.From, "max": tr.To}
+	}
+	return map[string]any{"histogram": hist}
+}
+
+// timeRange is the effective timestamp window of a query, in epoch seconds.
+// Both ends are inclusive, whether set by from_ts/to_ts or after:/before:
+// in q, so "after:X" and "from_ts=X" select the same documents.
+type timeRange struct {
+	From, To       int64
+	HasFrom, HasTo bool
+}
+
+// buildFilterQuery turns the request filters (field params and the q
+// syntax) into an ES query clause, shared by /scans and /stats.
+func buildFilterQuery(params map[string][]string, now time.Time) (map[string]any, timeRange) {
 	boolMust := []map[string]any{}
 	boolFilter := []map[string]any{}
+	boolMustNot := []map[string]any{}
 
 	// ------------------------
 	// Simple field filters
@@ -92,6 +170,23 @@ func buildESQuery(params map[string][]string) map[string]any {
 	if v := params["service"]; len(v) > 0 {
 		boolMust = append(boolMust, map[string]any{"terms": map[string]any{"service.keyword": v}})
 	}
+	// ------------------------
+	// Time range
+	// ------------------------
+	var tr timeRange
+	if v := params["from_ts"]; len(v) > 0 {
+		if ts, ok := parseTimeBound(v[0], now, false); ok {
+			tr.From, tr.HasFrom = ts, true
+			boolFilter = append(boolFilter, map[string]any{"range": map[string]any{"timestamp": map[string]any{"gte": ts}}})
+		}
+	}
+	if v := params["to_ts"]; len(v) > 0 {
+		if ts, ok := parseTimeBound(v[0], now, true); ok {
+			tr.To, tr.HasTo = ts, true
+			boolFilter = append(boolFilter, map[string]any{"range": map[string]any{"timestamp": map[string]any{"lte": ts}}})
+		}
+	}
+
 	if v := params["port"]; len(v) > 0 {
 		ports := []int{}
 		for _, s := range v {
@@ -140,81 +235,113 @@ func buildESQuery(params map[string][]string) map[string]any {
 		for f, vals := range t.FieldTerms {
 			for _, v := range vals {
 				switch f {
-				case "ip":
-					boolFilter = append(boolFilter, map[string]any{"term": map[string]any{"ip.keyword": v}})
-
-				case "country":
-					boolFilter = append(boolFilter, map[string]any{"term": map[string]any{"meta.geo.country.keyword": v}})
-				case "port":
-					if a, b, ok := parseNumericRange(v); ok {
-						boolFilter = append(boolFilter, map[string]any{"range": map[string]any{"port": map[string]any{"gte": a, "lte": b}}})
-					} else if p, err := strconv.Atoi(v); err == nil {
-						boolFilter = append(boolFilter, map[string]any{"term": map[string]any{"port": p}})
+				case "after":
+					if ts, ok := parseTimeBound(v, now, false); ok && (!tr.HasFrom || ts > tr.From) {
+						tr.From, tr.HasFrom = ts, true
+					}
+				case "before":
+					if ts, ok := parseTimeBound(v, now, true); ok && (!tr.HasTo || ts < tr.To) {
+						tr.To, tr.HasTo = ts, true
 					}
-				default:
-					boolMust = append(boolMust, map[string]any{"match": map[string]any{f: v}})
+				}
+				clause := shodanFieldClause(f, v, now)
+				if clause == nil {
+					continue
+				}
+				if _, scored := clause["match"]; scored {
+					boolMust = append(boolMust, clause)
+				} else {
+					boolFilter = append(boolFilter, clause)
+				}
+			}
+		}
+		// negated terms exclude; a bare "-word" excludes free text
+		for f, vals := range t.NotFieldTerms {
+			for _, v := range vals {
+				if f == "_free" {
+					boolMustNot = append(boolMustNot, freeTextClause(v))
+				} else if clause := shodanFieldClause(f, v, now); clause != nil {
+					boolMustNot = append(boolMustNot, clause)
 				}
 			}
 		}
 		// free-text search
 		if len(t.FreeTerms) > 0 {
-			boolMust = append(boolMust, map[string]any{"simple_query_string": map[string]any{
-				"query":            strings.Join(t.FreeTerms, " "),
-				"fields":           []string{"banner^3", "http.body_preview", "raw_tcp", "ssh.banner^2"},
-				"default_operator": "and",
-			}})
+			boolMust = append(boolMust, freeTextClause(strings.Join(t.FreeTerms, " ")))
 		}
 	}
 
-	// ------------------------
-	// Aggregations
-	// ------------------------
-	defaultAggs := map[string]any{
-		"top_ports":        map[string]any{"terms": map[string]any{"field": "port", "size": 10}},
-		"top_http_servers": map[string]any{"terms": map[string]any{"field": "http.headers.server.keyword", "size": 12}},
-		"by_country":       map[string]any{"terms": map[string]any{"field": "meta.geo.country.keyword", "size": 100}},
-		"top_orgs":         map[string]any{"terms": map[string]any{"field": "meta.asn.org.keyword", "size": 10}},
+	if len(boolMust) == 0 && len(boolFilter) == 0 && len(boolMustNot) == 0 {
+		return map[string]any{"match_all": map[string]any{}}, tr
 	}
-
-	aggs := defaultAggs
-	if v := params["aggs"]; len(v) > 0 && v[0] == "none" {
-		aggs = nil
+	boolQuery := map[string]any{}
+	if len(boolMust) > 0 {
+		boolQuery["must"] = boolMust
 	}
-
-	// ------------------------
-	// Build final ES body
-	// ------------------------
-	body := map[string]any{
-		"size": size,
-		"from": from,
-		"sort": []map[string]any{
-			{sortField: map[string]any{"order": sortOrder}},
-		},
-		"highlight": map[string]any{
-			"pre_tags":  []string{"<em>"},
-			"post_tags": []string{"</em>"},
-			"fields":    map[string]any{"banner": map[string]any{}},
-		},
+	if len(boolFilter) > 0 {
+		boolQuery["filter"] = boolFilter
+	}
+	if len(boolMustNot) > 0 {
+		boolQuery["must_not"] = boolMustNot
 	}
+	return map[string]any{"bool": boolQuery}, tr
+}
 
-	if len(boolMust) > 0 || len(boolFilter) > 0 {
-		boolQuery := map[string]any{}
-		if len(boolMust) > 0 {
-			boolQuery["must"] = boolMust
+// shodanFieldClause is the ES clause for one field:value term of q, or nil
+// if the value is invalid. resultMatcher must agree with it.
+func shodanFieldClause(f, v string, now time.Time) map[string]any {
+	switch f {
+	case "ip":
+		return map[string]any{"term": map[string]any{"ip.keyword": v}}
+	case "country":
+		return map[string]any{"term": map[string]any{"meta.geo.country.keyword": v}}
+	case "after":
+		if ts, ok := parseTimeBound(v, now, false); ok {
+			return map[string]any{"range": map[string]any{"timestamp": map[string]any{"gte": ts}}}
+		}
+	case "before":
+		if ts, ok := parseTimeBound(v, now, true); ok {
+			return map[string]any{"range": map[string]any{"timestamp": map[string]any{"lte": ts}}}
+		}
+	case "geo":
+		if lat, lon, km, ok := parseGeoDistance(v); ok {
+			return map[string]any{"geo_distance": map[string]any{
+				"distance":          strconv.FormatFloat(km, 'f', -1, 64) + "km",
+				"meta.geo.location": map[string]any{"lat": lat, "lon": lon},
+			}}
 		}
-		if len(boolFilter) > 0 {
-			boolQuery["filter"] = boolFilter
+	case "bbox":
+		if top, left, bottom, right, ok := parseBBox(v); ok {
+			return map[string]any{"geo_bounding_box": map[string]any{
+				"meta.geo.location": map[string]any{
+					"top_left":     map[string]any{"lat": top, "lon": left},
+					"bottom_right": map[string]any{"lat": bottom, "lon": right},
+				},
+			}}
 		}
-		body["query"] = map[string]any{"bool": boolQuery}
-	} else {
-		body["query"] = map[string]any{"match_all": map[string]any{}}
+	case "port":
+		if a, b, ok := parseNumericRange(v); ok {
+			return map[string]any{"range": map[string]any{"port": map[string]any{"gte": a, "lte": b}}}
+		} else if p, err := strconv.Atoi(v); err == nil {
+			return map[string]any{"term": map[string]any{"port": p}}
+		}
+	default:
+		return map[string]any{"match": map[string]any{f: v}}
 	}
+	return nil
+}
 
-	if aggs != nil {
-		body["aggs"] = aggs
-	}
+// freeTextFields are the banner-like fields free text searches, with their
+// ES boosts. resultMatcher searches the same paths.
+var freeTextFields = []string{"banner^3", "http.body_preview", "raw_tcp", "ssh.version^2"}
 
-	return body
+// freeTextClause searches the banner-like fields for every word of query.
+func freeTextClause(query string) map[string]any {
+	return map[string]any{"simple_query_string": map[string]any{
+		"query":            query,
+		"fields":           freeTextFields,
+		"default_operator": "and",
+	}}
 }
 
 // ------------------------
@@ -238,7 +365,7 @@ func scansHandler(es *elasticsearch.Client) http.Handler {
 		// Execute search
 		res, err := es.Search(
 			es.Search.WithContext(ctx),
-			es.Search.WithIndex("scans-000001"),
+			es.Search.WithIndex(scansSearchIndex(r.URL.Query())),
 			es.Search.WithBody(bytes.NewReader(bodyBytes)),
 			es.Search.WithTrackTotalHits(true),
 		)
@@ -272,15 +399,17 @@ func scansHandler(es *elasticsearch.Client) http.Handler {
 			}
 		}
 
-		results := []ServiceScanResult{}
+		results := []model.ServiceScanResult{}
 		if hitsObj, ok := doc["hits"].(map[string]any); ok {
 			if hitsArr, ok := hitsObj["hits"].([]any); ok {
 				for _, h := range hitsArr {
 					if hitMap, ok := h.(map[string]any); ok {
 						if src, ok := hitMap["_source"]; ok {
 							b, _ := json.Marshal(src)
-							var s ServiceScanResult
-							_ = json.Unmarshal(b, &s)
+							// Older documents predate the typed schema;
+							// model.Unmarshal upgrades them.
+							var s model.ServiceScanResult
+							_ = model.Unmarshal(b, &s)
 							if hm, ok := hitMap["highlight"].(map[string]any); ok {
 								if s.Meta == nil {
 									s.Meta = map[string]any{}
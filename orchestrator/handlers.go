@@ -7,16 +7,13 @@ import (
 	"net"
 	"net/http"
 
+	"github.com/exploravis/bus"
+	"github.com/exploravis/model"
 	"github.com/google/uuid"
-	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type ScanRequest struct {
-	ScanID  string `json:"scan_id"`
-	IPRange string `json:"ip_range"`
-	Ports   string `json:"ports"`
-}
-
 func splitCIDR(cidr string, mask int) ([]string, error) {
 	ip, ipnet, err := net.ParseCIDR(cidr)
 	if err != nil {
@@ -81,7 +78,10 @@ func nextSubnet(ip net.IP, mask int) net.IP {
 	return newIP
 }
 
-func scanHandler(kafka *kgo.Client) http.Handler {
+func scanHandler(b bus.Bus) http.Handler {
+	// Already validated by config.
+	encoding, _ := model.ParseEncoding(cfg.Topics.WireEncoding)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Println("[INFO] Scan handler invoked")
 		if r.Method != http.MethodPost {
@@ -97,7 +97,7 @@ func scanHandler(kafka *kgo.Client) http.Handler {
 			return
 		}
 
-		var req ScanRequest
+		var req model.ScanRequest
 		if err := json.Unmarshal(body, &req); err != nil {
 			log.Printf("[ERROR] Failed to unmarshal JSON: %v", err)
 			http.Error(w, "bad json", 400)
@@ -114,6 +114,13 @@ func scanHandler(kafka *kgo.Client) http.Handler {
 		req.ScanID = baseScanID
 		log.Printf("[INFO] Assigned base scan ID: %s", baseScanID)
 
+		ctx, span := tracer.Start(r.Context(), "scan submit", trace.WithAttributes(
+			attribute.String("scan.id", baseScanID),
+			attribute.String("scan.ip_range", req.IPRange),
+			attribute.String("scan.ports", req.Ports),
+		))
+		defer span.End()
+
 		subnets, err := splitCIDR(req.IPRange, 24)
 		if err != nil {
 			log.Printf("[ERROR] Failed to split CIDR: %v", err)
@@ -125,16 +132,19 @@ func scanHandler(kafka *kgo.Client) http.Handler {
 			subReq := req
 			subReq.IPRange = subnet
 
-			msgBytes, err := json.Marshal(subReq)
+			msgBytes, err := model.Marshal(&subReq, encoding)
 			if err != nil {
 				// log.Printf("[ERROR] Failed to marshal subnet scan request for %s: %v", subnet, err)
 				continue
 			}
 
-			produceScanRequest(kafka, msgBytes, subReq.IPRange)
+			produceScanRequest(ctx, b, msgBytes, subReq.IPRange)
 			// log.Printf("[INFO] Produced scan request for subnet %s with ScanID %s", subnet, baseScanID)
 		}
 
+		scanBatches.Inc()
+		scanSubnets.Observe(float64(len(subnets)))
+
 		w.WriteHeader(202)
 		w.Write([]byte(`{"status":"queued","scan_id":"` + baseScanID + `"}`))
 		log.Printf("[INFO] Scan batch queued with base ScanID %s for original range %s", baseScanID, req.IPRange)
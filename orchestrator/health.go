@@ -7,28 +7,143 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/twmb/franz-go/pkg/kadm"
 	"github.com/twmb/franz-go/pkg/kgo"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 )
 
 type ClusterHealth struct {
-	Elasticsearch any    `json:"elasticsearch"`
-	Kafka         any    `json:"kafka"`
-	K8s           any    `json:"kubernetes"`
-	Status        string `json:"status"`
+	Elasticsearch any `json:"elasticsearch,omitempty"`
+	Kafka         any `json:"kafka,omitempty"`
+	K8s           any `json:"kubernetes,omitempty"`
+	// Pipeline is the consumer lag of each stage; nil when not on Kafka.
+	Pipeline *PipelineReport `json:"pipeline,omitempty"`
+	// Checks says when each component was last checked and how it went.
+	Checks map[string]CheckInfo `json:"checks"`
+	Status string               `json:"status"`
 }
 
-func doGet(url string, timeout time.Duration, headers map[string]string, transport *http.Transport) (*http.Response, error) {
-	client := &http.Client{Timeout: timeout}
-	if transport != nil {
-		client.Transport = transport
+// HealthSummary is /health?summary=1: only the statuses, for the status
+// badge that polls it.
+type HealthSummary struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components"`
+}
+
+type CheckInfo struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	TookMS    int64     `json:"took_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// healthCheck is one component of /health. Its result is cached for
+// cfg.Health.CacheTTL; after that a request gets the cached result while a
+// refresh runs in the background, so once warm /health never waits on a
+// slow dependency.
+type healthCheck struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) (any, error)
+
+	mu         sync.Mutex
+	last       *checkResult
+	refreshing chan struct{} // closed when the running refresh is done
+}
+
+type checkResult struct {
+	out  any
+	err  error
+	at   time.Time
+	took time.Duration
+}
+
+// get returns the latest result, starting a refresh if it is stale. Only
+// the very first call waits for one.
+func (c *healthCheck) get() checkResult {
+	c.mu.Lock()
+	last, wait := c.last, c.refreshing
+	if (last == nil || time.Since(last.at) > cfg.Health.CacheTTL) && wait == nil {
+		wait = make(chan struct{})
+		c.refreshing = wait
+		go c.refresh(wait)
+	}
+	c.mu.Unlock()
+	if last != nil {
+		return *last
+	}
+
+	<-wait
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return *c.last
+}
+
+func (c *healthCheck) refresh(done chan struct{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	start := time.Now()
+	out, err := c.run(ctx)
+	res := &checkResult{out: out, err: err, at: time.Now(), took: time.Since(start)}
+
+	c.mu.Lock()
+	c.last, c.refreshing = res, nil
+	c.mu.Unlock()
+	close(done)
+}
+
+// healthChecks are the remote components. Kafka is left out when the
+// pipeline runs on another bus.
+func healthChecks() []*healthCheck {
+	healthOnce.Do(func() {
+		checks := []*healthCheck{
+			{name: "elasticsearch", timeout: cfg.Health.ESTimeout, run: func(ctx context.Context) (any, error) {
+				return checkElasticsearch(ctx, cfg.Elastic.URL)
+			}},
+		}
+		if onKafka() {
+			checks = append(checks, &healthCheck{name: "kafka", timeout: cfg.Health.KafkaTimeout, run: checkKafkaDetailed})
+		}
+		checks = append(checks, &healthCheck{name: "kubernetes", timeout: cfg.Health.K8sTimeout, run: checkKubernetes})
+		allHealthChecks = checks
+	})
+	return allHealthChecks
+}
+
+var (
+	healthOnce      sync.Once
+	allHealthChecks []*healthCheck
+)
+
+// healthComponents are the names ?component= accepts.
+func healthComponents() []string {
+	names := []string{"elasticsearch", "kafka", "kubernetes"}
+	if lagMon != nil {
+		names = append(names, "pipeline")
+	}
+	return names
+}
+
+// warmHealth runs every check once at startup, so the first /health is
+// served from cache.
+func warmHealth() {
+	for _, c := range healthChecks() {
+		go c.get()
 	}
-	req, err := http.NewRequest("GET", url, nil)
+}
+
+func doGet(ctx context.Context, client *http.Client, url string, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -38,8 +153,8 @@ func doGet(url string, timeout time.Duration, headers map[string]string, transpo
 	return client.Do(req)
 }
 
-func fetchJSONWithClient(url string, timeout time.Duration, headers map[string]string, transport *http.Transport, out any) error {
-	resp, err := doGet(url, timeout, headers, transport)
+func fetchJSONWithClient(ctx context.Context, client *http.Client, url string, headers map[string]string, out any) error {
+	resp, err := doGet(ctx, client, url, headers)
 	if err != nil {
 		return err
 	}
@@ -51,8 +166,8 @@ func fetchJSONWithClient(url string, timeout time.Duration, headers map[string]s
 	return json.NewDecoder(resp.Body).Decode(out)
 }
 
-func fetchRawWithClient(url string, timeout time.Duration, headers map[string]string, transport *http.Transport) (string, error) {
-	resp, err := doGet(url, timeout, headers, transport)
+func fetchRawWithClient(ctx context.Context, client *http.Client, url string, headers map[string]string) (string, error) {
+	resp, err := doGet(ctx, client, url, headers)
 	if err != nil {
 		return "", err
 	}
@@ -68,37 +183,52 @@ func fetchRawWithClient(url string, timeout time.Duration, headers map[string]st
 	return string(b), nil
 }
 
-func checkElasticsearch(base string) (any, error) {
+func checkElasticsearch(ctx context.Context, base string) (any, error) {
 	if base == "" {
 		return nil, fmt.Errorf("ELASTIC_URL unset")
 	}
 	u := strings.TrimRight(base, "/") + "/_cluster/health?pretty=false"
 	var out any
-	if err := fetchJSONWithClient(u, 3*time.Second, nil, nil, &out); err != nil {
+	if err := fetchJSONWithClient(ctx, http.DefaultClient, u, nil, &out); err != nil {
 		return nil, err
 	}
 	return out, nil
 }
 
-func checkKafkaDetailed(broker string) (any, error) {
-	if broker == "" {
-		return nil, fmt.Errorf("KAFKA_BROKER unset")
-	}
+var (
+	kafkaAdminOnce sync.Once
+	kafkaAdminCl   *kadm.Client
+	kafkaAdminErr  error
+)
 
-	seeds := []string{broker}
-	cl, err := kgo.NewClient(
-		kgo.SeedBrokers(seeds...),
-		kgo.AllowAutoTopicCreation(),
-	)
+// kafkaAdmin is the admin client /health and the lag monitor share. It is
+// created on first use and kept for the life of the process.
+func kafkaAdmin() (*kadm.Client, error) {
+	kafkaAdminOnce.Do(func() {
+		if len(cfg.Kafka.Seeds) == 0 {
+			kafkaAdminErr = fmt.Errorf("no kafka seeds configured")
+			return
+		}
+		opts, err := cfg.Kafka.ClientOpts()
+		if err != nil {
+			kafkaAdminErr = err
+			return
+		}
+		cl, err := kgo.NewClient(opts...)
+		if err != nil {
+			kafkaAdminErr = err
+			return
+		}
+		kafkaAdminCl = kadm.NewClient(cl)
+	})
+	return kafkaAdminCl, kafkaAdminErr
+}
+
+func checkKafkaDetailed(ctx context.Context) (any, error) {
+	admin, err := kafkaAdmin()
 	if err != nil {
 		return nil, err
 	}
-	defer cl.Close()
-
-	admin := kadm.NewClient(cl)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
 
 	topicsMeta, err := admin.ListTopics(ctx)
 	if err != nil {
@@ -135,11 +265,12 @@ func checkKafkaDetailed(broker string) (any, error) {
 		"consumer_groups": consumerGroups,
 	}, nil
 }
-func checkKafka(metricsURL string) (any, error) {
+
+func checkKafka(ctx context.Context, metricsURL string) (any, error) {
 	if metricsURL == "" {
 		return nil, fmt.Errorf("KAFKA_METRICS_URL unset")
 	}
-	raw, err := fetchRawWithClient(metricsURL, 10*time.Second, nil, nil)
+	raw, err := fetchRawWithClient(ctx, http.DefaultClient, metricsURL, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -153,24 +284,49 @@ func checkKafka(metricsURL string) (any, error) {
 	return map[string]any{"metrics_snippet": snippet}, nil
 }
 
-func checkKubernetes(apiURL string) (any, error) {
+// k8sConn is the Kubernetes API connection, built once from the service
+// account (or cfg.Health) on first use.
+type k8sConn struct {
+	url       string
+	headers   map[string]string
+	client    *http.Client
+	clientset *kubernetes.Clientset
+}
+
+var (
+	k8sOnce    sync.Once
+	k8sShared  *k8sConn
+	k8sConnErr error
+)
+
+func kubernetesConn() (*k8sConn, error) {
+	k8sOnce.Do(func() {
+		k8sShared, k8sConnErr = newK8sConn(cfg.Health.K8sAPI)
+	})
+	return k8sShared, k8sConnErr
+}
+
+func newK8sConn(apiURL string) (*k8sConn, error) {
 	if apiURL == "" {
 		apiURL = "https://kubernetes.default.svc"
 	}
-	headers := map[string]string{}
-	var transport *http.Transport
+	c := &k8sConn{
+		url:     strings.TrimRight(apiURL, "/"),
+		headers: map[string]string{},
+		client:  &http.Client{},
+	}
 
-	token := os.Getenv("K8S_BEARER_TOKEN")
+	token := cfg.Health.K8sBearerToken
 	if token == "" {
 		if b, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/token"); err == nil {
 			token = string(b)
 		}
 	}
 	if token != "" {
-		headers["Authorization"] = "Bearer " + token
+		c.headers["Authorization"] = "Bearer " + token
 	}
 
-	caPath := os.Getenv("K8S_CA_PATH")
+	caPath := cfg.Health.K8sCAPath
 	if caPath == "" {
 		defaultPath := "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
 		if _, err := os.Stat(defaultPath); err == nil {
@@ -187,90 +343,151 @@ func checkKubernetes(apiURL string) (any, error) {
 		if !pool.AppendCertsFromPEM(b) {
 			return nil, fmt.Errorf("failed to parse CA file")
 		}
-		transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		c.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
 	}
 
-	u := strings.TrimRight(apiURL, "/")
-	tryURLs := []string{u + "/healthz", u + "/readyz", u}
+	restCfg := &rest.Config{Host: c.url, BearerToken: token}
+	if caPath != "" {
+		restCfg.TLSClientConfig.CAFile = caPath
+	} else {
+		restCfg.TLSClientConfig.Insecure = true
+	}
+	clientset, err := kubernetes.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create clientset: %w", err)
+	}
+	c.clientset = clientset
+	return c, nil
+}
+
+func checkKubernetes(ctx context.Context) (any, error) {
+	c, err := kubernetesConn()
+	if err != nil {
+		return nil, err
+	}
 
 	var lastErr error
-	for _, candidate := range tryURLs {
-		var out any
-		if err := fetchJSONWithClient(candidate, 10*time.Second, headers, transport, &out); err == nil {
-			nodes, nErr := getClusterNodes(u, token, caPath)
-			if nErr != nil {
-				return map[string]any{"api": out, "nodes_error": nErr.Error()}, nil
-			}
-			return map[string]any{"api": out, "nodes": nodes}, nil
-		} else {
-			if raw, rErr := fetchRawWithClient(candidate, 10*time.Second, headers, transport); rErr == nil {
-				nodes, nErr := getClusterNodes(u, token, caPath)
-				if nErr != nil {
-					return map[string]any{"status": "ok", "message": raw, "nodes_error": nErr.Error()}, nil
-				}
-				return map[string]any{"status": "ok", "message": raw, "nodes": nodes}, nil
-			}
+	for _, candidate := range []string{c.url + "/healthz", c.url + "/readyz", c.url} {
+		raw, err := fetchRawWithClient(ctx, c.client, candidate, c.headers)
+		if err != nil {
 			lastErr = err
+			continue
 		}
+		var out map[string]any
+		if json.Unmarshal([]byte(raw), &out) == nil {
+			out = map[string]any{"api": out}
+		} else {
+			out = map[string]any{"status": "ok", "message": raw}
+		}
+		if nodes, nErr := getClusterNodes(ctx, c.clientset); nErr != nil {
+			out["nodes_error"] = nErr.Error()
+		} else {
+			out["nodes"] = nodes
+		}
+		if workloads, wErr := getWorkloads(ctx, c.clientset, cfg.Health.Namespace, cfg.Health.EventWindow); wErr != nil {
+			out["workloads_error"] = wErr.Error()
+		} else {
+			out["workloads"] = workloads
+		}
+		return out, nil
 	}
 	return nil, lastErr
 }
 
+// healthHandler serves GET /health. ?component=kafka,pipeline narrows it
+// to some components; ?summary=1 returns statuses only.
 func healthHandler() http.Handler {
-	k8sAPI := os.Getenv("K8S_METRICS_API")
-
-	esURL := os.Getenv("ELASTIC_URL")
-	if esURL == "" {
-		esURL = "http://elasticsearch-cluster-master.elasticsearch.svc:9200"
-	}
-
-	kafkaMetricsURL := os.Getenv("KAFKA_BROKER_METRICS")
-	if kafkaMetricsURL == "" {
-		kafkaMetricsURL = "http://redpanda.kafka.svc.cluster.local:9644/metrics"
-	}
-
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h := ClusterHealth{Status: "ok"}
-		upCount := 0
-		downCount := 0
-		unknownCount := 0
-		total := 0
-
-		total++
-		if out, err := checkElasticsearch(esURL); err != nil {
-			h.Elasticsearch = map[string]any{"status": "down", "error": err.Error()}
-			downCount++
-		} else {
-			h.Elasticsearch = out
-			upCount++
+		valid := healthComponents()
+		want := valid
+		if q := r.URL.Query().Get("component"); q != "" {
+			want = nil
+			for _, name := range strings.Split(q, ",") {
+				name = strings.TrimSpace(name)
+				if !slices.Contains(valid, name) {
+					http.Error(w, fmt.Sprintf("unknown component %q; one of %s", name, strings.Join(valid, ", ")), http.StatusBadRequest)
+					return
+				}
+				want = append(want, name)
+			}
 		}
 
-		total++
-		if out, err := checkKafkaDetailed(os.Getenv("KAFKA_BROKER")); err != nil {
-			h.Kafka = map[string]any{"status": "down", "error": err.Error()}
-			downCount++
-		} else {
-			h.Kafka = out
-			upCount++
+		// Concurrently, though only a cold cache makes any of them wait.
+		results := map[string]checkResult{}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for _, c := range healthChecks() {
+			if !slices.Contains(want, c.name) {
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := c.get()
+				mu.Lock()
+				results[c.name] = res
+				mu.Unlock()
+			}()
 		}
-		if out, err := checkKubernetes(k8sAPI); err != nil {
-			h.K8s = map[string]any{"status": "down", "error": err.Error()}
-			downCount++
-		} else {
-			h.K8s = out
-			upCount++
+		wg.Wait()
+
+		h := ClusterHealth{Checks: map[string]CheckInfo{}}
+		summary := HealthSummary{Components: map[string]string{}}
+		total, down, lagging := 0, 0, false
+		for name, res := range results {
+			info := CheckInfo{Status: "up", CheckedAt: res.at, TookMS: res.took.Milliseconds()}
+			out := res.out
+			if res.err != nil {
+				info.Status, info.Error = "down", res.err.Error()
+				out = map[string]any{"status": "down", "error": res.err.Error()}
+				down++
+			}
+			total++
+			h.Checks[name] = info
+			summary.Components[name] = info.Status
+			switch name {
+			case "elasticsearch":
+				h.Elasticsearch = out
+			case "kafka":
+				h.Kafka = out
+			case "kubernetes":
+				h.K8s = out
+			}
+		}
+		if !onKafka() && slices.Contains(want, "kafka") {
+			// Not part of this deployment, so not counted either way.
+			h.Kafka = map[string]any{"status": "disabled", "bus": cfg.Bus.Kind}
+			summary.Components["kafka"] = "disabled"
+		}
+		if lagMon != nil && slices.Contains(want, "pipeline") {
+			report := lagMon.Report()
+			h.Pipeline = &report
+			summary.Components["pipeline"] = report.Status
+			// Everything answering but a stage falling behind is
+			// degraded.
+			lagging = report.Status == "degraded"
 		}
 
-		if downCount == total {
+		status := http.StatusOK
+		switch {
+		case total > 0 && down == total:
 			h.Status = "down"
-			w.WriteHeader(http.StatusServiceUnavailable)
-		} else if downCount > 0 || unknownCount > 0 {
+			status = http.StatusServiceUnavailable
+		case down > 0 || lagging:
 			h.Status = "degraded"
-		} else {
+		default:
 			h.Status = "ok"
 		}
+		summary.Status = h.Status
 
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(h)
+		w.WriteHeader(status)
+		var body any = h
+		if ok, _ := strconv.ParseBool(r.URL.Query().Get("summary")); ok {
+			body = summary
+		}
+		if err := json.NewEncoder(w).Encode(body); err != nil {
+			log.Printf("[WARN] Writing /health failed: %v", err)
+		}
 	})
 }
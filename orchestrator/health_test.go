@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/exploravis/config"
+)
+
+// gatedCheck is a health check whose runs block until released, each
+// returning how many runs there have been.
+type gatedCheck struct {
+	*healthCheck
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func newGatedCheck() *gatedCheck {
+	g := &gatedCheck{release: make(chan struct{})}
+	g.healthCheck = &healthCheck{name: "test", timeout: time.Minute, run: func(ctx context.Context) (any, error) {
+		n := g.calls.Add(1)
+		<-g.release
+		return n, nil
+	}}
+	return g
+}
+
+// getAsync calls get n times concurrently and returns the results once all
+// are in.
+func (g *gatedCheck) getAsync(n int) <-chan []checkResult {
+	out := make(chan []checkResult, 1)
+	go func() {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		var results []checkResult
+		for range n {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				res := g.get()
+				mu.Lock()
+				results = append(results, res)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+		out <- results
+	}()
+	return out
+}
+
+func withHealthCacheTTL(t *testing.T, ttl time.Duration) {
+	t.Helper()
+	saved := cfg.Health.CacheTTL
+	cfg.Health.CacheTTL = ttl
+	t.Cleanup(func() { cfg.Health.CacheTTL = saved })
+}
+
+func TestHealthCheckCache(t *testing.T) {
+	withHealthCacheTTL(t, 50*time.Millisecond)
+	g := newGatedCheck()
+
+	// Cold start: every caller waits for the one refresh.
+	cold := g.getAsync(5)
+	select {
+	case <-cold:
+		t.Fatal("cold get returned before the check ran")
+	case <-time.After(20 * time.Millisecond):
+	}
+	g.release <- struct{}{}
+	for _, res := range <-cold {
+		if res.out != int32(1) {
+			t.Errorf("cold get = %v, want the first run", res.out)
+		}
+	}
+	if n := g.calls.Load(); n != 1 {
+		t.Fatalf("%d runs for concurrent cold gets, want 1", n)
+	}
+
+	// Fresh: served from cache.
+	if res := g.get(); res.out != int32(1) || g.calls.Load() != 1 {
+		t.Errorf("fresh get = %v after %d runs", res.out, g.calls.Load())
+	}
+
+	// Stale: the old result comes back at once while one refresh runs.
+	time.Sleep(60 * time.Millisecond)
+	select {
+	case results := <-g.getAsync(5):
+		for _, res := range results {
+			if res.out != int32(1) {
+				t.Errorf("stale get = %v, want the cached result", res.out)
+			}
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stale get waited for the refresh")
+	}
+	waitFor(t, func() bool { return g.calls.Load() == 2 })
+	if res := g.get(); res.out != int32(1) {
+		t.Errorf("get during the refresh = %v", res.out)
+	}
+	if n := g.calls.Load(); n != 2 {
+		t.Errorf("%d runs, want a single refresh in flight", n)
+	}
+
+	g.release <- struct{}{}
+	waitFor(t, func() bool { return g.get().out == int32(2) })
+}
+
+// waitFor polls cond for up to a second.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met within a second")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// stubHealth swaps in checks that answer at once, with a failing kafka and
+// a lagging pipeline.
+func stubHealth(t *testing.T) {
+	t.Helper()
+	withHealthCacheTTL(t, time.Hour)
+	savedBus, savedLag := cfg.Bus, lagMon
+	healthOnce.Do(func() {})
+	savedChecks := allHealthChecks
+
+	cfg.Bus = config.Bus{Kind: "kafka"}
+	ok := func(context.Context) (any, error) { return map[string]any{"status": "green"}, nil }
+	allHealthChecks = []*healthCheck{
+		{name: "elasticsearch", timeout: time.Second, run: ok},
+		{name: "kafka", timeout: time.Second, run: func(context.Context) (any, error) { return nil, errors.New("no brokers") }},
+		{name: "kubernetes", timeout: time.Second, run: ok},
+	}
+	lagMon = testLagMonitor(1, reading{100, 0}, reading{200, 10}, reading{300, 20})
+	t.Cleanup(func() { cfg.Bus, lagMon, allHealthChecks = savedBus, savedLag, savedChecks })
+}
+
+func TestHealthHandler(t *testing.T) {
+	stubHealth(t)
+	tests := []struct {
+		query      string
+		code       int
+		status     string
+		components []string
+	}{
+		{"", http.StatusOK, "degraded", []string{"elasticsearch", "kafka", "kubernetes", "pipeline"}},
+		{"component=elasticsearch", http.StatusOK, "ok", []string{"elasticsearch"}},
+		{"component=elasticsearch,%20kubernetes", http.StatusOK, "ok", []string{"elasticsearch", "kubernetes"}},
+		{"component=kafka", http.StatusServiceUnavailable, "down", []string{"kafka"}},
+		{"component=pipeline", http.StatusOK, "degraded", []string{"pipeline"}},
+		{"component=elasticsearch,kafka", http.StatusOK, "degraded", []string{"elasticsearch", "kafka"}},
+		{"component=redis", http.StatusBadRequest, "", nil},
+	}
+	for _, tt := range tests {
+		for _, summary := range []bool{false, true} {
+			q := tt.query
+			if summary {
+				q += "&summary=1"
+			}
+			rec := httptest.NewRecorder()
+			healthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/health?"+q, nil))
+			if rec.Code != tt.code {
+				t.Errorf("%s: code %d, want %d", q, rec.Code, tt.code)
+				continue
+			}
+			if tt.code == http.StatusBadRequest {
+				continue
+			}
+
+			var got []string
+			var status string
+			if summary {
+				var s HealthSummary
+				json.Unmarshal(rec.Body.Bytes(), &s)
+				for name := range s.Components {
+					got = append(got, name)
+				}
+				status = s.Status
+			} else {
+				var h ClusterHealth
+				json.Unmarshal(rec.Body.Bytes(), &h)
+				for name := range h.Checks {
+					got = append(got, name)
+				}
+				if h.Pipeline != nil {
+					got = append(got, "pipeline")
+				}
+				if h.Checks["kafka"].Status == "down" && h.Kafka == nil {
+					t.Errorf("%s: kafka down without its error body", q)
+				}
+				status = h.Status
+			}
+			slices.Sort(got)
+			if status != tt.status || !slices.Equal(got, tt.components) {
+				t.Errorf("%s: %s with %v, want %s with %v", q, status, got, tt.status, tt.components)
+			}
+		}
+	}
+}
+
+func TestHealthSummaryOmitsBodies(t *testing.T) {
+	stubHealth(t)
+	rec := httptest.NewRecorder()
+	healthHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/health?summary=true", nil))
+	var body map[string]any
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if _, ok := body["elasticsearch"]; ok || body["components"] == nil {
+		t.Errorf("summary = %s", rec.Body)
+	}
+	want := map[string]any{"elasticsearch": "up", "kafka": "down", "kubernetes": "up", "pipeline": "degraded"}
+	got, _ := body["components"].(map[string]any)
+	for name, status := range want {
+		if got[name] != status {
+			t.Errorf("summary components = %v, want %v", got, want)
+			break
+		}
+	}
+}
@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// Scan results live in a series of indices behind two aliases: writers only
+// know scansWriteAlias (which ILM rolls over), readers only know
+// scansReadAlias. Bump scansTemplateVersion whenever scansMappings changes
+// and run `orchestrator reindex` to move existing data onto it.
+const (
+	scansTemplateVersion = 2
+	scansTemplateName    = "exploravis-scans"
+	scansILMPolicy       = "exploravis-scans"
+	scansWriteAlias      = "scans-write"
+	scansReadAlias       = "scans-read"
+	legacyScansIndex     = "scans-000001"
+
+	// scansLatestIndex holds one document per ip+port+protocol, kept current
+	// by elasticsearch-worker's "latest" index mode. It does not roll over.
+	scansLatestIndex = "scans-latest"
+)
+
+// scansSearchIndex picks what /scans and /stats search: the full history by
+// default, or only the current state of each service with view=latest.
+func scansSearchIndex(params map[string][]string) string {
+	if v := params["view"]; len(v) > 0 && v[0] == "latest" {
+		return scansLatestIndex
+	}
+	return scansReadAlias
+}
+
+// scansMappings pins the fields that dynamic mapping gets wrong or that
+// queries rely on. Unknown fields are still mapped dynamically.
+var scansMappings = map[string]any{
+	"dynamic": true,
+	"properties": map[string]any{
+		"scan_id":   textWithKeyword(),
+		"ip":        textWithKeyword(),
+		"port":      map[string]any{"type": "integer"},
+		"timestamp": map[string]any{"type": "long"},
+		"protocol":  textWithKeyword(),
+		"service":   textWithKeyword(),
+		"banner":    textWithKeyword(),
+		"raw_tcp":   map[string]any{"type": "text"},
+		"meta": map[string]any{
+			"properties": map[string]any{
+				"geo": map[string]any{
+					"properties": map[string]any{
+						"country":  textWithKeyword(),
+						"city":     textWithKeyword(),
+						"location": map[string]any{"type": "geo_point"},
+					},
+				},
+				"asn": map[string]any{
+					"properties": map[string]any{
+						"number": map[string]any{"type": "long"},
+						"org":    textWithKeyword(),
+					},
+				},
+			},
+		},
+	},
+}
+
+// textWithKeyword mirrors what dynamic mapping produces for strings, so the
+// existing "<field>.keyword" queries keep working.
+func textWithKeyword() map[string]any {
+	return map[string]any{
+		"type": "text",
+		"fields": map[string]any{
+			"keyword": map[string]any{"type": "keyword", "ignore_above": 256},
+		},
+	}
+}
+
+func scansIndexName(version, generation int) string {
+	return fmt.Sprintf("scans-v%d-%06d", version, generation)
+}
+
+// esResult closes res and turns ES errors into Go errors.
+func esResult(res *esapi.Response, err error) error {
+	return esDecode(res, err, nil)
+}
+
+// esDecode is esResult that also decodes the body into out when out is
+// non-nil.
+func esDecode(res *esapi.Response, err error, out any) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("%s", res.String())
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func jsonBody(v any) *bytes.Reader {
+	b, _ := json.Marshal(v)
+	return bytes.NewReader(b)
+}
+
+// ------------------------
+// Template and ILM
+// ------------------------
+
+func scansILMBody() map[string]any {
+	phases := map[string]any{
+		"hot": map[string]any{
+			"actions": map[string]any{
+				"rollover": map[string]any{
+					"max_age":                cfg.Scans.RolloverMaxAge,
+					"max_primary_shard_size": cfg.Scans.RolloverMaxSize,
+				},
+			},
+		},
+	}
+	// Retention is opt-in: scan history is kept unless scans.retention is set.
+	if retention := cfg.Scans.Retention; retention != "" {
+		phases["delete"] = map[string]any{
+			"min_age": retention,
+			"actions": map[string]any{"delete": map[string]any{}},
+		}
+	}
+	return map[string]any{"policy": map[string]any{"phases": phases}}
+}
+
+func scansTemplateBody() map[string]any {
+	return map[string]any{
+		// scans-0* covers rollovers of the adopted legacy scans-000001.
+		"index_patterns": []string{"scans-v*", "scans-0*"},
+		"version":        scansTemplateVersion,
+		"priority":       100,
+		"template": map[string]any{
+			"settings": map[string]any{
+				"index.lifecycle.name":           scansILMPolicy,
+				"index.lifecycle.rollover_alias": scansWriteAlias,
+			},
+			"mappings": scansMappings,
+		},
+		"_meta": map[string]any{"managed_by": "exploravis-orchestrator"},
+	}
+}
+
+func installScansTemplate(ctx context.Context, es *elasticsearch.Client) error {
+	if err := esResult(es.ILM.PutLifecycle(scansILMPolicy,
+		es.ILM.PutLifecycle.WithContext(ctx),
+		es.ILM.PutLifecycle.WithBody(jsonBody(scansILMBody())),
+	)); err != nil {
+		return fmt.Errorf("put ILM policy: %w", err)
+	}
+	if err := esResult(es.Indices.PutIndexTemplate(scansTemplateName, jsonBody(scansTemplateBody()),
+		es.Indices.PutIndexTemplate.WithContext(ctx),
+	)); err != nil {
+		return fmt.Errorf("put index template: %w", err)
+	}
+	return nil
+}
+
+// ------------------------
+// Aliases
+// ------------------------
+
+// aliasIndices returns the indices currently behind alias in name order,
+// and which one of them (if any) is the write index.
+func aliasIndices(ctx context.Context, es *elasticsearch.Client, alias string) ([]string, string, error) {
+	res, err := es.Indices.GetAlias(
+		es.Indices.GetAlias.WithContext(ctx),
+		es.Indices.GetAlias.WithName(alias),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	if res.StatusCode == 404 {
+		res.Body.Close()
+		return nil, "", nil
+	}
+
+	var out map[string]struct {
+		Aliases map[string]struct {
+			IsWriteIndex *bool `json:"is_write_index"`
+		} `json:"aliases"`
+	}
+	if err := esDecode(res, nil, &out); err != nil {
+		return nil, "", err
+	}
+
+	indices := make([]string, 0, len(out))
+	writeIndex := ""
+	for index, a := range out {
+		indices = append(indices, index)
+		if w := a.Aliases[alias].IsWriteIndex; w != nil && *w {
+			writeIndex = index
+		} else if w == nil && len(out) == 1 {
+			writeIndex = index
+		}
+	}
+	slices.Sort(indices)
+	return indices, writeIndex, nil
+}
+
+// attachScansILM puts index under the scans ILM policy. ILM rolls index
+// over through scansWriteAlias, so it must be the alias's write index first.
+func attachScansILM(ctx context.Context, es *elasticsearch.Client, index string) error {
+	if err := esResult(es.Indices.PutSettings(jsonBody(map[string]any{
+		"index.lifecycle.name":           scansILMPolicy,
+		"index.lifecycle.rollover_alias": scansWriteAlias,
+	}), es.Indices.PutSettings.WithContext(ctx), es.Indices.PutSettings.WithIndex(index))); err != nil {
+		return fmt.Errorf("attach ILM to %s: %w", index, err)
+	}
+	return nil
+}
+
+func indexExists(ctx context.Context, es *elasticsearch.Client, index string) (bool, error) {
+	res, err := es.Indices.Exists([]string{index}, es.Indices.Exists.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+	return res.StatusCode == 200, nil
+}
+
+// ensureScansIndices installs the template and ILM policy and makes sure the
+// read and write aliases exist. A pre-alias deployment's scans-000001 is
+// adopted in place so no data moves; its mapping only changes once ILM (or
+// `orchestrator reindex`) rolls it over.
+func ensureScansIndices(es *elasticsearch.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := installScansTemplate(ctx, es); err != nil {
+		return err
+	}
+	if err := ensureLatestIndex(ctx, es); err != nil {
+		return fmt.Errorf("ensure %s: %w", scansLatestIndex, err)
+	}
+
+	_, writeIndex, err := aliasIndices(ctx, es, scansWriteAlias)
+	if err != nil {
+		return fmt.Errorf("lookup %s: %w", scansWriteAlias, err)
+	}
+	if writeIndex != "" {
+		return nil
+	}
+
+	legacy, err := indexExists(ctx, es, legacyScansIndex)
+	if err != nil {
+		return err
+	}
+	if legacy {
+		log.Printf("[ES] Adopting legacy index %s behind %s/%s", legacyScansIndex, scansWriteAlias, scansReadAlias)
+		if err := attachScansILM(ctx, es, legacyScansIndex); err != nil {
+			return err
+		}
+		return esResult(es.Indices.UpdateAliases(jsonBody(map[string]any{
+			"actions": []map[string]any{
+				{"add": map[string]any{"index": legacyScansIndex, "alias": scansWriteAlias, "is_write_index": true}},
+				{"add": map[string]any{"index": legacyScansIndex, "alias": scansReadAlias}},
+			},
+		}), es.Indices.UpdateAliases.WithContext(ctx)))
+	}
+
+	index := scansIndexName(scansTemplateVersion, 1)
+	log.Printf("[ES] Bootstrapping %s behind %s/%s", index, scansWriteAlias, scansReadAlias)
+	return esResult(es.Indices.Create(index,
+		es.Indices.Create.WithContext(ctx),
+		es.Indices.Create.WithBody(jsonBody(map[string]any{
+			"aliases": map[string]any{
+				scansWriteAlias: map[string]any{"is_write_index": true},
+				scansReadAlias:  map[string]any{},
+			},
+		})),
+	))
+}
+
+// ensureLatestIndex creates the latest-state index with the same mappings
+// as the history indices. Its name is outside the template's patterns, so
+// it gets no ILM policy.
+func ensureLatestIndex(ctx context.Context, es *elasticsearch.Client) error {
+	exists, err := indexExists(ctx, es, scansLatestIndex)
+	if err != nil || exists {
+		return err
+	}
+	log.Printf("[ES] Creating %s", scansLatestIndex)
+	return esResult(es.Indices.Create(scansLatestIndex,
+		es.Indices.Create.WithContext(ctx),
+		es.Indices.Create.WithBody(jsonBody(map[string]any{"mappings": scansMappings})),
+	))
+}
+
+// ------------------------
+// Reindex
+// ------------------------
+
+// reindexPollInterval is how often runReindex checks on its task.
+var reindexPollInterval = 5 * time.Second
+
+// reindexScans moves everything behind the read alias into a fresh index
+// built from the current template, without interrupting reads or writes:
+//
+//  1. create the new index with ILM off, as it is not the write index yet;
+//  2. point the write alias at the new index and hand it to ILM, so the old
+//     indices get no more documents;
+//  3. copy all documents of the old indices into the new one, skipping the
+//     ones already written there (document IDs are deterministic, so those
+//     are the newer copies);
+//  4. point the read alias at the new index.
+//
+// Reads keep going to the old indices until step 4, so results written
+// during the copy are only searchable (outside view=latest) once it is done.
+func reindexScans(es *elasticsearch.Client, deleteOld bool) error {
+	ctx := context.Background()
+
+	if err := installScansTemplate(ctx, es); err != nil {
+		return err
+	}
+	if err := ensureLatestIndex(ctx, es); err != nil {
+		return fmt.Errorf("ensure %s: %w", scansLatestIndex, err)
+	}
+
+	oldIndices, oldWrite, err := aliasIndices(ctx, es, scansReadAlias)
+	if err != nil {
+		return err
+	}
+	if len(oldIndices) == 0 {
+		return fmt.Errorf("alias %s has no indices, nothing to reindex", scansReadAlias)
+	}
+	if _, w, err := aliasIndices(ctx, es, scansWriteAlias); err == nil && w != "" {
+		oldWrite = w
+	}
+
+	target := ""
+	for gen := 1; ; gen++ {
+		name := scansIndexName(scansTemplateVersion, gen)
+		exists, err := indexExists(ctx, es, name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			target = name
+			break
+		}
+	}
+
+	log.Printf("[REINDEX] %s -> %s", strings.Join(oldIndices, ","), target)
+	// The template's rollover_alias would put the index into ILM error
+	// until it becomes the write index.
+	if err := esResult(es.Indices.Create(target,
+		es.Indices.Create.WithContext(ctx),
+		es.Indices.Create.WithBody(jsonBody(map[string]any{
+			"settings": map[string]any{"index.lifecycle.name": ""},
+		})),
+	)); err != nil {
+		return fmt.Errorf("create %s: %w", target, err)
+	}
+
+	if oldWrite != "" {
+		// Done with writes, so ILM stops waiting to roll it over.
+		if err := esResult(es.Indices.PutSettings(jsonBody(map[string]any{
+			"index.lifecycle.indexing_complete": true,
+		}), es.Indices.PutSettings.WithContext(ctx), es.Indices.PutSettings.WithIndex(oldWrite))); err != nil {
+			return fmt.Errorf("complete %s: %w", oldWrite, err)
+		}
+	}
+	if err := esResult(es.Indices.UpdateAliases(jsonBody(map[string]any{"actions": writeSwapActions(target, oldWrite)}),
+		es.Indices.UpdateAliases.WithContext(ctx))); err != nil {
+		return fmt.Errorf("swap write alias: %w", err)
+	}
+	log.Printf("[REINDEX] %s now writes to %s", scansWriteAlias, target)
+	if err := attachScansILM(ctx, es, target); err != nil {
+		return err
+	}
+
+	if err := runReindex(ctx, es, oldIndices, target); err != nil {
+		return err
+	}
+
+	if err := esResult(es.Indices.UpdateAliases(jsonBody(map[string]any{"actions": readSwapActions(target, oldIndices)}),
+		es.Indices.UpdateAliases.WithContext(ctx))); err != nil {
+		return fmt.Errorf("swap read alias: %w", err)
+	}
+	log.Printf("[REINDEX] %s now reads from %s", scansReadAlias, target)
+
+	if deleteOld {
+		if err := esResult(es.Indices.Delete(oldIndices, es.Indices.Delete.WithContext(ctx))); err != nil {
+			return fmt.Errorf("delete old indices: %w", err)
+		}
+		log.Printf("[REINDEX] Deleted %s", strings.Join(oldIndices, ","))
+	}
+	return nil
+}
+
+// writeSwapActions makes target the write index of scansWriteAlias. The old
+// write index stays behind the alias, as ILM rollover leaves it.
+func writeSwapActions(target, oldWrite string) []map[string]any {
+	actions := []map[string]any{
+		{"add": map[string]any{"index": target, "alias": scansWriteAlias, "is_write_index": true}},
+	}
+	if oldWrite != "" {
+		actions = append(actions, map[string]any{"add": map[string]any{"index": oldWrite, "alias": scansWriteAlias, "is_write_index": false}})
+	}
+	return actions
+}
+
+// readSwapActions moves scansReadAlias from the old indices to target in one
+// atomic update, and takes the old indices out of the write alias too.
+func readSwapActions(target string, oldIndices []string) []map[string]any {
+	actions := []map[string]any{
+		{"add": map[string]any{"index": target, "alias": scansReadAlias}},
+	}
+	for _, idx := range oldIndices {
+		actions = append(actions,
+			map[string]any{"remove": map[string]any{"index": idx, "alias": scansReadAlias}},
+			map[string]any{"remove": map[string]any{"index": idx, "alias": scansWriteAlias, "must_exist": false}},
+		)
+	}
+	return actions
+}
+
+// runReindex starts a server-side reindex task and waits for it to finish.
+// Documents that already exist in dest are left alone.
+func runReindex(ctx context.Context, es *elasticsearch.Client, src []string, dest string) error {
+	var started struct {
+		Task string `json:"task"`
+	}
+	res, err := es.Reindex(jsonBody(map[string]any{
+		"source":    map[string]any{"index": src},
+		"dest":      map[string]any{"index": dest, "op_type": "create"},
+		"conflicts": "proceed",
+	}),
+		es.Reindex.WithContext(ctx),
+		es.Reindex.WithWaitForCompletion(false),
+	)
+	if err := esDecode(res, err, &started); err != nil {
+		return fmt.Errorf("start reindex: %w", err)
+	}
+
+	for {
+		time.Sleep(reindexPollInterval)
+
+		var task struct {
+			Completed bool `json:"completed"`
+			Task      struct {
+				Status struct {
+					Total   int `json:"total"`
+					Created int `json:"created"`
+					Updated int `json:"updated"`
+				} `json:"status"`
+			} `json:"task"`
+			Error    map[string]any `json:"error"`
+			Response struct {
+				Failures []any `json:"failures"`
+			} `json:"response"`
+		}
+		res, err := es.Tasks.Get(started.Task, es.Tasks.Get.WithContext(ctx))
+		if err := esDecode(res, err, &task); err != nil {
+			return fmt.Errorf("poll reindex task %s: %w", started.Task, err)
+		}
+
+		st := task.Task.Status
+		log.Printf("[REINDEX] %s: %d/%d documents", started.Task, st.Created+st.Updated, st.Total)
+		if !task.Completed {
+			continue
+		}
+		if task.Error != nil {
+			return fmt.Errorf("reindex task %s failed: %v", started.Task, task.Error)
+		}
+		if len(task.Response.Failures) > 0 {
+			return fmt.Errorf("reindex task %s had %d failures: %v", started.Task, len(task.Response.Failures), task.Response.Failures[0])
+		}
+		return nil
+	}
+}
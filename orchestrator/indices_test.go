@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// fakeES answers by "METHOD /path" and records every request it gets.
+type fakeES struct {
+	routes map[string]func(body []byte) (int, string)
+
+	mu       sync.Mutex
+	requests []esRequest
+}
+
+type esRequest struct {
+	route string
+	body  map[string]any
+}
+
+func (f *fakeES) client(t *testing.T) *elasticsearch.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.Method + " " + r.URL.Path
+		body, _ := io.ReadAll(r.Body)
+		req := esRequest{route: route}
+		json.Unmarshal(body, &req.body)
+		f.mu.Lock()
+		f.requests = append(f.requests, req)
+		f.mu.Unlock()
+
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		handle, ok := f.routes[route]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"type":"index_not_found_exception"},"status":404}`)
+			return
+		}
+		status, out := handle(body)
+		w.WriteHeader(status)
+		fmt.Fprint(w, out)
+	}))
+	t.Cleanup(srv.Close)
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return es
+}
+
+func reply(status int, body string) func([]byte) (int, string) {
+	return func([]byte) (int, string) { return status, body }
+}
+
+func TestAliasIndices(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		indices   []string
+		writeIdx  string
+		expectErr bool
+	}{
+		{name: "missing alias", status: 404, body: `{}`},
+		{
+			// An alias over a single index writes to it implicitly.
+			name:     "single index",
+			status:   200,
+			body:     `{"scans-000001": {"aliases": {"scans-write": {}}}}`,
+			indices:  []string{"scans-000001"},
+			writeIdx: "scans-000001",
+		},
+		{
+			name:   "rolled over",
+			status: 200,
+			body: `{
+				"scans-v2-000001": {"aliases": {"scans-write": {"is_write_index": false}}},
+				"scans-v2-000002": {"aliases": {"scans-write": {"is_write_index": true}}}
+			}`,
+			indices:  []string{"scans-v2-000001", "scans-v2-000002"},
+			writeIdx: "scans-v2-000002",
+		},
+		{
+			name:   "several indices, none writable",
+			status: 200,
+			body: `{
+				"scans-v1-000001": {"aliases": {"scans-write": {}}},
+				"scans-v1-000002": {"aliases": {"scans-write": {}}}
+			}`,
+			indices: []string{"scans-v1-000001", "scans-v1-000002"},
+		},
+		{name: "server error", status: 500, body: `{"error":"boom"}`, expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeES{routes: map[string]func([]byte) (int, string){
+				"GET /_alias/" + scansWriteAlias: reply(tt.status, tt.body),
+			}}
+			indices, write, err := aliasIndices(context.Background(), f.client(t), scansWriteAlias)
+			if (err != nil) != tt.expectErr {
+				t.Fatalf("err = %v", err)
+			}
+			if fmt.Sprint(indices) != fmt.Sprint(tt.indices) || write != tt.writeIdx {
+				t.Errorf("got %v write %q, want %v write %q", indices, write, tt.indices, tt.writeIdx)
+			}
+		})
+	}
+}
+
+// aliasActions renders actions as "verb index alias[ write=bool]" lines.
+func aliasActions(actions []map[string]any) []string {
+	var out []string
+	for _, a := range actions {
+		for verb, v := range a {
+			spec := v.(map[string]any)
+			line := fmt.Sprintf("%s %s %s", verb, spec["index"], spec["alias"])
+			if w, ok := spec["is_write_index"]; ok {
+				line += fmt.Sprintf(" write=%v", w)
+			}
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+func TestAliasSwapActions(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []map[string]any
+		want []string
+	}{
+		{
+			name: "write swap keeps the old write index",
+			got:  writeSwapActions("scans-v3-000001", "scans-v2-000004"),
+			want: []string{
+				"add scans-v3-000001 scans-write write=true",
+				"add scans-v2-000004 scans-write write=false",
+			},
+		},
+		{
+			name: "write swap without a write index",
+			got:  writeSwapActions("scans-v3-000001", ""),
+			want: []string{"add scans-v3-000001 scans-write write=true"},
+		},
+		{
+			name: "read swap drops every old index from both aliases",
+			got:  readSwapActions("scans-v3-000001", []string{"scans-000001", "scans-v2-000004"}),
+			want: []string{
+				"add scans-v3-000001 scans-read",
+				"remove scans-000001 scans-read",
+				"remove scans-000001 scans-write",
+				"remove scans-v2-000004 scans-read",
+				"remove scans-v2-000004 scans-write",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := aliasActions(tt.got); !slices.Equal(got, tt.want) {
+				t.Errorf("actions:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+// The write alias moves before the copy, so nothing is written to the old
+// indices after they were copied, and the new index joins ILM only once it
+// is the write index.
+func TestReindexScansOrder(t *testing.T) {
+	reindexPollInterval = time.Millisecond
+	defer func() { reindexPollInterval = 5 * time.Second }()
+
+	ok := reply(200, `{"acknowledged": true}`)
+	f := &fakeES{routes: map[string]func([]byte) (int, string){
+		"PUT /_ilm/policy/" + scansILMPolicy:        ok,
+		"PUT /_index_template/" + scansTemplateName: ok,
+		"HEAD /" + scansLatestIndex:                 reply(200, ``),
+		// The target is the first free name.
+		"HEAD /scans-v2-000001": reply(200, ``),
+		"HEAD /scans-v2-000002": reply(200, ``),
+		"GET /_alias/" + scansReadAlias: reply(200, `{
+			"scans-v2-000001": {"aliases": {"scans-read": {}}},
+			"scans-v2-000002": {"aliases": {"scans-read": {}}}
+		}`),
+		"GET /_alias/" + scansWriteAlias: reply(200, `{
+			"scans-v2-000001": {"aliases": {"scans-write": {"is_write_index": false}}},
+			"scans-v2-000002": {"aliases": {"scans-write": {"is_write_index": true}}}
+		}`),
+		"PUT /scans-v2-000003":                    ok,
+		"PUT /scans-v2-000002/_settings":          ok,
+		"PUT /scans-v2-000003/_settings":          ok,
+		"POST /_aliases":                          ok,
+		"POST /_reindex":                          reply(200, `{"task": "node:1"}`),
+		"GET /_tasks/node:1":                      reply(200, `{"completed": true, "task": {"status": {"total": 3, "created": 3}}}`),
+		"DELETE /scans-v2-000001,scans-v2-000002": ok,
+	}}
+	if err := reindexScans(f.client(t), true); err != nil {
+		t.Fatal(err)
+	}
+
+	var steps []string
+	for _, r := range f.requests {
+		if !strings.HasPrefix(r.route, "HEAD ") && !strings.HasPrefix(r.route, "GET ") && !strings.HasPrefix(r.route, "PUT /_") {
+			steps = append(steps, r.route)
+		}
+	}
+	want := []string{
+		"PUT /scans-v2-000003",
+		"PUT /scans-v2-000002/_settings",
+		"POST /_aliases",
+		"PUT /scans-v2-000003/_settings",
+		"POST /_reindex",
+		"POST /_aliases",
+		"DELETE /scans-v2-000001,scans-v2-000002",
+	}
+	if !slices.Equal(steps, want) {
+		t.Fatalf("steps:\n%s\nwant:\n%s", strings.Join(steps, "\n"), strings.Join(want, "\n"))
+	}
+
+	// body is what the first request to route sent.
+	body := func(route string) map[string]any {
+		for _, r := range f.requests {
+			if r.route == route {
+				return r.body
+			}
+		}
+		return nil
+	}
+	settings, _ := body("PUT /scans-v2-000003")["settings"].(map[string]any)
+	if name, set := settings["index.lifecycle.name"]; !set || name != "" {
+		t.Errorf("target created with settings %v, want ILM off", settings)
+	}
+	if got := body("PUT /scans-v2-000002/_settings"); got["index.lifecycle.indexing_complete"] != true {
+		t.Errorf("old write index settings = %v", got)
+	}
+	if got := body("PUT /scans-v2-000003/_settings"); got["index.lifecycle.rollover_alias"] != scansWriteAlias {
+		t.Errorf("target settings after the swap = %v", got)
+	}
+	dest, _ := body("POST /_reindex")["dest"].(map[string]any)
+	if dest["index"] != "scans-v2-000003" || dest["op_type"] != "create" {
+		t.Errorf("reindex dest = %v, want create into the target", dest)
+	}
+	src, _ := body("POST /_reindex")["source"].(map[string]any)
+	if _, filtered := src["query"]; filtered {
+		t.Errorf("reindex source %v is filtered; the copy must be complete", src)
+	}
+	swap, _ := body("POST /_aliases")["actions"].([]any)
+	if len(swap) == 0 || !strings.Contains(fmt.Sprint(swap[0]), "scans-write") {
+		t.Errorf("first alias update = %v, want the write swap", swap)
+	}
+}
@@ -3,34 +3,18 @@ package main
 import (
 	"context"
 	"fmt"
-	"time"
+	"sort"
+	"strings"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
-func getClusterNodes(host, token, caPath string) ([]map[string]interface{}, error) {
-	config := &rest.Config{
-		Host:            host,
-		BearerToken:     token,
-		Timeout:         10 * time.Second,
-		TLSClientConfig: rest.TLSClientConfig{},
-	}
-
-	if caPath != "" {
-		config.TLSClientConfig.CAFile = caPath
-	} else {
-		config.TLSClientConfig.Insecure = true
-	}
-
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create clientset: %w", err)
-	}
-
-	nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+// getClusterNodes summarizes each node: addresses, readiness, capacity and
+// what it runs, without the full object.
+func getClusterNodes(ctx context.Context, clientset kubernetes.Interface) ([]map[string]interface{}, error) {
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to list nodes: %w", err)
 	}
@@ -43,13 +27,21 @@ func getClusterNodes(host, token, caPath string) ([]map[string]interface{}, erro
 		status := getNodeReadyStatus(node.Status.Conditions)
 
 		nodeInfo := map[string]interface{}{
-			"name":            node.Name,
-			"status":          status,
-			"internal_ip":     internalIP,
-			"external_ip":     externalIP,
-			"capacity_cpu":    node.Status.Capacity.Cpu().String(),
-			"capacity_memory": node.Status.Capacity.Memory().String(),
-			"node":            node,
+			"name":               node.Name,
+			"status":             status,
+			"internal_ip":        internalIP,
+			"external_ip":        externalIP,
+			"capacity_cpu":       node.Status.Capacity.Cpu().String(),
+			"capacity_memory":    node.Status.Capacity.Memory().String(),
+			"allocatable_cpu":    node.Status.Allocatable.Cpu().String(),
+			"allocatable_memory": node.Status.Allocatable.Memory().String(),
+			"roles":              getNodeRoles(node.Labels),
+			"pressure":           getNodePressure(node.Status.Conditions),
+			"unschedulable":      node.Spec.Unschedulable,
+			"kubelet_version":    node.Status.NodeInfo.KubeletVersion,
+			"os_image":           node.Status.NodeInfo.OSImage,
+			"architecture":       node.Status.NodeInfo.Architecture,
+			"created":            node.CreationTimestamp.Time,
 		}
 		results = append(results, nodeInfo)
 	}
@@ -57,6 +49,30 @@ func getClusterNodes(host, token, caPath string) ([]map[string]interface{}, erro
 	return results, nil
 }
 
+// getNodeRoles reads the node-role.kubernetes.io/<role> labels.
+func getNodeRoles(labels map[string]string) []string {
+	roles := make([]string, 0)
+	for key := range labels {
+		if role, ok := strings.CutPrefix(key, "node-role.kubernetes.io/"); ok && role != "" {
+			roles = append(roles, role)
+		}
+	}
+	sort.Strings(roles)
+	return roles
+}
+
+// getNodePressure lists the conditions other than Ready that are True,
+// e.g. MemoryPressure.
+func getNodePressure(conditions []v1.NodeCondition) []string {
+	pressure := make([]string, 0)
+	for _, condition := range conditions {
+		if condition.Type != v1.NodeReady && condition.Status == v1.ConditionTrue {
+			pressure = append(pressure, string(condition.Type))
+		}
+	}
+	return pressure
+}
+
 func getAddressByType(addresses []v1.NodeAddress, addressType v1.NodeAddressType) string {
 	for _, addr := range addresses {
 		if addr.Type == addressType {
@@ -1,49 +0,0 @@
-package main
-
-import (
-	"context"
-	"log"
-	"os"
-	"time"
-
-	"github.com/twmb/franz-go/pkg/kgo"
-)
-
-func newKafkaClient() *kgo.Client {
-	broker := os.Getenv("KAFKA_BROKER")
-	log.Println("Kafka broker:", broker)
-	if broker == "" {
-		broker = "redpanda-0.redpanda.kafka.svc.cluster.local:9093"
-	}
-	log.Println("Connecting to kafka broker...")
-	cl, err := kgo.NewClient(
-		kgo.SeedBrokers(broker),
-		kgo.DialTimeout(5*time.Second),
-		kgo.ProduceRequestTimeout(5*time.Second),
-		kgo.RecordPartitioner(kgo.RoundRobinPartitioner()),
-	)
-
-	log.Println("Connected")
-	if err != nil {
-		log.Fatalf("Kafka client error: %v", err)
-	}
-
-	return cl
-}
-
-func produceScanRequest(cl *kgo.Client, payload []byte, key string) {
-	record := &kgo.Record{
-		Topic: "ip_scan_request",
-		Key:   []byte(key),
-		Value: payload,
-	}
-
-	log.Println("Producing scan request...")
-	cl.Produce(context.Background(), record, func(_ *kgo.Record, err error) {
-		if err != nil {
-			log.Printf("failed to produce record: %v", err)
-		} else {
-			log.Println("Scan request produced successfully")
-		}
-	})
-}
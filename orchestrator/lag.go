@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+)
+
+// lagMonitor samples the lag of the stages' consumer groups in the
+// background, so /health can report rates and trends without a burst of
+// admin requests per call.
+type lagMonitor struct {
+	admin  *kadm.Client
+	groups []string
+	window time.Duration
+
+	mu      sync.Mutex
+	samples []lagSample // oldest first, spanning at most window
+	err     error       // of the latest sampling, if it failed
+}
+
+// lagSample is one reading of every group.
+type lagSample struct {
+	at     time.Time
+	groups map[string]groupSample
+	ends   map[string]int64 // end offsets summed per topic
+}
+
+type groupSample struct {
+	lag       kadm.DescribedGroupLag
+	total     int64 // lag over all partitions
+	committed int64 // committed offsets summed
+}
+
+// GroupLagReport is one stage in /health.
+type GroupLagReport struct {
+	Group   string `json:"group"`
+	State   string `json:"state"`
+	Members int    `json:"members"`
+	Lag     int64  `json:"lag"`
+	// ConsumeRate is records committed per second over the window.
+	ConsumeRate float64 `json:"consume_rate"`
+	// LagRate is how fast lag changed over the window, per second.
+	LagRate    float64              `json:"lag_rate"`
+	Status     string               `json:"status"`
+	Reason     string               `json:"reason,omitempty"`
+	Partitions []PartitionLagReport `json:"partitions,omitempty"`
+	Error      string               `json:"error,omitempty"`
+}
+
+type PartitionLagReport struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	Committed int64  `json:"committed"`
+	End       int64  `json:"end"`
+	Lag       int64  `json:"lag"`
+}
+
+// PipelineReport is the lag section of /health.
+type PipelineReport struct {
+	Status string           `json:"status"`
+	Stages []GroupLagReport `json:"stages"`
+	// ProduceRates is records appended per second to each consumed
+	// topic, over the window.
+	ProduceRates  map[string]float64 `json:"produce_rates"`
+	WindowSeconds float64            `json:"window_seconds"`
+	SampledAt     time.Time          `json:"sampled_at,omitzero"`
+	Error         string             `json:"error,omitempty"`
+}
+
+// minTrendSamples is how many samples a trend needs before a stage can be
+// called degraded: two could be one unlucky burst.
+const minTrendSamples = 3
+
+var lagMon *lagMonitor
+
+// startLagMonitor starts sampling cfg.Health.Groups every
+// cfg.Health.LagInterval until ctx is done.
+func startLagMonitor(ctx context.Context) {
+	admin, err := kafkaAdmin()
+	if err != nil {
+		log.Printf("[WARN] Lag monitor disabled: %v", err)
+		return
+	}
+	lagMon = &lagMonitor{
+		admin:  admin,
+		groups: cfg.Health.Groups,
+		window: cfg.Health.LagWindow,
+	}
+	go func() {
+		tick := time.NewTicker(cfg.Health.LagInterval)
+		defer tick.Stop()
+		for {
+			lagMon.sample(ctx)
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (m *lagMonitor) sample(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	lags, err := m.admin.Lag(ctx, m.groups...)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.err = err
+		return
+	}
+	m.err = nil
+
+	s := lagSample{at: time.Now(), groups: map[string]groupSample{}, ends: map[string]int64{}}
+	lags.Each(func(l kadm.DescribedGroupLag) {
+		g := groupSample{lag: l}
+		for _, p := range l.Lag.Sorted() {
+			if p.Err != nil {
+				continue
+			}
+			g.total += p.Lag
+			if p.Commit.At > 0 {
+				g.committed += p.Commit.At
+			}
+		}
+		s.groups[l.Group] = g
+	})
+	// A topic read by several groups is counted once.
+	seen := map[string]map[int32]bool{}
+	lags.Each(func(l kadm.DescribedGroupLag) {
+		for _, p := range l.Lag.Sorted() {
+			if p.End.Err != nil || seen[p.Topic][p.Partition] {
+				continue
+			}
+			if seen[p.Topic] == nil {
+				seen[p.Topic] = map[int32]bool{}
+			}
+			seen[p.Topic][p.Partition] = true
+			s.ends[p.Topic] += p.End.Offset
+		}
+	})
+
+	m.samples = append(m.samples, s)
+	cutoff := s.at.Add(-m.window)
+	for len(m.samples) > 1 && m.samples[0].at.Before(cutoff) {
+		m.samples = m.samples[1:]
+	}
+}
+
+// Report summarizes the samples in the window. A stage is degraded when
+// its lag never fell across the window and ended higher; steady lag,
+// however large, is a backlog the stage is keeping up with.
+func (m *lagMonitor) Report() PipelineReport {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r := PipelineReport{Status: "ok", ProduceRates: map[string]float64{}}
+	if m.err != nil {
+		r.Error = m.err.Error()
+	}
+	if len(m.samples) == 0 {
+		r.Status = "unknown"
+		return r
+	}
+	first, last := m.samples[0], m.samples[len(m.samples)-1]
+	r.SampledAt = last.at
+	span := last.at.Sub(first.at).Seconds()
+	r.WindowSeconds = span
+
+	if span > 0 {
+		for topic, end := range last.ends {
+			if start, ok := first.ends[topic]; ok {
+				r.ProduceRates[topic] = float64(end-start) / span
+			}
+		}
+	}
+
+	for _, group := range m.groups {
+		g, ok := last.groups[group]
+		if !ok {
+			r.Stages = append(r.Stages, GroupLagReport{Group: group, Status: "unknown", Error: "group not described"})
+			continue
+		}
+		st := GroupLagReport{
+			Group:   group,
+			State:   g.lag.State,
+			Members: len(g.lag.Members),
+			Lag:     g.total,
+			Status:  "ok",
+		}
+		if err := g.lag.Error(); err != nil {
+			st.Error = err.Error()
+			st.Status = "unknown"
+		}
+		for _, p := range g.lag.Lag.Sorted() {
+			st.Partitions = append(st.Partitions, PartitionLagReport{
+				Topic:     p.Topic,
+				Partition: p.Partition,
+				Committed: p.Commit.At,
+				End:       p.End.Offset,
+				Lag:       p.Lag,
+			})
+		}
+
+		series := m.groupSeries(group)
+		if len(series) >= 2 {
+			a, b := series[0], series[len(series)-1]
+			if secs := b.at.Sub(a.at).Seconds(); secs > 0 {
+				st.ConsumeRate = float64(b.committed-a.committed) / secs
+				st.LagRate = float64(b.total-a.total) / secs
+			}
+		}
+		if st.Status == "ok" && growing(series) {
+			st.Status = "degraded"
+			st.Reason = "lag kept growing over the window"
+			if st.Members == 0 {
+				st.Reason = "lag is growing and the group has no members"
+			}
+		}
+		if st.Status == "degraded" {
+			r.Status = "degraded"
+		}
+		r.Stages = append(r.Stages, st)
+	}
+	return r
+}
+
+type groupPoint struct {
+	at        time.Time
+	total     int64
+	committed int64
+}
+
+// groupSeries is group's lag in each sample that has it, oldest first.
+func (m *lagMonitor) groupSeries(group string) []groupPoint {
+	var out []groupPoint
+	for _, s := range m.samples {
+		if g, ok := s.groups[group]; ok && g.lag.Error() == nil {
+			out = append(out, groupPoint{at: s.at, total: g.total, committed: g.committed})
+		}
+	}
+	return out
+}
+
+// growing reports whether lag never fell between consecutive points and
+// ended above where it started, over at least minTrendSamples of them.
+func growing(series []groupPoint) bool {
+	if len(series) < minTrendSamples {
+		return false
+	}
+	for i := 1; i < len(series); i++ {
+		if series[i].total < series[i-1].total {
+			return false
+		}
+	}
+	return series[len(series)-1].total > series[0].total
+}
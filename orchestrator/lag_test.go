@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+)
+
+// reading is one group in one sample; a negative total leaves the group
+// out of that sample.
+type reading struct {
+	total, committed int64
+}
+
+var absent = reading{total: -1}
+
+// testLagMonitor has one sample of group "g" per reading, 10s apart, with
+// members in the group and 100 records appended to "results" between each.
+func testLagMonitor(members int, readings ...reading) *lagMonitor {
+	start := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := &lagMonitor{groups: []string{"g"}, window: time.Hour}
+	for i, rd := range readings {
+		s := lagSample{
+			at:     start.Add(time.Duration(i) * 10 * time.Second),
+			groups: map[string]groupSample{},
+			ends:   map[string]int64{"results": int64(i) * 100},
+		}
+		if rd != absent {
+			l := kadm.DescribedGroupLag{Group: "g", State: "Stable", Members: make([]kadm.DescribedGroupMember, members)}
+			s.groups["g"] = groupSample{lag: l, total: rd.total, committed: rd.committed}
+		}
+		m.samples = append(m.samples, s)
+	}
+	return m
+}
+
+func TestGrowing(t *testing.T) {
+	tests := []struct {
+		name   string
+		totals []int64
+		want   bool
+	}{
+		{"no points", nil, false},
+		{"fewer than minTrendSamples", []int64{1, 50}, false},
+		{"steady", []int64{5, 5, 5}, false},
+		{"steady and large", []int64{9000, 9000, 9000, 9000}, false},
+		{"growing", []int64{1, 2, 3}, true},
+		{"flat then growing", []int64{1, 1, 2}, true},
+		{"fell once", []int64{1, 3, 2, 4}, false},
+		{"shrinking", []int64{3, 2, 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var series []groupPoint
+			for _, total := range tt.totals {
+				series = append(series, groupPoint{total: total})
+			}
+			if got := growing(series); got != tt.want {
+				t.Errorf("growing(%v) = %v, want %v", tt.totals, got, tt.want)
+			}
+		})
+	}
+}
+
+// Samples where the group is missing or failed to describe are left out of
+// its series.
+func TestGroupSeries(t *testing.T) {
+	m := testLagMonitor(1, reading{1, 10}, absent, reading{2, 20}, reading{3, 30})
+	failed := m.samples[2].groups["g"]
+	failed.lag.FetchErr = errors.New("coordinator not available")
+	m.samples[2].groups["g"] = failed
+
+	series := m.groupSeries("g")
+	if len(series) != 2 || series[0].total != 1 || series[1].total != 3 || series[1].committed != 30 {
+		t.Errorf("series = %+v, want the first and last samples", series)
+	}
+	if !series[1].at.Equal(m.samples[3].at) {
+		t.Errorf("last point at %v, want the last sample's time", series[1].at)
+	}
+	if got := m.groupSeries("other"); len(got) != 0 {
+		t.Errorf("series of an unknown group = %+v", got)
+	}
+}
+
+func TestLagReport(t *testing.T) {
+	tests := []struct {
+		name     string
+		members  int
+		readings []reading
+		status   string // of the pipeline
+		stage    string // status of the stage
+		reason   string
+		lagRate  float64
+	}{
+		{
+			name:     "steady lag",
+			members:  1,
+			readings: []reading{{500, 0}, {500, 100}, {500, 200}},
+			status:   "ok",
+			stage:    "ok",
+			lagRate:  0,
+		},
+		{
+			name:     "growing lag",
+			members:  1,
+			readings: []reading{{100, 0}, {150, 50}, {200, 100}},
+			status:   "degraded",
+			stage:    "degraded",
+			reason:   "lag kept growing over the window",
+			lagRate:  5,
+		},
+		{
+			name:     "growing lag without members",
+			readings: []reading{{100, 0}, {200, 0}, {300, 0}},
+			status:   "degraded",
+			stage:    "degraded",
+			reason:   "lag is growing and the group has no members",
+			lagRate:  10,
+		},
+		{
+			name:     "fewer than minTrendSamples",
+			members:  1,
+			readings: []reading{{100, 0}, {900, 0}},
+			status:   "ok",
+			stage:    "ok",
+			lagRate:  80,
+		},
+		{
+			// The missing sample leaves two points: too few for a trend.
+			name:     "group missing from a sample",
+			members:  1,
+			readings: []reading{{100, 0}, absent, {300, 0}},
+			status:   "ok",
+			stage:    "ok",
+			lagRate:  10,
+		},
+		{
+			name:     "group missing from the latest sample",
+			members:  1,
+			readings: []reading{{100, 0}, {200, 0}, {300, 0}, absent},
+			status:   "ok",
+			stage:    "unknown",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := testLagMonitor(tt.members, tt.readings...).Report()
+			if r.Status != tt.status {
+				t.Errorf("pipeline status %q, want %q", r.Status, tt.status)
+			}
+			if len(r.Stages) != 1 {
+				t.Fatalf("stages = %+v", r.Stages)
+			}
+			st := r.Stages[0]
+			if st.Status != tt.stage || st.Reason != tt.reason || st.LagRate != tt.lagRate {
+				t.Errorf("stage %q (%q) lag rate %v; want %q (%q) %v", st.Status, st.Reason, st.LagRate, tt.stage, tt.reason, tt.lagRate)
+			}
+			wantWindow := float64(len(tt.readings)-1) * 10
+			if r.WindowSeconds != wantWindow || r.ProduceRates["results"] != 10 {
+				t.Errorf("window %vs, produce rates %v; want %vs and 10/s", r.WindowSeconds, r.ProduceRates, wantWindow)
+			}
+		})
+	}
+}
+
+func TestLagReportRates(t *testing.T) {
+	st := testLagMonitor(2, reading{100, 1000}, reading{100, 1300}, reading{100, 1600}).Report().Stages[0]
+	if st.ConsumeRate != 30 || st.Members != 2 || st.Lag != 100 {
+		t.Errorf("stage %+v, want 30/s consumed by 2 members with lag 100", st)
+	}
+}
+
+func TestLagReportNoSamples(t *testing.T) {
+	m := &lagMonitor{groups: []string{"g"}, err: errors.New("brokers unreachable")}
+	r := m.Report()
+	if r.Status != "unknown" || r.Error != "brokers unreachable" || len(r.Stages) != 0 {
+		t.Errorf("report %+v, want unknown with the sampling error", r)
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
+	"github.com/exploravis/config"
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func cors(next http.Handler) http.Handler {
@@ -25,26 +30,72 @@ func cors(next http.Handler) http.Handler {
 func loadEnv() {
 	_ = godotenv.Load()
 }
+
+// runReindexCommand implements `orchestrator reindex`, which migrates the
+// scans indices onto the current template version.
+func runReindexCommand(args []string) {
+	fs := flag.NewFlagSet("reindex", flag.ExitOnError)
+	deleteOld := fs.Bool("delete-old", false, "delete the source indices once the aliases are switched")
+	_ = fs.Parse(args)
+
+	esClient, err := newElasticsearchClient()
+	if err != nil {
+		log.Fatalf("failed to create ES client: %v", err)
+	}
+	if err := reindexScans(esClient, *deleteOld); err != nil {
+		log.Fatalf("reindex failed: %v", err)
+	}
+	log.Println("Reindex complete")
+}
+
 func main() {
 	loadEnv()
 
-	kafkaClient := newKafkaClient()
-	defer kafkaClient.Close()
+	// Anything that isn't a flag is a subcommand. Subcommands parse their own
+	// flags and take settings from the environment or CONFIG_FILE only.
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		if err := config.Load("orchestrator", &cfg, nil); err != nil {
+			log.Fatal(err)
+		}
+		switch os.Args[1] {
+		case "reindex":
+			runReindexCommand(os.Args[2:])
+			return
+		case "provision":
+			runProvisionCommand(os.Args[2:])
+			return
+		default:
+			log.Fatalf("unknown command %q", os.Args[1])
+		}
+	}
+	config.MustLoad("orchestrator", &cfg)
+	initTracing()
+	if onKafka() {
+		checkTopics()
+		startLagMonitor(context.Background())
+	}
+	warmHealth()
+
+	b := newBus()
+	defer b.Close()
 	esClient, err := newElasticsearchClient()
 	if err != nil {
 		log.Fatalf("failed to create ES client: %v", err)
 	}
+	if err := ensureScansIndices(esClient); err != nil {
+		log.Printf("[WARN] Could not set up scans indices: %v", err)
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/scan", scanHandler(kafkaClient))
+	mux.Handle("/scan", scanHandler(b))
+	mux.Handle("GET /scan/{id}/stream", scanStreamHandler())
 	mux.Handle("/health", healthHandler())
 	mux.Handle("/scans", scansHandler(esClient))
+	mux.Handle("/stats", statsHandler(esClient))
+	mux.Handle("GET /metrics", promhttp.Handler())
 
 	handler := cors(mux)
-	addr := ":8089"
-	if p := os.Getenv("PORT"); p != "" {
-		addr = ":" + p
-	}
+	addr := ":" + cfg.HTTP.Port
 
 	log.Println("API server listening on", addr)
 	log.Fatal(http.ListenAndServe(addr, handler))
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metric names match the workers' where they measure the same thing, so
+// records_produced_total lines up across the whole pipeline.
+var (
+	recordsProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "exploravis",
+		Name:      "records_produced_total",
+		Help:      "Records produced to Kafka, by topic and outcome (ok or error).",
+	}, []string{"topic", "outcome"})
+	scanBatches = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "exploravis",
+		Subsystem: "orchestrator",
+		Name:      "scan_batches_total",
+		Help:      "Scan requests accepted by POST /scan, before splitting into /24s.",
+	})
+	scanSubnets = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "exploravis",
+		Subsystem: "orchestrator",
+		Name:      "scan_subnets",
+		Help:      "Number of /24 scan requests an accepted scan was split into.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 9),
+	})
+	streamClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "exploravis",
+		Subsystem: "orchestrator",
+		Name:      "stream_clients",
+		Help:      "Open GET /scan/{id}/stream connections.",
+	})
+	streamResults = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "exploravis",
+		Subsystem: "orchestrator",
+		Name:      "stream_results_total",
+		Help:      "Results sent to stream clients.",
+	})
+)
+
+func produceOutcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
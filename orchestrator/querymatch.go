@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exploravis/model"
+)
+
+// resultMatcher evaluates the Shodan-like q syntax against a single result
+// in memory, for consumers (like the live stream) that never go through ES.
+// It follows the q part of buildFilterQuery: field terms are ANDed, repeated
+// values of a field are ANDed as well, negated terms exclude. Keyword fields
+// (ip, country) match exactly, as the ES term query does; other fields and
+// free text match case-insensitive substrings, close to ES's analyzed match.
+type resultMatcher struct {
+	q   TokenizedQuery
+	now time.Time
+}
+
+func newResultMatcher(q string) *resultMatcher {
+	return &resultMatcher{q: parseShodanLikeQuery(q), now: time.Now()}
+}
+
+func (m *resultMatcher) Match(r model.ServiceScanResult) bool {
+	d := &resultDoc{r: r}
+	for f, vals := range m.q.FieldTerms {
+		for _, v := range vals {
+			if !matchField(d, f, v, m.now) {
+				return false
+			}
+		}
+	}
+	for f, vals := range m.q.NotFieldTerms {
+		for _, v := range vals {
+			if f == "_free" {
+				if matchFreeText(d, v) {
+					return false
+				}
+				continue
+			}
+			if matchField(d, f, v, m.now) {
+				return false
+			}
+		}
+	}
+	for _, t := range m.q.FreeTerms {
+		if !matchFreeText(d, t) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchField(d *resultDoc, field, v string, now time.Time) bool {
+	r := d.r
+	switch field {
+	case "ip":
+		return r.IP == v
+	case "port":
+		if a, b, ok := parseNumericRange(v); ok {
+			return r.Port >= a && r.Port <= b
+		}
+		p, err := strconv.Atoi(v)
+		return err == nil && r.Port == p
+	case "country":
+		return metaString(r.Meta, "geo", "country") == v
+	case "after":
+		ts, ok := parseTimeBound(v, now, false)
+		return ok && r.Timestamp >= ts
+	case "before":
+		ts, ok := parseTimeBound(v, now, true)
+		return ok && r.Timestamp <= ts
+	case "geo":
+		lat, lon, km, ok := parseGeoDistance(v)
+		if !ok {
+			return false
+		}
+		rlat, rlon, ok := resultLocation(r)
+		return ok && haversineKM(lat, lon, rlat, rlon) <= km
+	case "bbox":
+		top, left, bottom, right, ok := parseBBox(v)
+		if !ok {
+			return false
+		}
+		rlat, rlon, ok := resultLocation(r)
+		return ok && rlat <= top && rlat >= bottom && rlon >= left && rlon <= right
+	}
+
+	// Everything else mirrors the ES "match" on the field: compare against
+	// the value at that path in the JSON document.
+	got, ok := d.lookup(field)
+	if !ok {
+		return false
+	}
+	return strings.Contains(strings.ToLower(got), strings.ToLower(v))
+}
+
+// matchFreeText searches the fields freeTextClause does.
+func matchFreeText(d *resultDoc, term string) bool {
+	term = strings.ToLower(term)
+	for _, f := range freeTextFields {
+		path, _, _ := strings.Cut(f, "^")
+		if got, ok := d.lookup(path); ok && strings.Contains(strings.ToLower(got), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// resultDoc is a result being matched, with its JSON form decoded on the
+// first lookup and kept for the rest.
+type resultDoc struct {
+	r       model.ServiceScanResult
+	doc     any
+	decoded bool
+}
+
+// lookup resolves a dotted field name ("http.headers.server") against the
+// JSON form of the result.
+func (d *resultDoc) lookup(path string) (string, bool) {
+	if !d.decoded {
+		d.decoded = true
+		b, err := json.Marshal(d.r)
+		if err != nil {
+			return "", false
+		}
+		if err := json.Unmarshal(b, &d.doc); err != nil {
+			return "", false
+		}
+	}
+	cur := d.doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return "", false
+		}
+		if cur, ok = m[part]; !ok {
+			return "", false
+		}
+	}
+	if cur == nil {
+		return "", false
+	}
+	if s, ok := cur.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(cur), true
+}
+
+func metaString(meta map[string]any, keys ...string) string {
+	var cur any = meta
+	for _, k := range keys {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return ""
+		}
+		cur = m[k]
+	}
+	s, _ := cur.(string)
+	return s
+}
+
+func resultLocation(r model.ServiceScanResult) (float64, float64, bool) {
+	geo, ok := r.Meta["geo"].(map[string]any)
+	if !ok {
+		return 0, 0, false
+	}
+	loc, ok := geo["location"].(map[string]any)
+	if !ok {
+		return 0, 0, false
+	}
+	lat, ok1 := loc["lat"].(float64)
+	lon, ok2 := loc["lon"].(float64)
+	return lat, lon, ok1 && ok2
+}
+
+func haversineKM(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKM = 6371.0
+	rad := math.Pi / 180
+	dLat := (lat2 - lat1) * rad
+	dLon := (lon2 - lon1) * rad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*rad)*math.Cos(lat2*rad)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKM * math.Asin(math.Sqrt(a))
+}
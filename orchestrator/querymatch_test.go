@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exploravis/model"
+)
+
+func TestResultMatcher(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	r := model.ServiceScanResult{
+		IP:        "198.51.100.7",
+		Port:      8443,
+		Timestamp: now.Add(-2 * time.Hour).Unix(),
+		Protocol:  "tcp",
+		Service:   "https",
+		Banner:    "nginx/1.25.3",
+		HTTP:      &model.HTTPInfo{Title: "Welcome Page", BodyPreview: "<h1>It works</h1>", Headers: map[string]string{"server": "nginx"}},
+		Meta: map[string]any{"geo": map[string]any{
+			"country":  "DE",
+			"location": map[string]any{"lat": 52.52, "lon": 13.40},
+		}},
+	}
+
+	tests := []struct {
+		q    string
+		want bool
+	}{
+		{"", true},
+		{"ip:198.51.100.7", true},
+		{"ip:198.51.100.8", false},
+		{"port:8443", true},
+		{"port:8000-9000", true},
+		{"port:80", false},
+		{"port:abc", false},
+		{"country:DE", true},
+		{"country:de", false}, // a keyword term is case-sensitive in ES
+		{"-country:DE", false},
+		{"-country:FR", true},
+		{"after:-3h", true},
+		{"after:-1h", false},
+		{"before:-1h", true},
+		{"before:-3h", false},
+		{"geo:52.5,13.4,10", true},
+		{"geo:48.1,11.6,10", false},
+		{"bbox:53,13,52,14", true},
+		{"bbox:1,1,0,0", false},
+		{"service:HTTPS", true},
+		{"http.title:welcome", true},
+		{"http.headers.server:nginx", true},
+		{"http.title:admin", false},
+		{"ssh.version:openssh", false},
+		{"nginx", true},
+		{"NGINX works", true},
+		{"nginx apache", false},
+		{`"it works"`, true},
+		{"-apache", true},
+		{"-nginx", false},
+		{"port:8443 port:443", false}, // repeated values are ANDed
+		{"country:DE -port:8443", false},
+	}
+	for _, tt := range tests {
+		m := &resultMatcher{q: parseShodanLikeQuery(tt.q), now: now}
+		if got := m.Match(r); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestBuildFilterQueryNegation(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		q       string
+		mustNot []string // substrings of the must_not clauses
+	}{
+		{"country:DE", nil},
+		{"-country:FR", []string{`"meta.geo.country.keyword":"FR"`}},
+		{"-port:22 -port:80-89", []string{`"port":22`, `"gte":80`}},
+		{"ssh -telnet", []string{`"query":"telnet"`}},
+		{"-port:abc", nil},
+	}
+	for _, tt := range tests {
+		query, _ := buildFilterQuery(map[string][]string{"q": {tt.q}}, now)
+		b, _ := json.Marshal(query)
+		var clauses []map[string]any
+		if q, ok := query["bool"].(map[string]any); ok {
+			clauses, _ = q["must_not"].([]map[string]any)
+		}
+		if len(clauses) != len(tt.mustNot) {
+			t.Errorf("%q: %d must_not clauses, want %d: %s", tt.q, len(clauses), len(tt.mustNot), b)
+			continue
+		}
+		for _, want := range tt.mustNot {
+			if !strings.Contains(string(b), want) {
+				t.Errorf("%q: query %s lacks %s", tt.q, b, want)
+			}
+		}
+	}
+}
+
+// The live stream and /scans agree on which fields free text searches: a
+// word matches in memory exactly when it sits in a field the ES query lists.
+func TestFreeTextFieldsAgree(t *testing.T) {
+	query, _ := buildFilterQuery(map[string][]string{"q": {"needle"}}, time.Now())
+	b, _ := json.Marshal(query)
+	var parsed struct {
+		Bool struct {
+			Must []struct {
+				SimpleQueryString struct {
+					Fields []string `json:"fields"`
+				} `json:"simple_query_string"`
+			} `json:"must"`
+		} `json:"bool"`
+	}
+	json.Unmarshal(b, &parsed)
+	searched := map[string]bool{}
+	for _, c := range parsed.Bool.Must {
+		for _, f := range c.SimpleQueryString.Fields {
+			path, _, _ := strings.Cut(f, "^")
+			searched[path] = true
+		}
+	}
+	if len(searched) == 0 {
+		t.Fatalf("no free text fields in %s", b)
+	}
+
+	tests := []struct {
+		path string
+		r    model.ServiceScanResult
+	}{
+		{"banner", model.ServiceScanResult{Banner: "needle"}},
+		{"raw_tcp", model.ServiceScanResult{RawTCP: "needle"}},
+		{"http.body_preview", model.ServiceScanResult{HTTP: &model.HTTPInfo{BodyPreview: "needle"}}},
+		{"http.title", model.ServiceScanResult{HTTP: &model.HTTPInfo{Title: "needle"}}},
+		{"ssh.version", model.ServiceScanResult{SSH: &model.SSHInfo{Version: "SSH-2.0-needle"}}},
+		{"service", model.ServiceScanResult{Service: "needle"}},
+	}
+	for _, tt := range tests {
+		if _, ok := (&resultDoc{r: tt.r}).lookup(tt.path); !ok {
+			t.Errorf("%s is not a field of the result model", tt.path)
+		}
+		if got := newResultMatcher("needle").Match(tt.r); got != searched[tt.path] {
+			t.Errorf("needle in %s: stream match = %v, /scans searches it = %v", tt.path, got, searched[tt.path])
+		}
+	}
+	for path := range searched {
+		if _, ok := (&resultDoc{r: fullResult()}).lookup(path); !ok {
+			t.Errorf("/scans searches %s, which the result model does not have", path)
+		}
+	}
+}
+
+// fullResult has every free-text candidate field set.
+func fullResult() model.ServiceScanResult {
+	return model.ServiceScanResult{
+		Banner: "b",
+		RawTCP: "r",
+		HTTP:   &model.HTTPInfo{BodyPreview: "p", Title: "t"},
+		SSH:    &model.SSHInfo{Version: "v"},
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type TokenizedQuery struct {
@@ -79,3 +80,153 @@ func parseNumericRange(v string) (int, int, bool) {
 	}
 	return 0, 0, false
 }
+
+// parseTimeBound turns a query time value into unix seconds. It accepts
+// relative offsets ("-7d", "now-12h", "now"), unix seconds, RFC3339
+// timestamps, and dates down to a year ("2025-01-31", "2025-01", "2025").
+// A value less precise than a second names a whole period: as an upper
+// bound it stands for the period's last second, so before:2025-01-31
+// includes that day. Short integers other than years are rejected rather
+// than read as seconds into 1970.
+func parseTimeBound(v string, now time.Time, upper bool) (int64, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if strings.EqualFold(v, "now") {
+		return now.Unix(), true
+	}
+	if strings.HasPrefix(v, "now") {
+		v = strings.TrimPrefix(v, "now")
+	}
+	if strings.HasPrefix(v, "-") || strings.HasPrefix(v, "+") {
+		d, ok := parseRelativeDuration(v[1:])
+		if !ok {
+			return 0, false
+		}
+		if v[0] == '-' {
+			d = -d
+		}
+		return now.Add(d).Unix(), true
+	}
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil && len(v) >= minUnixDigits {
+		return n, true
+	}
+	for _, l := range timeLayouts {
+		t, err := time.Parse(l.layout, v)
+		if err != nil {
+			continue
+		}
+		if upper && l.period != nil {
+			t = l.period(t).Add(-time.Second)
+		}
+		return t.Unix(), true
+	}
+	return 0, false
+}
+
+// minUnixDigits is the shortest integer taken as unix seconds (1973 on);
+// four digits are a year.
+const minUnixDigits = 9
+
+// timeLayouts are the absolute forms parseTimeBound accepts. period, for
+// the ones coarser than a second, gives the start of the next period.
+var timeLayouts = []struct {
+	layout string
+	period func(time.Time) time.Time
+}{
+	{time.RFC3339, nil},
+	{"2006-01-02T15:04:05", nil},
+	{"2006-01-02T15:04", func(t time.Time) time.Time { return t.Add(time.Minute) }},
+	{"2006-01-02", func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }},
+	{"2006-01", func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }},
+	{"2006", func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }},
+}
+
+// parseRelativeDuration understands the units time.ParseDuration lacks
+// (d, w) on top of the ones it has.
+func parseRelativeDuration(v string) (time.Duration, bool) {
+	if len(v) < 2 {
+		return 0, false
+	}
+	unit := v[len(v)-1]
+	n, err := strconv.Atoi(v[:len(v)-1])
+	if err != nil || n < 0 {
+		d, err := time.ParseDuration(v)
+		return d, err == nil
+	}
+	switch unit {
+	case 'm':
+		return time.Duration(n) * time.Minute, true
+	case 'h':
+		return time.Duration(n) * time.Hour, true
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, true
+	case 'w':
+		return time.Duration(n) * 7 * 24 * time.Hour, true
+	}
+	d, err := time.ParseDuration(v)
+	return d, err == nil
+}
+
+// histogramIntervals maps the accepted date_histogram intervals to seconds,
+// since timestamp is stored as epoch seconds.
+var histogramIntervals = map[string]int64{
+	"1h":    3600,
+	"hour":  3600,
+	"6h":    6 * 3600,
+	"12h":   12 * 3600,
+	"1d":    86400,
+	"day":   86400,
+	"7d":    7 * 86400,
+	"1w":    7 * 86400,
+	"week":  7 * 86400,
+	"30d":   30 * 86400,
+	"month": 30 * 86400,
+}
+
+func parseHistogramInterval(v string) (int64, bool) {
+	s, ok := histogramIntervals[strings.ToLower(strings.TrimSpace(v))]
+	return s, ok
+}
+
+// parseFloats splits a comma separated list of exactly n numbers.
+func parseFloats(v string, n int) ([]float64, bool) {
+	parts := strings.Split(v, ",")
+	if len(parts) != n {
+		return nil, false
+	}
+	out := make([]float64, 0, n)
+	for _, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return nil, false
+		}
+		out = append(out, f)
+	}
+	return out, true
+}
+
+func validLatLon(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
+
+// parseGeoDistance parses "lat,lon,radius_km".
+func parseGeoDistance(v string) (lat, lon, km float64, ok bool) {
+	f, ok := parseFloats(v, 3)
+	if !ok || !validLatLon(f[0], f[1]) || f[2] <= 0 {
+		return 0, 0, 0, false
+	}
+	return f[0], f[1], f[2], true
+}
+
+// parseBBox parses two opposite corners "lat1,lon1,lat2,lon2" and returns
+// them as top-left / bottom-right. Boxes crossing the antimeridian are not
+// supported.
+func parseBBox(v string) (top, left, bottom, right float64, ok bool) {
+	f, ok := parseFloats(v, 4)
+	if !ok || !validLatLon(f[0], f[1]) || !validLatLon(f[2], f[3]) {
+		return 0, 0, 0, 0, false
+	}
+	return max(f[0], f[2]), min(f[1], f[3]), min(f[0], f[2]), max(f[1], f[3]), true
+}
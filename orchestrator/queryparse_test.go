@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exploravis/model"
+)
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v      string
+		want   time.Time
+		wantOK bool
+	}{
+		{"now", now, true},
+		{"NOW", now, true},
+		{" now ", now, true},
+		{"-7d", now.AddDate(0, 0, -7), true},
+		{"now-12h", now.Add(-12 * time.Hour), true},
+		{"now+30m", now.Add(30 * time.Minute), true},
+		{"-2w", now.AddDate(0, 0, -14), true},
+		{"-90s", now.Add(-90 * time.Second), true},
+		{"-1h30m", now.Add(-90 * time.Minute), true},
+		{"1717243200", time.Unix(1717243200, 0), true},
+		{"2025-01-31T08:30:00Z", time.Date(2025, 1, 31, 8, 30, 0, 0, time.UTC), true},
+		{"2025-01-31T08:30:00+02:00", time.Date(2025, 1, 31, 6, 30, 0, 0, time.UTC), true},
+		{"2025-01-31T08:30:00", time.Date(2025, 1, 31, 8, 30, 0, 0, time.UTC), true},
+		{"2025-01-31T08:30", time.Date(2025, 1, 31, 8, 30, 0, 0, time.UTC), true},
+		{"2025-01-31", time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"-", time.Time{}, false},
+		{"-7x", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+		{"2025-13-01", time.Time{}, false},
+		{"2025-01", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2025", time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), true},
+		// Neither a year nor a plausible unix time.
+		{"20250", time.Time{}, false},
+		{"86400", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTimeBound(tt.v, now, false)
+		if ok != tt.wantOK || (ok && got != tt.want.Unix()) {
+			t.Errorf("parseTimeBound(%q) = %d, %v; want %d, %v", tt.v, got, ok, tt.want.Unix(), tt.wantOK)
+		}
+	}
+}
+
+// As an upper bound, a value coarser than a second takes in the whole
+// period it names.
+func TestParseTimeBoundUpper(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		v    string
+		want time.Time
+	}{
+		{"2025-01-31", time.Date(2025, 1, 31, 23, 59, 59, 0, time.UTC)},
+		{"2025-01-31T08:30", time.Date(2025, 1, 31, 8, 30, 59, 0, time.UTC)},
+		{"2025-01-31T08:30:00", time.Date(2025, 1, 31, 8, 30, 0, 0, time.UTC)},
+		{"2025-02", time.Date(2025, 2, 28, 23, 59, 59, 0, time.UTC)},
+		{"2024", time.Date(2024, 12, 31, 23, 59, 59, 0, time.UTC)},
+		{"1717243200", time.Unix(1717243200, 0)},
+		{"-7d", now.AddDate(0, 0, -7)},
+	}
+	for _, tt := range tests {
+		got, ok := parseTimeBound(tt.v, now, true)
+		if !ok || got != tt.want.Unix() {
+			t.Errorf("parseTimeBound(%q, upper) = %d, %v; want %s", tt.v, got, ok, tt.want)
+		}
+	}
+
+	day := time.Date(2025, 1, 31, 18, 0, 0, 0, time.UTC).Unix()
+	r := model.ServiceScanResult{Timestamp: day}
+	for _, q := range []string{"before:2025-01-31", "after:2025-01-31 before:2025-01-31", "after:2025 before:2025"} {
+		if !newResultMatcher(q).Match(r) {
+			t.Errorf("%s does not match a result during that day", q)
+		}
+	}
+	query, _ := buildFilterQuery(map[string][]string{"to_ts": {"2025-01-31"}}, now)
+	b, _ := json.Marshal(query)
+	if want := fmt.Sprintf(`"lte":%d`, day+6*3600-1); !strings.Contains(string(b), want) {
+		t.Errorf("to_ts=2025-01-31 query %s lacks %s", b, want)
+	}
+}
+
+// Every time bound is inclusive, however it is given.
+func TestTimeBoundsInclusive(t *testing.T) {
+	now := time.Now()
+	ts := "1717243200"
+	tests := []struct {
+		params map[string][]string
+		op     string
+	}{
+		{map[string][]string{"from_ts": {ts}}, "gte"},
+		{map[string][]string{"q": {"after:" + ts}}, "gte"},
+		{map[string][]string{"to_ts": {ts}}, "lte"},
+		{map[string][]string{"q": {"before:" + ts}}, "lte"},
+	}
+	for _, tt := range tests {
+		query, tr := buildFilterQuery(tt.params, now)
+		b, _ := json.Marshal(query)
+		if want := `"timestamp":{"` + tt.op + `":` + ts + `}`; !strings.Contains(string(b), want) {
+			t.Errorf("%v: query %s lacks %s", tt.params, b, want)
+		}
+		if (tr.HasFrom && tr.From != 1717243200) || (tr.HasTo && tr.To != 1717243200) || tr.HasFrom == tr.HasTo {
+			t.Errorf("%v: time range %+v", tt.params, tr)
+		}
+	}
+
+	r := model.ServiceScanResult{Timestamp: 1717243200}
+	for _, q := range []string{"after:" + ts, "before:" + ts} {
+		if !newResultMatcher(q).Match(r) {
+			t.Errorf("%s does not match a result at exactly that time", q)
+		}
+	}
+}
+
+func TestTimeRangeNarrowest(t *testing.T) {
+	_, tr := buildFilterQuery(map[string][]string{
+		"from_ts": {"1700000100"},
+		"to_ts":   {"1700000900"},
+		"q":       {"after:1700000200 after:1700000150 before:1700000800 before:1700000950"},
+	}, time.Now())
+	if tr != (timeRange{From: 1700000200, To: 1700000800, HasFrom: true, HasTo: true}) {
+		t.Errorf("time range = %+v, want the narrowest bounds 1700000200..1700000800", tr)
+	}
+}
+
+func TestParseBBox(t *testing.T) {
+	tests := []struct {
+		v                        string
+		top, left, bottom, right float64
+		ok                       bool
+	}{
+		{"53,13,52,14", 53, 13, 52, 14, true},
+		{"52,14,53,13", 53, 13, 52, 14, true}, // any two opposite corners
+		{" 40.5 , -74.2 , 40.9 , -73.7 ", 40.9, -74.2, 40.5, -73.7, true},
+		{"90,-180,-90,180", 90, -180, -90, 180, true},
+		{"91,0,0,1", 0, 0, 0, 0, false},
+		{"0,181,1,0", 0, 0, 0, 0, false},
+		{"1,2,3", 0, 0, 0, 0, false},
+		{"1,2,3,4,5", 0, 0, 0, 0, false},
+		{"a,b,c,d", 0, 0, 0, 0, false},
+		{"", 0, 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		top, left, bottom, right, ok := parseBBox(tt.v)
+		if ok != tt.ok || top != tt.top || left != tt.left || bottom != tt.bottom || right != tt.right {
+			t.Errorf("parseBBox(%q) = %v,%v,%v,%v,%v; want %v,%v,%v,%v,%v", tt.v,
+				top, left, bottom, right, ok, tt.top, tt.left, tt.bottom, tt.right, tt.ok)
+		}
+	}
+}
+
+func TestParseGeoDistance(t *testing.T) {
+	tests := []struct {
+		v            string
+		lat, lon, km float64
+		ok           bool
+	}{
+		{"52.52,13.40,25", 52.52, 13.40, 25, true},
+		{"-33.9,151.2,0.5", -33.9, 151.2, 0.5, true},
+		{"52.52,13.40,0", 0, 0, 0, false},
+		{"52.52,13.40,-5", 0, 0, 0, false},
+		{"95,13.40,5", 0, 0, 0, false},
+		{"52.52,13.40", 0, 0, 0, false},
+		{"52.52,east,5", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		lat, lon, km, ok := parseGeoDistance(tt.v)
+		if ok != tt.ok || lat != tt.lat || lon != tt.lon || km != tt.km {
+			t.Errorf("parseGeoDistance(%q) = %v,%v,%v,%v; want %v,%v,%v,%v", tt.v, lat, lon, km, ok, tt.lat, tt.lon, tt.km, tt.ok)
+		}
+	}
+}
+
+func TestGeoClauses(t *testing.T) {
+	query, _ := buildFilterQuery(map[string][]string{"q": {"geo:52.52,13.4,25 bbox:52,14,53,13 geo:bad"}}, time.Now())
+	b, _ := json.Marshal(query)
+	for _, want := range []string{
+		`{"geo_distance":{"distance":"25km","meta.geo.location":{"lat":52.52,"lon":13.4}}}`,
+		`{"geo_bounding_box":{"meta.geo.location":{"bottom_right":{"lat":52,"lon":14},"top_left":{"lat":53,"lon":13}}}}`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("query %s lacks %s", b, want)
+		}
+	}
+	if strings.Count(string(b), "geo_distance") != 1 {
+		t.Errorf("an invalid geo: term made a clause: %s", b)
+	}
+}
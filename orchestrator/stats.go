@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// statsGroupFields are the fields /stats can group by, keyed by the name
+// used in the group_by parameter.
+var statsGroupFields = map[string]string{
+	"country":     "meta.geo.country.keyword",
+	"org":         "meta.asn.org.keyword",
+	"asn":         "meta.asn.number",
+	"port":        "port",
+	"protocol":    "protocol.keyword",
+	"service":     "service.keyword",
+	"tls_version": "tls.version.keyword",
+	"http_server": "http.headers.server.keyword",
+}
+
+type StatsPoint struct {
+	Timestamp int64 `json:"ts"`
+	Count     int   `json:"count"`
+}
+
+type StatsSeries struct {
+	Key    string       `json:"key"`
+	Total  int          `json:"total"`
+	Points []StatsPoint `json:"points"`
+}
+
+type StatsResponse struct {
+	GroupBy  string        `json:"group_by,omitempty"`
+	Interval string        `json:"interval"`
+	Total    int           `json:"total"`
+	Totals   []StatsPoint  `json:"totals"`
+	Series   []StatsSeries `json:"series,omitempty"`
+	TookMS   int           `json:"took_ms"`
+}
+
+type histogramBucket struct {
+	Key      float64 `json:"key"`
+	DocCount int     `json:"doc_count"`
+}
+
+type statsESResponse struct {
+	Took int `json:"took"`
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+	} `json:"hits"`
+	Aggregations struct {
+		OverTime struct {
+			Buckets []histogramBucket `json:"buckets"`
+		} `json:"over_time"`
+		Groups struct {
+			Buckets []struct {
+				Key      any `json:"key"`
+				DocCount int `json:"doc_count"`
+				OverTime struct {
+					Buckets []histogramBucket `json:"buckets"`
+				} `json:"over_time"`
+			} `json:"buckets"`
+		} `json:"groups"`
+	} `json:"aggregations"`
+}
+
+// ------------------------
+// Cache
+// ------------------------
+
+// statsCache keeps rendered /stats responses for a short TTL and collapses
+// concurrent identical requests into a single ES query.
+type statsCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	entries  map[string]statsCacheEntry
+	inflight map[string]*statsCall
+}
+
+type statsCacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+type statsCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+const statsCacheMaxEntries = 512
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{
+		ttl:      ttl,
+		entries:  make(map[string]statsCacheEntry),
+		inflight: make(map[string]*statsCall),
+	}
+}
+
+// get returns the cached value for key, or runs fetch to fill it. The bool
+// reports whether the value came from the cache.
+func (c *statsCache) get(key string, fetch func() ([]byte, error)) ([]byte, bool, error) {
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && time.Now().Before(e.expires) {
+		c.mu.Unlock()
+		return e.value, true, nil
+	}
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.value, true, call.err
+	}
+	call := &statsCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	call.value, call.err = fetch()
+	close(call.done)
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if call.err == nil {
+		now := time.Now()
+		if len(c.entries) >= statsCacheMaxEntries {
+			for k, e := range c.entries {
+				if now.After(e.expires) {
+					delete(c.entries, k)
+				}
+			}
+		}
+		if len(c.entries) < statsCacheMaxEntries {
+			c.entries[key] = statsCacheEntry{value: call.value, expires: now.Add(c.ttl)}
+		}
+	}
+	c.mu.Unlock()
+
+	return call.value, false, call.err
+}
+
+// ------------------------
+// Query Builder
+// ------------------------
+func buildStatsQuery(params url.Values, interval int64, groupField string, groupSize int) map[string]any {
+	query, tr := buildFilterQuery(params, time.Now())
+	hist := timestampHistogram(interval, tr)
+
+	aggs := map[string]any{"over_time": hist}
+	if groupField != "" {
+		aggs["groups"] = map[string]any{
+			"terms": map[string]any{"field": groupField, "size": groupSize},
+			"aggs":  map[string]any{"over_time": hist},
+		}
+	}
+
+	return map[string]any{
+		"size":             0,
+		"track_total_hits": true,
+		"query":            query,
+		"aggs":             aggs,
+	}
+}
+
+// ------------------------
+// HTTP Handler
+// ------------------------
+func statsHandler(es *elasticsearch.Client) http.Handler {
+	cache := newStatsCache(cfg.Stats.CacheTTL)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+
+		groupBy := params.Get("group_by")
+		groupField := ""
+		if groupBy != "" {
+			f, ok := statsGroupFields[groupBy]
+			if !ok {
+				http.Error(w, "unsupported group_by: "+groupBy, http.StatusBadRequest)
+				return
+			}
+			groupField = f
+		}
+
+		intervalName := params.Get("interval")
+		if intervalName == "" {
+			intervalName = "1d"
+		}
+		interval, ok := parseHistogramInterval(intervalName)
+		if !ok {
+			http.Error(w, "unsupported interval: "+intervalName, http.StatusBadRequest)
+			return
+		}
+
+		groupSize := 10
+		if v := params.Get("size"); v != "" {
+			if n, err := strconv.Atoi(v); err == nil && n > 0 {
+				groupSize = min(n, 50)
+			}
+		}
+
+		// url.Values.Encode sorts by key, so equivalent requests share a slot.
+		key := params.Encode()
+		body, cached, err := cache.get(key, func() ([]byte, error) {
+			ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+			defer cancel()
+			resp, err := fetchStats(ctx, es, scansSearchIndex(params), buildStatsQuery(params, interval, groupField, groupSize))
+			if err != nil {
+				return nil, err
+			}
+			resp.GroupBy = groupBy
+			resp.Interval = intervalName
+			return json.Marshal(resp)
+		})
+		if err != nil {
+			http.Error(w, "stats query failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if cached {
+			w.Header().Set("X-Cache", "HIT")
+		} else {
+			w.Header().Set("X-Cache", "MISS")
+		}
+		_, _ = w.Write(body)
+	})
+}
+
+func fetchStats(ctx context.Context, es *elasticsearch.Client, index string, query map[string]any) (*StatsResponse, error) {
+	bodyBytes, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("[ES] Stats query body: %s", string(bodyBytes))
+
+	res, err := es.Search(
+		es.Search.WithContext(ctx),
+		es.Search.WithIndex(index),
+		es.Search.WithBody(bytes.NewReader(bodyBytes)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		return nil, fmt.Errorf("ES returned error: %s", res.String())
+	}
+
+	var doc statsESResponse
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to parse ES response: %w", err)
+	}
+
+	out := &StatsResponse{
+		Total:  doc.Hits.Total.Value,
+		Totals: make([]StatsPoint, 0, len(doc.Aggregations.OverTime.Buckets)),
+		TookMS: doc.Took,
+	}
+	for _, b := range doc.Aggregations.OverTime.Buckets {
+		out.Totals = append(out.Totals, StatsPoint{Timestamp: int64(b.Key), Count: b.DocCount})
+	}
+
+	// Group histograms only cover the span where that group has hits, so
+	// align every series on the overall buckets.
+	for _, g := range doc.Aggregations.Groups.Buckets {
+		counts := make(map[int64]int, len(g.OverTime.Buckets))
+		for _, b := range g.OverTime.Buckets {
+			counts[int64(b.Key)] = b.DocCount
+		}
+		series := StatsSeries{
+			Key:    fmt.Sprint(g.Key),
+			Total:  g.DocCount,
+			Points: make([]StatsPoint, 0, len(out.Totals)),
+		}
+		for _, t := range out.Totals {
+			series.Points = append(series.Points, StatsPoint{Timestamp: t.Timestamp, Count: counts[t.Timestamp]})
+		}
+		out.Series = append(out.Series, series)
+	}
+	sort.SliceStable(out.Series, func(i, j int) bool { return out.Series[i].Total > out.Series[j].Total })
+
+	return out, nil
+}
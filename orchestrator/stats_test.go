@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+func TestStatsCache(t *testing.T) {
+	fail := errors.New("es down")
+	tests := []struct {
+		name string
+		ttl  time.Duration
+		// errs are the results of successive fetches.
+		errs       []error
+		wantCached []bool
+		wantCalls  int
+	}{
+		{name: "hit within ttl", ttl: time.Hour, errs: []error{nil}, wantCached: []bool{false, true, true}, wantCalls: 1},
+		{name: "refetch after ttl", ttl: 0, errs: []error{nil, nil, nil}, wantCached: []bool{false, false, false}, wantCalls: 3},
+		{name: "errors are not cached", ttl: time.Hour, errs: []error{fail, nil}, wantCached: []bool{false, false, true}, wantCalls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newStatsCache(tt.ttl)
+			calls := 0
+			fetch := func() ([]byte, error) {
+				err := tt.errs[calls]
+				calls++
+				return []byte(fmt.Sprint(calls)), err
+			}
+			for i, want := range tt.wantCached {
+				_, cached, _ := c.get("k", fetch)
+				if cached != want {
+					t.Errorf("get %d cached = %v, want %v", i, cached, want)
+				}
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("%d fetches, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestStatsCacheKeys(t *testing.T) {
+	c := newStatsCache(time.Hour)
+	a, _, _ := c.get("a", func() ([]byte, error) { return []byte("A"), nil })
+	b, _, _ := c.get("b", func() ([]byte, error) { return []byte("B"), nil })
+	if string(a) != "A" || string(b) != "B" {
+		t.Errorf("got %s and %s for different keys", a, b)
+	}
+}
+
+func TestStatsCacheCollapsesConcurrent(t *testing.T) {
+	c := newStatsCache(time.Hour)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func() ([]byte, error) {
+		calls.Add(1)
+		<-release
+		return []byte("v"), nil
+	}
+
+	const n = 8
+	var wg sync.WaitGroup
+	var misses atomic.Int32
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, cached, err := c.get("k", fetch)
+			if err != nil || string(v) != "v" {
+				t.Errorf("get = %q, %v", v, err)
+			}
+			if !cached {
+				misses.Add(1)
+			}
+		}()
+	}
+	// Let every caller reach the cache before the fetch returns.
+	for {
+		c.mu.Lock()
+		_, started := c.inflight["k"]
+		c.mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 || misses.Load() != 1 {
+		t.Errorf("%d fetches and %d misses for %d concurrent gets, want 1 and 1", calls.Load(), misses.Load(), n)
+	}
+}
+
+func TestStatsCacheBounded(t *testing.T) {
+	c := newStatsCache(time.Hour)
+	for i := range statsCacheMaxEntries + 10 {
+		c.get(fmt.Sprint(i), func() ([]byte, error) { return nil, nil })
+	}
+	if n := len(c.entries); n != statsCacheMaxEntries {
+		t.Errorf("%d entries, want the cap of %d", n, statsCacheMaxEntries)
+	}
+
+	// Expired entries make room again.
+	c.ttl = 0
+	for k, e := range c.entries {
+		e.expires = time.Now().Add(-time.Second)
+		c.entries[k] = e
+	}
+	c.get("new", func() ([]byte, error) { return nil, nil })
+	if _, ok := c.entries["new"]; !ok || len(c.entries) != 1 {
+		t.Errorf("%d entries after expiry, want only the new one", len(c.entries))
+	}
+}
+
+func TestFetchStatsAlignsSeries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"took": 7,
+			"hits": {"total": {"value": 6}},
+			"aggregations": {
+				"over_time": {"buckets": [{"key": 100, "doc_count": 1}, {"key": 200, "doc_count": 2}, {"key": 300, "doc_count": 3}]},
+				"groups": {"buckets": [
+					{"key": "US", "doc_count": 2, "over_time": {"buckets": [{"key": 300, "doc_count": 2}]}},
+					{"key": 443, "doc_count": 4, "over_time": {"buckets": [{"key": 100, "doc_count": 1}, {"key": 200, "doc_count": 2}, {"key": 300, "doc_count": 1}]}}
+				]}
+			}
+		}`)
+	}))
+	defer srv.Close()
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := fetchStats(context.Background(), es, "scans", map[string]any{"size": 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Total != 6 || resp.TookMS != 7 || len(resp.Totals) != 3 {
+		t.Fatalf("response = %+v", resp)
+	}
+	if len(resp.Series) != 2 {
+		t.Fatalf("%d series, want 2", len(resp.Series))
+	}
+	// Sorted by total; every series has a point for each overall bucket.
+	want := map[string][]int{"443": {1, 2, 1}, "US": {0, 0, 2}}
+	for i, key := range []string{"443", "US"} {
+		s := resp.Series[i]
+		if s.Key != key {
+			t.Errorf("series %d = %s, want %s", i, s.Key, key)
+			continue
+		}
+		var got []int
+		for j, p := range s.Points {
+			if p.Timestamp != resp.Totals[j].Timestamp {
+				t.Errorf("%s point %d at %d, want %d", key, j, p.Timestamp, resp.Totals[j].Timestamp)
+			}
+			got = append(got, p.Count)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want[key]) {
+			t.Errorf("%s counts = %v, want %v", key, got, want[key])
+		}
+	}
+}
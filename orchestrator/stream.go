@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exploravis/model"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+const (
+	streamHeartbeat  = 15 * time.Second
+	maxStreamClients = 64
+)
+
+// streamCursor tracks the next offset to read per partition. It doubles as
+// the SSE event id, so a reconnecting client resumes where it stopped.
+type streamCursor map[int32]int64
+
+func (c streamCursor) String() string {
+	parts := make([]string, 0, len(c))
+	for p, o := range c {
+		parts = append(parts, fmt.Sprintf("%d:%d", p, o))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+func parseStreamCursor(s string) (streamCursor, bool) {
+	c := streamCursor{}
+	if strings.TrimSpace(s) == "" {
+		return c, false
+	}
+	for _, part := range strings.Split(s, ",") {
+		ps, offs, ok := strings.Cut(part, ":")
+		if !ok {
+			return nil, false
+		}
+		p, err1 := strconv.ParseInt(ps, 10, 32)
+		o, err2 := strconv.ParseInt(offs, 10, 64)
+		if err1 != nil || err2 != nil || o < 0 {
+			return nil, false
+		}
+		c[int32(p)] = o
+	}
+	return c, true
+}
+
+// newStreamConsumer returns a group-less consumer on the results topic positioned
+// at cursor, or at the end of every partition the cursor doesn't cover.
+func newStreamConsumer(ctx context.Context, cursor streamCursor) (*kgo.Client, error) {
+	opts, err := cfg.Kafka.ClientOpts()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, kgo.DialTimeout(5*time.Second))
+
+	admin, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ends, err := kadm.NewClient(admin).ListEndOffsets(ctx, cfg.Topics.Results)
+	admin.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := ends.Error(); err != nil {
+		return nil, err
+	}
+
+	offsets := map[int32]kgo.Offset{}
+	ends.Each(func(o kadm.ListedOffset) {
+		if at, ok := cursor[o.Partition]; ok && at <= o.Offset {
+			offsets[o.Partition] = kgo.NewOffset().At(at)
+		} else {
+			offsets[o.Partition] = kgo.NewOffset().At(o.Offset)
+		}
+		cursor[o.Partition] = offsets[o.Partition].EpochOffset().Offset
+	})
+
+	return kgo.NewClient(append(opts,
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{cfg.Topics.Results: offsets}),
+		// Results of aborted exactly-once batches are polled again and
+		// committed later; only show the committed copy.
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+	)...)
+}
+
+// ------------------------
+// HTTP Handler
+// ------------------------
+
+// scanStreamHandler serves GET /scan/{id}/stream: every enriched result of
+// the scan, as Server-Sent Events, optionally narrowed with q.
+func scanStreamHandler() http.Handler {
+	slots := make(chan struct{}, maxStreamClients)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !onKafka() {
+			http.Error(w, "result streaming needs the kafka bus", http.StatusNotImplemented)
+			return
+		}
+		scanID := r.PathValue("id")
+		if scanID == "" {
+			http.Error(w, "scan id required", http.StatusBadRequest)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		select {
+		case slots <- struct{}{}:
+			streamClients.Inc()
+			defer func() {
+				streamClients.Dec()
+				<-slots
+			}()
+		default:
+			http.Error(w, "too many open streams", http.StatusServiceUnavailable)
+			return
+		}
+
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = r.URL.Query().Get("last_event_id")
+		}
+		cursor, resumed := parseStreamCursor(lastID)
+		if cursor == nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+
+		matcher := newResultMatcher(r.URL.Query().Get("q"))
+
+		ctx := r.Context()
+		setupCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		cl, err := newStreamConsumer(setupCtx, cursor)
+		cancel()
+		if err != nil {
+			http.Error(w, "failed to open stream: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer cl.Close()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		log.Printf("[STREAM] Client attached to scan %s (resumed=%v, cursor=%s)", scanID, resumed, cursor)
+		fmt.Fprintf(w, "retry: 3000\nid: %s\n\n", cursor)
+		flusher.Flush()
+
+		for {
+			pollCtx, cancel := context.WithTimeout(ctx, streamHeartbeat)
+			fetches := cl.PollFetches(pollCtx)
+			cancel()
+
+			if ctx.Err() != nil {
+				log.Printf("[STREAM] Client detached from scan %s", scanID)
+				return
+			}
+
+			fetches.EachError(func(t string, p int32, err error) {
+				if !errors.Is(err, context.DeadlineExceeded) {
+					log.Printf("[STREAM] Fetch error %s/%d: %v", t, p, err)
+				}
+			})
+
+			sent := false
+			fetches.EachRecord(func(rec *kgo.Record) {
+				cursor[rec.Partition] = rec.Offset + 1
+
+				var res model.ServiceScanResult
+				if err := model.Unmarshal(rec.Value, &res); err != nil {
+					return
+				}
+				if res.ScanID != scanID || !matcher.Match(res) {
+					return
+				}
+
+				data, err := json.Marshal(res)
+				if err != nil {
+					return
+				}
+				fmt.Fprintf(w, "id: %s\nevent: result\ndata: %s\n\n", cursor, data)
+				streamResults.Inc()
+				sent = true
+			})
+
+			// Keep idle connections alive and move the client's resume point
+			// past records that didn't match.
+			if !sent {
+				fmt.Fprintf(w, ": ping\nid: %s\n\n", cursor)
+			}
+			flusher.Flush()
+		}
+	})
+}
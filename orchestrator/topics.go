@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// dlqStages are the stages that dead-letter to "<stage>_dlq".
+var dlqStages = []string{"scanner", "banner", "enrich", "elasticsearch"}
+
+// pipelineTopic is a topic the pipeline needs and the retention it is kept at.
+type pipelineTopic struct {
+	name      string
+	retention time.Duration
+	// consumed topics must have enough partitions for every consumer in
+	// the group; DLQs are only read by dlq-replay, the control topic by
+	// progress trackers.
+	consumed bool
+}
+
+func pipelineTopics() []pipelineTopic {
+	var topics []pipelineTopic
+	for _, name := range []string{
+		cfg.Topics.ScanRequests,
+		cfg.Topics.HostResults,
+		cfg.Topics.BannerResults,
+		cfg.Topics.Results,
+	} {
+		topics = append(topics, pipelineTopic{name: name, retention: cfg.Provision.Retention, consumed: true})
+	}
+	// Chunk completions, for whoever tracks scan progress.
+	topics = append(topics, pipelineTopic{name: cfg.Topics.Control, retention: cfg.Provision.Retention})
+	for _, stage := range dlqStages {
+		topics = append(topics, pipelineTopic{name: stage + "_dlq", retention: cfg.Provision.DLQRetention})
+	}
+	return topics
+}
+
+func retentionMs(d time.Duration) *string {
+	return kadm.StringPtr(strconv.FormatInt(d.Milliseconds(), 10))
+}
+
+// errUnderPartitioned means a consumed topic has less than half the
+// partitions the configured parallelism needs: most consumers would sit idle.
+var errUnderPartitioned = errors.New("topic is badly under-partitioned")
+
+// checkPartitions warns when a consumed topic cannot give every consumer a
+// partition, and fails when it is badly short.
+func checkPartitions(t pipelineTopic, partitions int) error {
+	want := cfg.Provision.Parallelism
+	if !t.consumed || partitions >= want {
+		return nil
+	}
+	if partitions*2 < want {
+		return fmt.Errorf("%w: %s has %d partitions for %d consumers, run `orchestrator provision -grow`",
+			errUnderPartitioned, t.name, partitions, want)
+	}
+	log.Printf("[WARN] Topic %s has %d partitions, %d consumers will leave some idle", t.name, partitions, want)
+	return nil
+}
+
+// provisionTopics creates missing pipeline topics and checks existing ones.
+// With grow, consumed topics short of cfg.Provision.Partitions are extended
+// to it; partitions can never be removed, so larger topics are left alone.
+func provisionTopics(ctx context.Context, admin *kadm.Client, grow bool) error {
+	topics := pipelineTopics()
+	names := make([]string, len(topics))
+	for i, t := range topics {
+		names[i] = t.name
+	}
+
+	details, err := admin.ListTopics(ctx, names...)
+	if err != nil {
+		return fmt.Errorf("listing topics: %w", err)
+	}
+
+	var errs []error
+	for _, t := range topics {
+		d, ok := details[t.name]
+		if !ok || errors.Is(d.Err, kerr.UnknownTopicOrPartition) {
+			partitions := int32(cfg.Provision.Partitions)
+			if !t.consumed {
+				partitions = 1
+			}
+			_, err := admin.CreateTopic(ctx, partitions, int16(cfg.Provision.ReplicationFactor),
+				map[string]*string{"retention.ms": retentionMs(t.retention)}, t.name)
+			if err != nil && !errors.Is(err, kerr.TopicAlreadyExists) {
+				errs = append(errs, fmt.Errorf("creating %s: %w", t.name, err))
+				continue
+			}
+			log.Printf("[INFO] Created topic %s (%d partitions)", t.name, partitions)
+			continue
+		}
+		if d.Err != nil {
+			errs = append(errs, fmt.Errorf("describing %s: %w", t.name, d.Err))
+			continue
+		}
+
+		partitions := len(d.Partitions)
+		if grow && t.consumed && partitions < cfg.Provision.Partitions {
+			resp, err := admin.UpdatePartitions(ctx, cfg.Provision.Partitions, t.name)
+			if err == nil {
+				err = resp.Error()
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("adding partitions to %s: %w", t.name, err))
+				continue
+			}
+			log.Printf("[INFO] Grew topic %s from %d to %d partitions", t.name, partitions, cfg.Provision.Partitions)
+			partitions = cfg.Provision.Partitions
+		}
+		if err := checkPartitions(t, partitions); err != nil {
+			errs = append(errs, err)
+		}
+		if err := alignRetention(ctx, admin, t); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// alignRetention sets retention.ms on t if it differs from the configured one.
+func alignRetention(ctx context.Context, admin *kadm.Client, t pipelineTopic) error {
+	want := retentionMs(t.retention)
+	rcs, err := admin.DescribeTopicConfigs(ctx, t.name)
+	if err != nil {
+		return fmt.Errorf("describing config of %s: %w", t.name, err)
+	}
+	rc, err := rcs.On(t.name, nil)
+	if err != nil {
+		return fmt.Errorf("describing config of %s: %w", t.name, err)
+	}
+	for _, c := range rc.Configs {
+		if c.Key == "retention.ms" && c.Value != nil && *c.Value == *want {
+			return nil
+		}
+	}
+
+	resp, err := admin.AlterTopicConfigs(ctx, []kadm.AlterConfig{
+		{Op: kadm.SetConfig, Name: "retention.ms", Value: want},
+	}, t.name)
+	if err == nil {
+		_, err = resp.On(t.name, func(r *kadm.AlterConfigsResponse) error { return r.Err })
+	}
+	if err != nil {
+		return fmt.Errorf("setting retention of %s: %w", t.name, err)
+	}
+	log.Printf("[INFO] Set retention of %s to %s", t.name, t.retention)
+	return nil
+}
+
+func newKafkaAdmin() (*kadm.Client, error) {
+	opts, err := cfg.Kafka.ClientOpts()
+	if err != nil {
+		return nil, err
+	}
+	cl, err := kgo.NewClient(append(opts, kgo.DialTimeout(5*time.Second))...)
+	if err != nil {
+		return nil, err
+	}
+	return kadm.NewClient(cl), nil
+}
+
+// runProvisionCommand implements `orchestrator provision`, which creates or
+// verifies every pipeline topic.
+func runProvisionCommand(args []string) {
+	fs := flag.NewFlagSet("provision", flag.ExitOnError)
+	grow := fs.Bool("grow", false, "add partitions to existing topics that have fewer than provision.partitions")
+	_ = fs.Parse(args)
+
+	admin, err := newKafkaAdmin()
+	if err != nil {
+		log.Fatalf("failed to create Kafka client: %v", err)
+	}
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := provisionTopics(ctx, admin, *grow); err != nil {
+		log.Fatalf("provision failed: %v", err)
+	}
+	log.Println("Topics provisioned")
+}
+
+// checkTopics runs at startup. It creates missing topics but refuses to
+// start on a badly under-partitioned one; an unreachable Kafka only warns,
+// as the API can still serve searches.
+func checkTopics() {
+	admin, err := newKafkaAdmin()
+	if err != nil {
+		log.Fatalf("failed to create Kafka client: %v", err)
+	}
+	defer admin.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	err = provisionTopics(ctx, admin, false)
+	if errors.Is(err, errUnderPartitioned) {
+		log.Fatalf("[ERROR] Refusing to start: %v", err)
+	}
+	if err != nil {
+		log.Printf("[WARN] Could not provision topics: %v", err)
+	}
+}
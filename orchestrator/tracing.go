@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Traces start here, at POST /scan, and follow each subnet request through
+// the workers in the traceparent record header. The worker side lives in
+// worker/tracing; both must use the same propagator.
+var tracer = otel.Tracer("github.com/exploravis/orchestrator")
+
+// initTracing installs the propagator and, unless tracing is off, a tracer
+// provider. The API server runs until killed, so spans are not flushed on
+// exit; the batcher exports them every few seconds.
+func initTracing() {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+	switch cfg.Tracing.Exporter {
+	case "otlp":
+		exp, err = otlptracehttp.New(context.Background())
+	case "file":
+		var file *os.File
+		file, err = os.OpenFile(cfg.Tracing.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err == nil {
+			exp, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		}
+	default:
+		return
+	}
+	if err != nil {
+		log.Printf("[WARN] Tracing disabled, unable to create %s exporter: %v", cfg.Tracing.Exporter, err)
+		return
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("orchestrator"))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	log.Printf("[INFO] Exporting traces via %s", cfg.Tracing.Exporter)
+}
+
+// recordHeaders adapts a record's headers to propagation.TextMapCarrier.
+type recordHeaders struct{ rec *kgo.Record }
+
+func (h recordHeaders) Get(key string) string {
+	for _, hd := range h.rec.Headers {
+		if hd.Key == key {
+			return string(hd.Value)
+		}
+	}
+	return ""
+}
+
+func (h recordHeaders) Set(key, value string) {
+	for i, hd := range h.rec.Headers {
+		if hd.Key == key {
+			h.rec.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	h.rec.Headers = append(h.rec.Headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+}
+
+func (h recordHeaders) Keys() []string {
+	keys := make([]string, len(h.rec.Headers))
+	for i, hd := range h.rec.Headers {
+		keys[i] = hd.Key
+	}
+	return keys
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
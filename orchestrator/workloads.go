@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes"
+)
+
+// maxWorkloadEvents caps the warning events reported per deployment.
+const maxWorkloadEvents = 5
+
+// WorkloadReport is one deployment of the Exploravis namespace in /health.
+type WorkloadReport struct {
+	Name string `json:"name"`
+	// Status is "ok" with every desired replica available, "degraded" with
+	// some, and "down" with none.
+	Status      string          `json:"status"`
+	Desired     int32           `json:"desired"`
+	Ready       int32           `json:"ready"`
+	Available   int32           `json:"available"`
+	Updated     int32           `json:"updated"`
+	Unavailable int32           `json:"unavailable"`
+	Restarts    int32           `json:"restarts"`
+	Pods        []PodReport     `json:"pods"`
+	Events      []WarningReport `json:"events"`
+	Error       string          `json:"error,omitempty"`
+}
+
+type PodReport struct {
+	Name     string `json:"name"`
+	Phase    string `json:"phase"`
+	Ready    bool   `json:"ready"`
+	Restarts int32  `json:"restarts"`
+	Node     string `json:"node,omitempty"`
+	// Waiting is why a container is not running, e.g. CrashLoopBackOff.
+	Waiting string `json:"waiting,omitempty"`
+	// LastTermination is why a container last exited, e.g. OOMKilled.
+	LastTermination string    `json:"last_termination,omitempty"`
+	Started         time.Time `json:"started,omitzero"`
+}
+
+type WarningReport struct {
+	Object  string    `json:"object"`
+	Reason  string    `json:"reason"`
+	Message string    `json:"message"`
+	Count   int32     `json:"count"`
+	Last    time.Time `json:"last"`
+}
+
+// getWorkloads reports the deployments of namespace with their pods and
+// the warning events of the last window about them or their pods.
+func getWorkloads(ctx context.Context, clientset kubernetes.Interface, namespace string, window time.Duration) ([]WorkloadReport, error) {
+	deployments, err := clientset.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deployments in %s: %w", namespace, err)
+	}
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in %s: %w", namespace, err)
+	}
+	// Events are only context; the workloads are worth reporting without.
+	var warnings []v1.Event
+	events, eErr := clientset.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: "type=Warning"})
+	if eErr == nil {
+		cutoff := time.Now().Add(-window)
+		for _, ev := range events.Items {
+			// Checked again for clients that ignore field selectors.
+			if ev.Type == v1.EventTypeWarning && eventTime(ev).After(cutoff) {
+				warnings = append(warnings, ev)
+			}
+		}
+		sort.Slice(warnings, func(i, j int) bool {
+			return eventTime(warnings[i]).After(eventTime(warnings[j]))
+		})
+	}
+
+	results := make([]WorkloadReport, 0, len(deployments.Items))
+	for _, dep := range deployments.Items {
+		wl := workloadReport(dep, pods.Items, warnings)
+		if eErr != nil {
+			wl.Error = fmt.Sprintf("failed to list events: %v", eErr)
+		}
+		results = append(results, wl)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	return results, nil
+}
+
+func workloadReport(dep appsv1.Deployment, pods []v1.Pod, warnings []v1.Event) WorkloadReport {
+	desired := int32(1)
+	if dep.Spec.Replicas != nil {
+		desired = *dep.Spec.Replicas
+	}
+	wl := WorkloadReport{
+		Name:        dep.Name,
+		Desired:     desired,
+		Ready:       dep.Status.ReadyReplicas,
+		Available:   dep.Status.AvailableReplicas,
+		Updated:     dep.Status.UpdatedReplicas,
+		Unavailable: dep.Status.UnavailableReplicas,
+		Pods:        make([]PodReport, 0),
+		Events:      make([]WarningReport, 0),
+	}
+	switch {
+	case wl.Available >= desired:
+		wl.Status = "ok"
+	case wl.Available > 0:
+		wl.Status = "degraded"
+	default:
+		wl.Status = "down"
+	}
+
+	owned := map[string]bool{}
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		wl.Error = fmt.Sprintf("invalid selector: %v", err)
+	} else {
+		for _, pod := range pods {
+			if !selector.Matches(labels.Set(pod.Labels)) {
+				continue
+			}
+			p := podReport(pod)
+			wl.Restarts += p.Restarts
+			wl.Pods = append(wl.Pods, p)
+			owned[pod.Name] = true
+		}
+	}
+	sort.Slice(wl.Pods, func(i, j int) bool { return wl.Pods[i].Name < wl.Pods[j].Name })
+
+	// Warnings are about the deployment, its ReplicaSets or its pods.
+	for _, ev := range warnings {
+		if len(wl.Events) == maxWorkloadEvents {
+			break
+		}
+		obj := ev.InvolvedObject
+		switch {
+		case obj.Kind == "Deployment" && obj.Name == dep.Name:
+		case obj.Kind == "ReplicaSet" && ownReplicaSet(dep.Name, obj.Name):
+		case obj.Kind == "Pod" && owned[obj.Name]:
+		default:
+			continue
+		}
+		wl.Events = append(wl.Events, WarningReport{
+			Object:  strings.ToLower(obj.Kind) + "/" + obj.Name,
+			Reason:  ev.Reason,
+			Message: ev.Message,
+			Count:   max(ev.Count, 1),
+			Last:    eventTime(ev),
+		})
+	}
+	return wl
+}
+
+// ownReplicaSet reports whether rs is named like a ReplicaSet of
+// deployment: its name followed by a pod-template hash, which has no dashes.
+// banner-worker-7d9f is not one of deployment banner's.
+func ownReplicaSet(deployment, rs string) bool {
+	hash, ok := strings.CutPrefix(rs, deployment+"-")
+	if !ok || hash == "" {
+		return false
+	}
+	for _, c := range hash {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func podReport(pod v1.Pod) PodReport {
+	p := PodReport{
+		Name:  pod.Name,
+		Phase: string(pod.Status.Phase),
+		Node:  pod.Spec.NodeName,
+	}
+	if pod.Status.StartTime != nil {
+		p.Started = pod.Status.StartTime.Time
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodReady {
+			p.Ready = condition.Status == v1.ConditionTrue
+		}
+	}
+	var lastExit time.Time
+	for _, cs := range pod.Status.ContainerStatuses {
+		p.Restarts += cs.RestartCount
+		if cs.State.Waiting != nil && p.Waiting == "" {
+			p.Waiting = cs.State.Waiting.Reason
+		}
+		if t := cs.LastTerminationState.Terminated; t != nil && !t.FinishedAt.Time.Before(lastExit) {
+			lastExit = t.FinishedAt.Time
+			p.LastTermination = t.Reason
+		}
+	}
+	return p
+}
+
+// eventTime is when an event last occurred. Events from the older API set
+// LastTimestamp, those from events.k8s.io only EventTime.
+func eventTime(ev v1.Event) time.Time {
+	switch {
+	case !ev.LastTimestamp.IsZero():
+		return ev.LastTimestamp.Time
+	case !ev.EventTime.IsZero():
+		return ev.EventTime.Time
+	default:
+		return ev.CreationTimestamp.Time
+	}
+}
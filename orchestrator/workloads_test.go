@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testNamespace = "exploravis"
+
+func deployment(name string, replicas, available int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}},
+		},
+		Status: appsv1.DeploymentStatus{
+			ReadyReplicas:       available,
+			AvailableReplicas:   available,
+			UpdatedReplicas:     replicas,
+			UnavailableReplicas: replicas - available,
+		},
+	}
+}
+
+func pod(name, app string, ready bool, restarts ...int32) *v1.Pod {
+	p := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, Labels: map[string]string{"app": app}},
+		Spec:       v1.PodSpec{NodeName: "node-1"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	status := v1.ConditionFalse
+	if ready {
+		status = v1.ConditionTrue
+	}
+	p.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: status}}
+	for _, n := range restarts {
+		p.Status.ContainerStatuses = append(p.Status.ContainerStatuses, v1.ContainerStatus{RestartCount: n})
+	}
+	return p
+}
+
+func event(name, kind, object, typ, reason string, at time.Time) *v1.Event {
+	return &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		InvolvedObject: v1.ObjectReference{Kind: kind, Name: object, Namespace: testNamespace},
+		Type:           typ,
+		Reason:         reason,
+		Message:        reason + " on " + object,
+		LastTimestamp:  metav1.NewTime(at),
+	}
+}
+
+func TestGetWorkloads(t *testing.T) {
+	now := time.Now()
+	crashing := pod("banner-worker-7d9f-abcde", "banner-worker", false, 4, 1)
+	crashing.Status.ContainerStatuses[0].State.Waiting = &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}
+	crashing.Status.ContainerStatuses[0].LastTerminationState.Terminated = &v1.ContainerStateTerminated{
+		Reason: "OOMKilled", FinishedAt: metav1.NewTime(now.Add(-time.Minute)),
+	}
+	crashing.Status.ContainerStatuses[1].LastTerminationState.Terminated = &v1.ContainerStateTerminated{
+		Reason: "Error", FinishedAt: metav1.NewTime(now.Add(-time.Hour)),
+	}
+
+	cs := fake.NewClientset(
+		deployment("scanner-worker", 3, 3),
+		deployment("banner-worker", 2, 1),
+		deployment("enrich-meta-worker", 1, 0),
+		pod("scanner-worker-5c6b-aaaaa", "scanner-worker", true),
+		pod("scanner-worker-5c6b-bbbbb", "scanner-worker", true, 2),
+		pod("scanner-worker-5c6b-ccccc", "scanner-worker", true),
+		crashing,
+		pod("banner-worker-7d9f-fghij", "banner-worker", true),
+		pod("unrelated-pod", "other", true, 9),
+		event("e1", "Pod", "banner-worker-7d9f-abcde", v1.EventTypeWarning, "BackOff", now.Add(-2*time.Minute)),
+		event("e2", "Pod", "banner-worker-7d9f-abcde", v1.EventTypeWarning, "OOMKilling", now.Add(-time.Minute)),
+		event("e3", "ReplicaSet", "banner-worker-7d9f", v1.EventTypeWarning, "FailedCreate", now.Add(-3*time.Minute)),
+		event("e4", "Pod", "banner-worker-7d9f-fghij", v1.EventTypeNormal, "Pulled", now),
+		event("e5", "Pod", "banner-worker-7d9f-fghij", v1.EventTypeWarning, "Unhealthy", now.Add(-2*time.Hour)),
+		event("e6", "Pod", "unrelated-pod", v1.EventTypeWarning, "BackOff", now),
+		event("e7", "Deployment", "enrich-meta-worker", v1.EventTypeWarning, "ProgressDeadlineExceeded", now),
+	)
+
+	got, err := getWorkloads(context.Background(), cs, testNamespace, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := map[string]WorkloadReport{}
+	var names []string
+	for _, wl := range got {
+		byName[wl.Name] = wl
+		names = append(names, wl.Name)
+	}
+	if want := []string{"banner-worker", "enrich-meta-worker", "scanner-worker"}; !slices.Equal(names, want) {
+		t.Fatalf("workloads = %v, want %v, sorted", names, want)
+	}
+
+	t.Run("status", func(t *testing.T) {
+		for name, want := range map[string]string{"scanner-worker": "ok", "banner-worker": "degraded", "enrich-meta-worker": "down"} {
+			if byName[name].Status != want {
+				t.Errorf("%s status = %q, want %q", name, byName[name].Status, want)
+			}
+		}
+		banner := byName["banner-worker"]
+		if banner.Desired != 2 || banner.Available != 1 || banner.Unavailable != 1 {
+			t.Errorf("banner replicas = %+v", banner)
+		}
+	})
+
+	t.Run("pods", func(t *testing.T) {
+		scanner := byName["scanner-worker"]
+		if len(scanner.Pods) != 3 || scanner.Restarts != 2 {
+			t.Errorf("scanner pods = %d, restarts = %d, want 3 and 2", len(scanner.Pods), scanner.Restarts)
+		}
+		banner := byName["banner-worker"]
+		if len(banner.Pods) != 2 || banner.Restarts != 5 {
+			t.Fatalf("banner pods = %d, restarts = %d, want 2 and 5", len(banner.Pods), banner.Restarts)
+		}
+		p := banner.Pods[0]
+		if p.Name != "banner-worker-7d9f-abcde" || p.Ready || p.Phase != "Running" || p.Node != "node-1" {
+			t.Errorf("crashing pod = %+v", p)
+		}
+		if p.Waiting != "CrashLoopBackOff" || p.LastTermination != "OOMKilled" {
+			t.Errorf("crashing pod waiting = %q, last termination = %q, want CrashLoopBackOff and the latest, OOMKilled", p.Waiting, p.LastTermination)
+		}
+		if !banner.Pods[1].Ready {
+			t.Errorf("healthy pod not ready: %+v", banner.Pods[1])
+		}
+		if len(byName["enrich-meta-worker"].Pods) != 0 {
+			t.Errorf("enrich has pods: %+v", byName["enrich-meta-worker"].Pods)
+		}
+	})
+
+	t.Run("events", func(t *testing.T) {
+		var got []string
+		for _, ev := range byName["banner-worker"].Events {
+			got = append(got, ev.Reason)
+		}
+		// Newest first; not the Normal one, the stale one or another
+		// workload's.
+		if want := []string{"OOMKilling", "BackOff", "FailedCreate"}; !slices.Equal(got, want) {
+			t.Errorf("banner events = %v, want %v", got, want)
+		}
+		ev := byName["banner-worker"].Events[0]
+		if ev.Object != "pod/banner-worker-7d9f-abcde" || ev.Count != 1 {
+			t.Errorf("event = %+v", ev)
+		}
+		if evs := byName["enrich-meta-worker"].Events; len(evs) != 1 || evs[0].Reason != "ProgressDeadlineExceeded" {
+			t.Errorf("enrich events = %+v", evs)
+		}
+		if evs := byName["scanner-worker"].Events; len(evs) != 0 {
+			t.Errorf("scanner events = %+v", evs)
+		}
+	})
+}
+
+// A deployment whose name prefixes another's does not take the other's
+// ReplicaSet warnings.
+func TestGetWorkloadsReplicaSetEvents(t *testing.T) {
+	now := time.Now()
+	cs := fake.NewClientset(
+		deployment("banner", 1, 1),
+		deployment("banner-worker", 1, 0),
+		event("e1", "ReplicaSet", "banner-worker-7d9f", v1.EventTypeWarning, "FailedCreate", now),
+		event("e2", "ReplicaSet", "banner-5c6b", v1.EventTypeWarning, "FailedCreate", now),
+	)
+	got, err := getWorkloads(context.Background(), cs, testNamespace, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"banner":        "[replicaset/banner-5c6b]",
+		"banner-worker": "[replicaset/banner-worker-7d9f]",
+	}
+	for _, wl := range got {
+		var objects []string
+		for _, ev := range wl.Events {
+			objects = append(objects, ev.Object)
+		}
+		if fmt.Sprint(objects) != want[wl.Name] {
+			t.Errorf("%s events = %v, want %s", wl.Name, objects, want[wl.Name])
+		}
+	}
+}
+
+func TestOwnReplicaSet(t *testing.T) {
+	tests := []struct {
+		rs   string
+		want bool
+	}{
+		{"banner-7d9f8b6c5", true},
+		{"banner-1234567890", true}, // older clusters hash in decimal
+		{"banner-worker-7d9f8b6c5", false},
+		{"banner-", false},
+		{"banner", false},
+		{"bannerx-7d9f", false},
+	}
+	for _, tt := range tests {
+		if got := ownReplicaSet("banner", tt.rs); got != tt.want {
+			t.Errorf("ownReplicaSet(banner, %s) = %v, want %v", tt.rs, got, tt.want)
+		}
+	}
+}
+
+func TestGetWorkloadsCapsEvents(t *testing.T) {
+	now := time.Now()
+	objs := []runtime.Object{deployment("banner-worker", 1, 1)}
+	for i := range maxWorkloadEvents + 3 {
+		objs = append(objs, event(fmt.Sprintf("e%d", i), "Deployment", "banner-worker", v1.EventTypeWarning, "R", now.Add(-time.Duration(i)*time.Minute)))
+	}
+	got, err := getWorkloads(context.Background(), fake.NewClientset(objs...), testNamespace, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(got[0].Events); n != maxWorkloadEvents {
+		t.Errorf("%d events, want %d", n, maxWorkloadEvents)
+	}
+}
+
+func TestGetClusterNodes(t *testing.T) {
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Labels: map[string]string{
+				"node-role.kubernetes.io/control-plane": "",
+				"node-role.kubernetes.io/worker":        "",
+				"kubernetes.io/arch":                    "amd64",
+			},
+			CreationTimestamp: metav1.NewTime(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)),
+		},
+		Spec: v1.NodeSpec{Unschedulable: true},
+		Status: v1.NodeStatus{
+			Addresses: []v1.NodeAddress{
+				{Type: v1.NodeInternalIP, Address: "10.0.0.5"},
+				{Type: v1.NodeExternalIP, Address: "203.0.113.5"},
+			},
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionTrue},
+				{Type: v1.NodeMemoryPressure, Status: v1.ConditionTrue},
+				{Type: v1.NodeDiskPressure, Status: v1.ConditionFalse},
+			},
+			Capacity: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("4"),
+				v1.ResourceMemory: resource.MustParse("16Gi"),
+			},
+			Allocatable: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("3800m"),
+				v1.ResourceMemory: resource.MustParse("15Gi"),
+			},
+			NodeInfo: v1.NodeSystemInfo{KubeletVersion: "v1.34.1", OSImage: "Ubuntu 24.04", Architecture: "amd64"},
+		},
+	}
+
+	nodes, err := getClusterNodes(context.Background(), fake.NewClientset(node))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 {
+		t.Fatalf("%d nodes, want 1", len(nodes))
+	}
+	got := nodes[0]
+	if _, ok := got["node"]; ok {
+		t.Error("the full node object is still reported")
+	}
+	want := map[string]any{
+		"name":               "node-1",
+		"status":             "True",
+		"internal_ip":        "10.0.0.5",
+		"external_ip":        "203.0.113.5",
+		"capacity_cpu":       "4",
+		"capacity_memory":    "16Gi",
+		"allocatable_cpu":    "3800m",
+		"allocatable_memory": "15Gi",
+		"unschedulable":      true,
+		"kubelet_version":    "v1.34.1",
+		"os_image":           "Ubuntu 24.04",
+		"architecture":       "amd64",
+	}
+	for key, v := range want {
+		if got[key] != v {
+			t.Errorf("%s = %v, want %v", key, got[key], v)
+		}
+	}
+	if roles := got["roles"].([]string); !slices.Equal(roles, []string{"control-plane", "worker"}) {
+		t.Errorf("roles = %v", roles)
+	}
+	if pressure := got["pressure"].([]string); !slices.Equal(pressure, []string{"MemoryPressure"}) {
+		t.Errorf("pressure = %v", pressure)
+	}
+	if created := got["created"].(time.Time); !created.Equal(node.CreationTimestamp.Time) {
+		t.Errorf("created = %v", created)
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok && !slices.Contains([]string{"roles", "pressure", "created"}, key) {
+			t.Errorf("unexpected key %q", key)
+		}
+	}
+}
@@ -2,8 +2,9 @@ module test
 
 go 1.22.2
 
+require github.com/RumbleDiscovery/recog-go v0.1.0
+
 require (
-	github.com/RumbleDiscovery/recog-go v0.1.0 // indirect
 	github.com/sirupsen/logrus v1.8.1 // indirect
 	golang.org/x/sys v0.0.0-20210420205809-ac73e9fd8988 // indirect
 )
@@ -0,0 +1,110 @@
+// Package ack completes a consumed record only once all the work for it is
+// done, giving the workers at-least-once processing. Work that fans out
+// holds the record open until every piece has finished; the bus
+// subscription is then acked, which on Kafka commits the offset once every
+// earlier record of the partition is acked too.
+package ack
+
+import (
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// FailFunc is called once for a record whose work finished with an error.
+// Workers dead-letter it there and call done once the dead letter is
+// settled, which acks the record. It may run in a produce callback, so it
+// must not wait for another produce.
+type FailFunc func(rec *kgo.Record, err error, done func())
+
+// Acker is what a completed record is acked on; bus.Subscription
+// implements it.
+type Acker interface {
+	Ack(rec *kgo.Record)
+}
+
+type Tracker struct {
+	mu       sync.Mutex
+	sub      Acker
+	onFail   FailFunc
+	inFlight int
+}
+
+func NewTracker(onFail FailFunc) *Tracker {
+	return &Tracker{onFail: onFail}
+}
+
+func (t *Tracker) Bind(sub Acker) {
+	t.mu.Lock()
+	t.sub = sub
+	t.mu.Unlock()
+}
+
+// InFlight is the number of tracked records not yet completed.
+func (t *Tracker) InFlight() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.inFlight
+}
+
+// Track starts the work for a polled record, holding one reference.
+func (t *Tracker) Track(rec *kgo.Record) *Ack {
+	t.mu.Lock()
+	t.inFlight++
+	t.mu.Unlock()
+	return &Ack{t: t, rec: rec, refs: 1}
+}
+
+func (t *Tracker) complete(rec *kgo.Record) {
+	t.mu.Lock()
+	t.inFlight--
+	sub := t.sub
+	t.mu.Unlock()
+	if sub != nil {
+		sub.Ack(rec)
+	}
+}
+
+// Ack completes one tracked record. Work that fans out (several produced
+// messages for one consumed record) calls Add before handing each piece off
+// and Done as each piece finishes; the record completes when all are done.
+type Ack struct {
+	t   *Tracker
+	rec *kgo.Record
+
+	mu   sync.Mutex
+	refs int
+	err  error
+}
+
+func (a *Ack) Add(n int) {
+	a.mu.Lock()
+	a.refs += n
+	a.mu.Unlock()
+}
+
+// Done finishes one piece of work. The first non-nil error is passed to the
+// tracker's FailFunc once the record completes.
+func (a *Ack) Done(err error) {
+	a.mu.Lock()
+	if err != nil && a.err == nil {
+		a.err = err
+	}
+	a.refs--
+	last := a.refs == 0
+	a.mu.Unlock()
+
+	if !last {
+		return
+	}
+	if a.err != nil && a.t.onFail != nil {
+		a.t.onFail(a.rec, a.err, func() { a.t.complete(a.rec) })
+		return
+	}
+	a.t.complete(a.rec)
+}
+
+// Record is the consumed record this Ack completes.
+func (a *Ack) Record() *kgo.Record {
+	return a.rec
+}
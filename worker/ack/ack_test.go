@@ -0,0 +1,142 @@
+package ack
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type recordingAcker struct {
+	mu    sync.Mutex
+	acked []int64
+}
+
+func (r *recordingAcker) Ack(rec *kgo.Record) {
+	r.mu.Lock()
+	r.acked = append(r.acked, rec.Offset)
+	r.mu.Unlock()
+}
+
+type failure struct {
+	offset int64
+	err    error
+}
+
+func TestAck(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	tests := []struct {
+		name string
+		add  int
+		// dones are the errors each piece finishes with, in order.
+		dones []error
+		// settle calls the FailFunc's done; otherwise the dead letter is
+		// still in flight.
+		settle    bool
+		wantAcked bool
+		wantFail  error
+	}{
+		{name: "single piece", dones: []error{nil}, wantAcked: true},
+		{name: "fan-out waits for every piece", add: 2, dones: []error{nil, nil}, wantAcked: false},
+		{name: "fan-out completes", add: 2, dones: []error{nil, nil, nil}, wantAcked: true},
+		{name: "failure is dead-lettered first", dones: []error{errA}, wantFail: errA},
+		{name: "acked once the dead letter settles", dones: []error{errA}, settle: true, wantAcked: true, wantFail: errA},
+		{name: "first error wins", add: 2, dones: []error{nil, errA, errB}, settle: true, wantAcked: true, wantFail: errA},
+		{name: "empty fan-out", add: 0, dones: []error{nil}, wantAcked: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fails []failure
+			tr := NewTracker(func(rec *kgo.Record, err error, done func()) {
+				fails = append(fails, failure{rec.Offset, err})
+				if tt.settle {
+					done()
+				}
+			})
+			sub := &recordingAcker{}
+			tr.Bind(sub)
+
+			a := tr.Track(&kgo.Record{Offset: 42})
+			a.Add(tt.add)
+			for _, err := range tt.dones {
+				a.Done(err)
+			}
+
+			if acked := len(sub.acked) == 1; acked != tt.wantAcked {
+				t.Errorf("acked %v, want %v", sub.acked, tt.wantAcked)
+			}
+			if tt.wantFail == nil && len(fails) != 0 {
+				t.Errorf("FailFunc called: %v", fails)
+			}
+			if tt.wantFail != nil && (len(fails) != 1 || fails[0] != (failure{42, tt.wantFail})) {
+				t.Errorf("failures = %v, want one for %v", fails, tt.wantFail)
+			}
+			wantInFlight := 1
+			if tt.wantAcked {
+				wantInFlight = 0
+			}
+			if tr.InFlight() != wantInFlight {
+				t.Errorf("in flight = %d, want %d", tr.InFlight(), wantInFlight)
+			}
+		})
+	}
+}
+
+func TestAckWithoutFailFunc(t *testing.T) {
+	tr := NewTracker(nil)
+	sub := &recordingAcker{}
+	tr.Bind(sub)
+	tr.Track(&kgo.Record{Offset: 1}).Done(errors.New("lost"))
+	if len(sub.acked) != 1 {
+		t.Errorf("acked %v; a failure with nowhere to go is still acked", sub.acked)
+	}
+}
+
+func TestTrackerOutOfOrder(t *testing.T) {
+	tr := NewTracker(nil)
+	sub := &recordingAcker{}
+	tr.Bind(sub)
+
+	acks := make([]*Ack, 5)
+	for i := range acks {
+		acks[i] = tr.Track(&kgo.Record{Offset: int64(i)})
+	}
+	for _, i := range []int{3, 0, 4, 1, 2} {
+		acks[i].Done(nil)
+	}
+	// The tracker acks as work completes; ordering offsets is the
+	// subscription's job.
+	if got := sub.acked; len(got) != 5 || got[0] != 3 || got[4] != 2 {
+		t.Errorf("acked %v, want completion order", got)
+	}
+	if tr.InFlight() != 0 {
+		t.Errorf("in flight = %d", tr.InFlight())
+	}
+}
+
+func TestTrackerConcurrentPieces(t *testing.T) {
+	tr := NewTracker(nil)
+	sub := &recordingAcker{}
+	tr.Bind(sub)
+
+	const pieces = 100
+	a := tr.Track(&kgo.Record{})
+	a.Add(pieces)
+	var wg sync.WaitGroup
+	for range pieces {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.Done(nil)
+		}()
+	}
+	wg.Wait()
+	if len(sub.acked) != 0 {
+		t.Fatal("acked before the tracking reference was released")
+	}
+	a.Done(nil)
+	if len(sub.acked) != 1 {
+		t.Errorf("acked %d times, want once", len(sub.acked))
+	}
+}
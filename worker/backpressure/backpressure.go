@@ -0,0 +1,136 @@
+// Package backpressure stops a worker's fetch loop from blocking on a full
+// job queue. Blocking there delays polling long enough for the group to
+// rebalance; instead, the loop only polls as many records as the queue has
+// room for, and partitions feeding a saturated queue are paused, so the
+// client stops buffering them, until the workers have drained it.
+package backpressure
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/exploravis/bus"
+	"github.com/exploravis/worker/metrics"
+)
+
+// Controller pauses a partition when a record from it is queued above the
+// high watermark, and resumes every paused partition once the queue is back
+// below the low watermark.
+type Controller struct {
+	depth    func() int
+	capacity int
+	high     int
+	low      int
+
+	mu     sync.Mutex
+	sub    bus.Subscription
+	paused map[string][]int32
+}
+
+// New uses watermarks at 75% and 25% of capacity. depth reports the current
+// queue length, typically len(jobQueue).
+func New(depth func() int, capacity int) *Controller {
+	metrics.QueueCapacity.Set(float64(capacity))
+	return &Controller{
+		depth:    depth,
+		capacity: capacity,
+		high:     capacity * 3 / 4,
+		low:      capacity / 4,
+		paused:   map[string][]int32{},
+	}
+}
+
+// roomPoll is how often Room checks a full queue again.
+const roomPoll = 50 * time.Millisecond
+
+// Room waits until the queue has room and returns how much, which the fetch
+// loop polls at most, so every polled record can be queued without waiting.
+// It returns 0 once ctx is done.
+func (c *Controller) Room(ctx context.Context) int {
+	for {
+		if free := c.capacity - c.depth(); free > 0 {
+			return free
+		}
+		select {
+		case <-ctx.Done():
+			return 0
+		case <-time.After(roomPoll):
+		}
+	}
+}
+
+func (c *Controller) Bind(sub bus.Subscription) {
+	c.mu.Lock()
+	c.sub = sub
+	c.mu.Unlock()
+}
+
+// Admit is called by the fetch loop after queueing work from a partition.
+func (c *Controller) Admit(topic string, partition int32) {
+	depth := c.depth()
+	if depth < c.high {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sub == nil {
+		return
+	}
+	for _, p := range c.paused[topic] {
+		if p == partition {
+			return
+		}
+	}
+	if len(c.paused) == 0 {
+		log.Printf("[WARN] Queue at %d/%d, pausing fetches", depth, c.high)
+		metrics.FetchPauses.Inc()
+		metrics.FetchPaused.Set(1)
+	}
+	c.paused[topic] = append(c.paused[topic], partition)
+	c.sub.Pause(topic, partition)
+	metrics.PausedPartitions.Set(float64(c.count()))
+}
+
+// Run samples the queue depth and resumes paused partitions once it drops
+// below the low watermark. It returns when ctx is done.
+func (c *Controller) Run(ctx context.Context) {
+	t := time.NewTicker(200 * time.Millisecond)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		depth := c.depth()
+		metrics.QueueDepth.Set(float64(depth))
+		if depth <= c.low {
+			c.resume(depth)
+		}
+	}
+}
+
+func (c *Controller) resume(depth int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.paused) == 0 || c.sub == nil {
+		return
+	}
+	log.Printf("[INFO] Queue down to %d, resuming %d partitions", depth, c.count())
+	c.sub.ResumeAll()
+	c.paused = map[string][]int32{}
+	metrics.PausedPartitions.Set(0)
+	metrics.FetchPaused.Set(0)
+}
+
+func (c *Controller) count() int {
+	n := 0
+	for _, parts := range c.paused {
+		n += len(parts)
+	}
+	return n
+}
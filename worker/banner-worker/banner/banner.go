@@ -0,0 +1,94 @@
+// Package banner grabs service banners with zgrab2, picking the protocol
+// module from the port.
+package banner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	// "github.com/projectdiscovery/naabu/v2/pkg/port"
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/tracing"
+	"github.com/zmap/zgrab2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Grab runs the zgrab2 module matching s.Port under a span named after it,
+// as a child of ctx's span.
+func Grab(ctx context.Context, s ServiceScanRequest) (model.ServiceScanResult, error) {
+	portNum, err := strconv.Atoi(s.Port)
+	if err != nil {
+		return model.ServiceScanResult{}, fmt.Errorf("invalid port %q", s.Port)
+	}
+
+	target := &zgrab2.ScanTarget{
+		IP:   net.ParseIP(s.IP),
+		Port: uint(portNum),
+	}
+
+	if target.IP == nil {
+		return model.ServiceScanResult{}, fmt.Errorf("invalid IP format %q", s.IP)
+	}
+
+	var result *model.ServiceScanResult
+	protocol := moduleFor(portNum)
+	_, span := tracing.Tracer.Start(ctx, "grab "+protocol, trace.WithAttributes(
+		attribute.String("scan.id", s.ScanID),
+		semconv.NetworkPeerAddress(s.IP),
+		semconv.NetworkPeerPort(portNum),
+	))
+	start := time.Now()
+
+	switch protocol {
+	case "http":
+		result = scanHTTP(target)
+	case "https":
+		result = scanHTTPS(target)
+	case "ftp":
+		result = scanFTP(target)
+	case "ssh":
+		result = scanSSH(target)
+	default:
+		result = scanRawTCP(target)
+	}
+
+	grabDuration.WithLabelValues(protocol).Observe(time.Since(start).Seconds())
+	outcome := "ok"
+	if result == nil {
+		outcome = "error"
+		span.SetStatus(codes.Error, "grab failed or timed out")
+	}
+	grabs.WithLabelValues(protocol, outcome).Inc()
+	span.End()
+
+	// this shouldn't happen
+	if result == nil {
+		return model.ServiceScanResult{}, fmt.Errorf("scan of %s:%d failed or timed out", s.IP, portNum)
+	}
+
+	result.ScanID = s.ScanID
+	return *result, nil
+}
+
+// moduleFor picks the zgrab2 module for a port; it doubles as the protocol
+// label on the grab metrics.
+func moduleFor(port int) string {
+	switch port {
+	// HTTP family
+	case 80, 8080, 8000:
+		return "http"
+	case 443:
+		return "https"
+	case 21:
+		return "ftp"
+	case 22:
+		return "ssh"
+	}
+	return "tcp"
+}
@@ -1,4 +1,4 @@
-package main
+package banner
 
 import (
 	"context"
@@ -6,11 +6,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/exploravis/model"
 	"github.com/zmap/zgrab2"
 	ftpmod "github.com/zmap/zgrab2/modules/ftp"
 )
 
-func scanFTP(t *zgrab2.ScanTarget) *ServiceScanResult {
+func scanFTP(t *zgrab2.ScanTarget) *model.ServiceScanResult {
 	log.Printf("Scanning target: %s:%d", t.IP.String(), t.Port)
 
 	var mod ftpmod.Module
@@ -41,7 +42,7 @@ func scanFTP(t *zgrab2.ScanTarget) *ServiceScanResult {
 	status, out, err := scanner.Scan(ctx, dialerGroup, t)
 	if err != nil {
 		log.Printf("FTP scan failed for %s:%d: %v", t.IP.String(), t.Port, err)
-		return &ServiceScanResult{
+		return &model.ServiceScanResult{
 			IP:       t.IP.String(),
 			Port:     int(t.Port),
 			Protocol: "FTP",
@@ -51,7 +52,7 @@ func scanFTP(t *zgrab2.ScanTarget) *ServiceScanResult {
 
 	if status != zgrab2.SCAN_SUCCESS {
 		log.Printf("FTP scan not successful for %s:%d (Status: %s)", t.IP.String(), t.Port, status)
-		return &ServiceScanResult{
+		return &model.ServiceScanResult{
 			IP:       t.IP.String(),
 			Port:     int(t.Port),
 			Protocol: "FTP",
@@ -62,7 +63,7 @@ func scanFTP(t *zgrab2.ScanTarget) *ServiceScanResult {
 	res, ok := out.(*ftpmod.ScanResults)
 	if !ok || res == nil {
 		log.Printf("FTP scan output invalid for %s:%d", t.IP.String(), t.Port)
-		return &ServiceScanResult{
+		return &model.ServiceScanResult{
 			IP:       t.IP.String(),
 			Port:     int(t.Port),
 			Protocol: "FTP",
@@ -83,7 +84,7 @@ func scanFTP(t *zgrab2.ScanTarget) *ServiceScanResult {
 	}
 	unifiedBanner := sanitizeBanner([]byte(strings.Join(bannerParts, " | ")))
 
-	return &ServiceScanResult{
+	return &model.ServiceScanResult{
 		IP:        t.IP.String(),
 		Port:      int(t.Port),
 		Protocol:  "FTP",
@@ -1,4 +1,4 @@
-package main
+package banner
 
 import (
 	"context"
@@ -8,11 +8,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/exploravis/model"
 	"github.com/zmap/zgrab2"
 	httpmod "github.com/zmap/zgrab2/modules/http"
 )
 
-func scanHTTP(t *zgrab2.ScanTarget) *ServiceScanResult {
+func scanHTTP(t *zgrab2.ScanTarget) *model.ServiceScanResult {
 	log.Printf("Scanning target: %s:%d", t.IP.String(), t.Port)
 
 	var mod httpmod.Module
@@ -103,17 +104,17 @@ func scanHTTP(t *zgrab2.ScanTarget) *ServiceScanResult {
 	bannerBuilder.WriteString(body)
 	bannerStr := bannerBuilder.String()
 
-	httpInfo := map[string]any{
-		"status_code":    res.Response.StatusCode,
-		"headers":        normHeaders,
-		"title":          title,
-		"body_preview":   body,
-		"body_hash":      bodyHash,
-		"content_length": len(body),
-		"tags":           tags,
+	httpInfo := &model.HTTPInfo{
+		StatusCode:    res.Response.StatusCode,
+		Headers:       normHeaders,
+		Title:         title,
+		BodyPreview:   body,
+		BodyHash:      bodyHash,
+		ContentLength: len(body),
+		Tags:          tags,
 	}
 
-	return &ServiceScanResult{
+	return &model.ServiceScanResult{
 		IP:        t.IP.String(),
 		Port:      int(t.Port),
 		Protocol:  "HTTP",
@@ -1,4 +1,4 @@
-package main
+package banner
 
 import (
 	"crypto/sha256"
@@ -11,10 +11,11 @@ import (
 	"strings"
 	"time"
 
+	"github.com/exploravis/model"
 	"github.com/zmap/zgrab2"
 )
 
-func scanHTTPS(target *zgrab2.ScanTarget) *ServiceScanResult {
+func scanHTTPS(target *zgrab2.ScanTarget) *model.ServiceScanResult {
 	const (
 		dialTimeout  = 5 * time.Second
 		writeTimeout = 3 * time.Second
@@ -34,7 +35,7 @@ func scanHTTPS(target *zgrab2.ScanTarget) *ServiceScanResult {
 	dialer := &net.Dialer{Timeout: dialTimeout}
 	conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
 	if err != nil {
-		return &ServiceScanResult{
+		return &model.ServiceScanResult{
 			IP:       ipStr,
 			Port:     int(target.Port),
 			Protocol: "HTTPS",
@@ -77,25 +78,25 @@ func scanHTTPS(target *zgrab2.ScanTarget) *ServiceScanResult {
 	// TLS Metadata
 	// -------------------------
 	state := conn.ConnectionState()
-	tlsInfo := map[string]any{
-		"version":             tlsVersionString(state.Version),
-		"cipher_suite":        tls.CipherSuiteName(state.CipherSuite),
-		"handshake_ok":        state.HandshakeComplete,
-		"negotiated_protocol": state.NegotiatedProtocol,
-		"alpn":                state.NegotiatedProtocol,
+	tlsInfo := &model.TLSInfo{
+		Version:            tlsVersionString(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		HandshakeOK:        state.HandshakeComplete,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+		ALPN:               state.NegotiatedProtocol,
 	}
 
 	if len(state.PeerCertificates) > 0 {
 		c := state.PeerCertificates[0]
-		tlsInfo["certificate"] = map[string]any{
-			"subject":    c.Subject.String(),
-			"issuer":     c.Issuer.String(),
-			"dns_names":  c.DNSNames,
-			"serial":     c.SerialNumber.String(),
-			"not_before": c.NotBefore,
-			"not_after":  c.NotAfter,
-			"sig_alg":    c.SignatureAlgorithm.String(),
-			"public_key": fmt.Sprintf("%T", c.PublicKey),
+		tlsInfo.Certificate = &model.Certificate{
+			Subject:   c.Subject.String(),
+			Issuer:    c.Issuer.String(),
+			DNSNames:  c.DNSNames,
+			Serial:    c.SerialNumber.String(),
+			NotBefore: c.NotBefore,
+			NotAfter:  c.NotAfter,
+			SigAlg:    c.SignatureAlgorithm.String(),
+			PublicKey: fmt.Sprintf("%T", c.PublicKey),
 		}
 	}
 
@@ -104,7 +105,7 @@ func scanHTTPS(target *zgrab2.ScanTarget) *ServiceScanResult {
 	// -------------------------
 	var banner strings.Builder
 	banner.WriteString(fmt.Sprintf("TLS: %s %s\n",
-		tlsInfo["version"], tlsInfo["cipher_suite"]))
+		tlsInfo.Version, tlsInfo.CipherSuite))
 
 	if statusLine != "" {
 		banner.WriteString(statusLine + "\n")
@@ -119,16 +120,16 @@ func scanHTTPS(target *zgrab2.ScanTarget) *ServiceScanResult {
 	// -------------------------
 	// Structured HTTP object
 	// -------------------------
-	httpInfo := map[string]any{
-		"status_line":  statusLine,
-		"headers":      headers,
-		"title":        title,
-		"body_len":     len(body),
-		"body_preview": body,
-		"body_hash":    bodyHash,
+	httpInfo := &model.HTTPInfo{
+		StatusLine:    statusLine,
+		Headers:       headers,
+		Title:         title,
+		ContentLength: len(body),
+		BodyPreview:   body,
+		BodyHash:      bodyHash,
 	}
 
-	return &ServiceScanResult{
+	return &model.ServiceScanResult{
 		IP:        ipStr,
 		Port:      int(target.Port),
 		Protocol:  "HTTPS",
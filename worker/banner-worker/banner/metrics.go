@@ -0,0 +1,23 @@
+package banner
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	grabs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "exploravis",
+		Subsystem: "banner",
+		Name:      "grabs_total",
+		Help:      "Banner grabs by protocol module and outcome (ok or error).",
+	}, []string{"protocol", "outcome"})
+	grabDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "exploravis",
+		Subsystem: "banner",
+		Name:      "grab_duration_seconds",
+		Help:      "Time spent in one protocol module's grab, by protocol.",
+		// Modules time out after a few seconds.
+		Buckets: []float64{.05, .1, .25, .5, 1, 2, 4, 6, 10},
+	}, []string{"protocol"})
+)
@@ -1,4 +1,4 @@
-package main
+package banner
 
 import (
 	"fmt"
@@ -6,17 +6,18 @@ import (
 	"time"
 
 	"github.com/adedayo/sshscan"
+	"github.com/exploravis/model"
 	"github.com/zmap/zgrab2"
 )
 
-func scanSSH(t *zgrab2.ScanTarget) *ServiceScanResult {
+func scanSSH(t *zgrab2.ScanTarget) *model.ServiceScanResult {
 	portStr := strconv.Itoa(int(t.Port))
 	ipStr := t.IP.String()
 
 	ex := sshscan.Inspect(ipStr, portStr)
 
 	if ex.Fail {
-		return &ServiceScanResult{
+		return &model.ServiceScanResult{
 			IP:       ipStr,
 			Port:     int(t.Port),
 			Protocol: "SSH",
@@ -24,18 +25,18 @@ func scanSSH(t *zgrab2.ScanTarget) *ServiceScanResult {
 		}
 	}
 
-	sshInfo := map[string]any{
-		"version":               ex.ProtocolVersion,
-		"kex_algorithms":        ex.KEXAlgorithms,
-		"server_host_key_algos": ex.ServerHostKeyAlgos,
-		"enc_algos_c2s":         ex.EncAlgosC2S,
-		"enc_algos_s2c":         ex.EncAlgosS2C,
-		"mac_algos_c2s":         ex.MACAlgosC2S,
-		"mac_algos_s2c":         ex.MACAlgosS2C,
-		"compression_c2s":       ex.CompAlgosC2S,
-		"compression_s2c":       ex.CompAlgosS2C,
-		"languages_c2s":         ex.LanguagesC2S,
-		"languages_s2c":         ex.LanguagesS2C,
+	sshInfo := &model.SSHInfo{
+		Version:            ex.ProtocolVersion,
+		KEXAlgorithms:      ex.KEXAlgorithms,
+		ServerHostKeyAlgos: ex.ServerHostKeyAlgos,
+		EncAlgosC2S:        ex.EncAlgosC2S,
+		EncAlgosS2C:        ex.EncAlgosS2C,
+		MACAlgosC2S:        ex.MACAlgosC2S,
+		MACAlgosS2C:        ex.MACAlgosS2C,
+		CompressionC2S:     ex.CompAlgosC2S,
+		CompressionS2C:     ex.CompAlgosS2C,
+		LanguagesC2S:       ex.LanguagesC2S,
+		LanguagesS2C:       ex.LanguagesS2C,
 	}
 
 	// Unified Shodan‑style banner text
@@ -50,7 +51,7 @@ func scanSSH(t *zgrab2.ScanTarget) *ServiceScanResult {
 		ex.MACAlgosS2C,
 	)
 
-	return &ServiceScanResult{
+	return &model.ServiceScanResult{
 		IP:        ipStr,
 		Port:      int(t.Port),
 		Protocol:  "SSH",
@@ -1,16 +1,17 @@
-package main
+package banner
 
 import (
 	"fmt"
 	"net"
 	"time"
 
+	"github.com/exploravis/model"
 	"github.com/zmap/zgrab2"
 )
 
 const maxReadBytes = 4096
 
-func scanRawTCP(t *zgrab2.ScanTarget) *ServiceScanResult {
+func scanRawTCP(t *zgrab2.ScanTarget) *model.ServiceScanResult {
 	const (
 		readTimeout  = 2 * time.Second
 		maxReadBytes = 4096
@@ -22,7 +23,7 @@ func scanRawTCP(t *zgrab2.ScanTarget) *ServiceScanResult {
 
 	conn, err := net.DialTimeout("tcp", addr, 3*time.Second)
 	if err != nil {
-		return &ServiceScanResult{
+		return &model.ServiceScanResult{
 			IP:       ip,
 			Port:     int(t.Port),
 			Protocol: "TCP",
@@ -38,7 +39,7 @@ func scanRawTCP(t *zgrab2.ScanTarget) *ServiceScanResult {
 	n, _ := conn.Read(buf)
 
 	if n <= 0 {
-		return &ServiceScanResult{
+		return &model.ServiceScanResult{
 			IP:        ip,
 			Port:      int(t.Port),
 			Protocol:  "TCP",
@@ -59,7 +60,7 @@ func scanRawTCP(t *zgrab2.ScanTarget) *ServiceScanResult {
 		banner = banner[:maxStore]
 	}
 
-	return &ServiceScanResult{
+	return &model.ServiceScanResult{
 		IP:        ip,
 		Port:      int(t.Port),
 		Protocol:  "TCP",
@@ -0,0 +1,8 @@
+package banner
+
+// ServiceScanRequest is a single ip:port handed to a grab worker.
+type ServiceScanRequest struct {
+	ScanID string
+	IP     string
+	Port   string
+}
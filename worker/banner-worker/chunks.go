@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/exploravis/model"
+)
+
+// chunkTracker publishes the banner's ChunkComplete for a chunk once the
+// scanner's marker for it has been polled and every port queued before the
+// marker has been grabbed. The chunk's hosts and its marker share a key, so
+// they arrive on one partition in order: by the time the marker is polled,
+// each of its hosts has been queued here or was committed by a previous
+// owner of the partition, whose grabs the counts then leave out.
+type chunkTracker struct {
+	send func(ctx context.Context, ev *model.ChunkComplete, done func(error))
+
+	mu     sync.Mutex
+	chunks map[string]*chunkState
+}
+
+type chunkState struct {
+	ev      model.ChunkComplete
+	started time.Time
+	pending int // ports queued and not yet done
+
+	// Set once the marker is polled.
+	marker     bool
+	markerCtx  context.Context
+	markerDone func(error)
+}
+
+func newChunkTracker(send func(ctx context.Context, ev *model.ChunkComplete, done func(error))) *chunkTracker {
+	return &chunkTracker{send: send, chunks: map[string]*chunkState{}}
+}
+
+func (t *chunkTracker) state(scanID, chunk string) *chunkState {
+	key := model.ChunkKey(scanID, chunk)
+	st := t.chunks[key]
+	if st == nil {
+		st = &chunkState{
+			ev:      model.ChunkComplete{ScanID: scanID, Chunk: chunk, Stage: "banner"},
+			started: time.Now(),
+		}
+		t.chunks[key] = st
+	}
+	return st
+}
+
+// Queued counts a host whose ports are about to be queued. Hosts from older
+// scanners, or replayed from the DLQ, have no chunk and are not tracked.
+func (t *chunkTracker) Queued(msg model.HostPorts, ports int) {
+	if msg.Chunk == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.state(msg.ScanID, msg.Chunk)
+	st.ev.HostsUp++
+	st.pending += ports
+}
+
+// Done counts one finished grab.
+func (t *chunkTracker) Done(scanID, chunk string, failed bool) {
+	if chunk == "" {
+		return
+	}
+	t.mu.Lock()
+	st := t.chunks[model.ChunkKey(scanID, chunk)]
+	if st == nil {
+		t.mu.Unlock()
+		return
+	}
+	st.pending--
+	st.ev.Ports++
+	if failed {
+		st.ev.Errors++
+	}
+	complete := t.complete(st)
+	t.mu.Unlock()
+	t.publish(complete)
+}
+
+// Marker records the scanner's marker for a chunk. done is called once the
+// banner's ChunkComplete is delivered, to complete the marker's record.
+func (t *chunkTracker) Marker(ctx context.Context, scanner model.ChunkComplete, done func(error)) {
+	t.mu.Lock()
+	st := t.state(scanner.ScanID, scanner.Chunk)
+	st.marker, st.markerCtx, st.markerDone = true, ctx, done
+	complete := t.complete(st)
+	t.mu.Unlock()
+	t.publish(complete)
+}
+
+// complete removes st once its marker is in and no ports are pending, and
+// returns it for publishing; otherwise nil. Called with t.mu held.
+func (t *chunkTracker) complete(st *chunkState) *chunkState {
+	if !st.marker || st.pending > 0 {
+		return nil
+	}
+	delete(t.chunks, model.ChunkKey(st.ev.ScanID, st.ev.Chunk))
+	return st
+}
+
+func (t *chunkTracker) publish(st *chunkState) {
+	if st == nil {
+		return
+	}
+	ev := st.ev
+	ev.DurationMS = time.Since(st.started).Milliseconds()
+	ev.Timestamp = time.Now().Unix()
+	log.Printf("[INFO] Chunk %s of ScanID %s done: %d hosts, %d ports, %d errors", ev.Chunk, ev.ScanID, ev.HostsUp, ev.Ports, ev.Errors)
+	t.send(st.markerCtx, &ev, st.markerDone)
+}
+
+// Snapshot copies the counts, so an aborted transaction's hosts, which are
+// polled again, can be taken back out with Restore. Taken between batches,
+// when no marker is waiting on grabs.
+func (t *chunkTracker) Snapshot() map[string]chunkState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	snap := make(map[string]chunkState, len(t.chunks))
+	for key, st := range t.chunks {
+		snap[key] = *st
+	}
+	return snap
+}
+
+func (t *chunkTracker) Restore(snap map[string]chunkState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.chunks = make(map[string]*chunkState, len(snap))
+	for key, st := range snap {
+		t.chunks[key] = &st
+	}
+}
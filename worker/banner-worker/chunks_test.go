@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/exploravis/model"
+)
+
+type sentEvent struct {
+	ev   model.ChunkComplete
+	done func(error)
+}
+
+func newTestTracker() (*chunkTracker, *[]sentEvent) {
+	var sent []sentEvent
+	t := newChunkTracker(func(_ context.Context, ev *model.ChunkComplete, done func(error)) {
+		sent = append(sent, sentEvent{*ev, done})
+	})
+	return t, &sent
+}
+
+func hosts(chunk string) model.HostPorts {
+	return model.HostPorts{ScanID: "s1", Chunk: chunk, Host: "10.0.0.1"}
+}
+
+func TestChunkTracker(t *testing.T) {
+	marker := model.ChunkComplete{ScanID: "s1", Chunk: "10.0.0.0/30", Stage: "scanner"}
+	tests := []struct {
+		name string
+		// steps: "q<n>" queues a host with n ports, "ok"/"fail" finishes a
+		// grab, "m" polls the marker.
+		steps      []string
+		wantSentAt int // index of the step that publishes, -1 for none
+		want       model.ChunkComplete
+	}{
+		{
+			name:       "marker after every grab",
+			steps:      []string{"q2", "q1", "ok", "fail", "ok", "m"},
+			wantSentAt: 5,
+			want:       model.ChunkComplete{HostsUp: 2, Ports: 3, Errors: 1},
+		},
+		{
+			name:       "marker waits for grabs in flight",
+			steps:      []string{"q2", "ok", "m", "ok"},
+			wantSentAt: 3,
+			want:       model.ChunkComplete{HostsUp: 1, Ports: 2},
+		},
+		{
+			name:       "chunk with no hosts up",
+			steps:      []string{"m"},
+			wantSentAt: 0,
+			want:       model.ChunkComplete{},
+		},
+		{
+			name:       "no marker, no event",
+			steps:      []string{"q1", "ok"},
+			wantSentAt: -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, sent := newTestTracker()
+			sentAt := -1
+			for i, step := range tt.steps {
+				switch {
+				case step == "m":
+					tr.Marker(context.Background(), marker, func(error) {})
+				case step == "ok" || step == "fail":
+					tr.Done("s1", marker.Chunk, step == "fail")
+				default:
+					tr.Queued(hosts(marker.Chunk), int(step[1]-'0'))
+				}
+				if len(*sent) > 0 && sentAt < 0 {
+					sentAt = i
+				}
+			}
+			if sentAt != tt.wantSentAt {
+				t.Fatalf("published after step %d, want %d", sentAt, tt.wantSentAt)
+			}
+			if len(*sent) > 1 {
+				t.Fatalf("published %d events, want one", len(*sent))
+			}
+			if sentAt < 0 {
+				return
+			}
+			got := (*sent)[0].ev
+			if got.ScanID != "s1" || got.Chunk != marker.Chunk || got.Stage != "banner" ||
+				got.HostsUp != tt.want.HostsUp || got.Ports != tt.want.Ports || got.Errors != tt.want.Errors {
+				t.Errorf("published %+v, want counts %+v", got, tt.want)
+			}
+			if len(tr.Snapshot()) != 0 {
+				t.Error("completed chunk still tracked")
+			}
+		})
+	}
+}
+
+func TestChunkTrackerMarkerDone(t *testing.T) {
+	tr, sent := newTestTracker()
+	var acked error = errors.New("not called")
+	tr.Marker(context.Background(), model.ChunkComplete{ScanID: "s1", Chunk: "c"}, func(err error) { acked = err })
+	(*sent)[0].done(nil)
+	if acked != nil {
+		t.Errorf("marker done got %v, want the delivery result", acked)
+	}
+}
+
+func TestChunkTrackerUntracked(t *testing.T) {
+	tr, sent := newTestTracker()
+	tr.Queued(model.HostPorts{ScanID: "s1"}, 3) // from an older scanner: no chunk
+	tr.Done("s1", "", false)
+	tr.Done("s1", "unknown", false) // committed by a previous owner
+	if len(tr.Snapshot()) != 0 || len(*sent) != 0 {
+		t.Errorf("tracked %v, sent %v", tr.Snapshot(), *sent)
+	}
+}
+
+func TestChunkTrackerSeparateChunks(t *testing.T) {
+	tr, sent := newTestTracker()
+	tr.Queued(hosts("a"), 1)
+	tr.Queued(model.HostPorts{ScanID: "s2", Chunk: "a"}, 1) // same chunk, other scan
+	tr.Marker(context.Background(), model.ChunkComplete{ScanID: "s1", Chunk: "a"}, func(error) {})
+	tr.Done("s2", "a", false)
+	if len(*sent) != 0 {
+		t.Fatal("a grab of another scan completed the chunk")
+	}
+	tr.Done("s1", "a", false)
+	if len(*sent) != 1 || (*sent)[0].ev.ScanID != "s1" {
+		t.Errorf("sent %+v", *sent)
+	}
+}
+
+func TestChunkTrackerRestore(t *testing.T) {
+	tr, sent := newTestTracker()
+	tr.Queued(hosts("a"), 1)
+	snap := tr.Snapshot()
+
+	// A transaction queues more and aborts; its hosts are polled again.
+	tr.Queued(hosts("a"), 2)
+	tr.Queued(hosts("b"), 1)
+	tr.Restore(snap)
+	tr.Queued(hosts("a"), 2)
+
+	tr.Marker(context.Background(), model.ChunkComplete{ScanID: "s1", Chunk: "a"}, func(error) {})
+	for range 3 {
+		tr.Done("s1", "a", false)
+	}
+	if len(*sent) != 1 {
+		t.Fatalf("sent %d events, want 1", len(*sent))
+	}
+	if ev := (*sent)[0].ev; ev.HostsUp != 2 || ev.Ports != 3 {
+		t.Errorf("counts %+v, want the aborted hosts counted once", ev)
+	}
+	if _, ok := tr.Snapshot()[model.ChunkKey("s1", "b")]; ok {
+		t.Error("restore kept a chunk queued by the aborted transaction")
+	}
+}
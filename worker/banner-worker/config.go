@@ -0,0 +1,27 @@
+package main
+
+import "github.com/exploravis/config"
+
+type Config struct {
+	Bus      config.Bus      `yaml:"bus"`
+	Kafka    config.Kafka    `yaml:"kafka"`
+	Consumer config.Consumer `yaml:"consumer"`
+	Producer config.Producer `yaml:"producer"`
+	Control  config.Control  `yaml:"control"`
+	Workers  config.Workers  `yaml:"workers"`
+	Runtime  config.Runtime  `yaml:"runtime"`
+	Tracing  config.Tracing  `yaml:"tracing"`
+}
+
+func (c *Config) Validate() error {
+	return c.Bus.ValidateTxn(c.Kafka)
+}
+
+func loadConfig() Config {
+	cfg := Config{
+		Consumer: config.Consumer{Topic: "ip_scan_result", Group: "banner-scanner-group"},
+		Producer: config.Producer{Topic: "not_enriched_finished_scan"},
+	}
+	config.MustLoad("banner-worker", &cfg)
+	return cfg
+}
@@ -2,83 +2,316 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/exploravis/bus"
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/ack"
+	"github.com/exploravis/worker/backpressure"
+	"github.com/exploravis/worker/banner-worker/banner"
 	"github.com/exploravis/worker/banner-worker/producer"
-	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/exploravis/worker/dlq"
+	"github.com/exploravis/worker/health"
+	"github.com/exploravis/worker/metrics"
+	"github.com/exploravis/worker/shutdown"
+	"github.com/exploravis/worker/tracing"
 )
 
+// grabJob is one port of a consumed host record; the record is acked
+// once every port's result is delivered or dead-lettered.
+type grabJob struct {
+	req banner.ServiceScanRequest
+	// chunk is the host's HostPorts.Chunk, for chunkTracker.
+	chunk string
+	ack   *ack.Ack
+	// ctx carries the record's trace; it is never canceled.
+	ctx context.Context
+}
+
+// hostJob is a consumed host record, split into grabJobs by the dispatcher.
+// Its ports are already counted in ack and chunks.
+type hostJob struct {
+	req   model.HostPorts
+	ports []string
+	ack   *ack.Ack
+	ctx   context.Context
+}
+
 func main() {
-	workerCount := 8
-	jobQueue := make(chan ServiceScanRequest, 2000)
+	cfg := loadConfig()
+	workerCount := cfg.Workers.Count
+	// Hosts fan out to any number of ports, so the fetch loop queues hosts,
+	// which its polls can be sized to, and a dispatcher waits on the grab
+	// workers instead of it.
+	hostQueue := make(chan hostJob, cfg.Workers.QueueSize)
+	jobQueue := make(chan grabJob, workerCount)
+	flow := backpressure.New(func() int { return len(hostQueue) }, cap(hostQueue))
+	metrics.Serve(cfg.Runtime.MetricsAddr, health.Routes(cfg.Runtime.StallTimeout))
+	flushTraces := tracing.Init("banner-worker", cfg.Tracing)
+
+	ctx, stop := shutdown.OnSignal()
+	defer stop()
+	go flow.Run(ctx)
+
+	// Closed if the drain deadline passes, to skip grabs still queued.
+	abort := make(chan struct{})
+
+	log.Printf("[INFO] Connecting to the %s bus", cfg.Bus.Kind)
+	b, err := bus.New(cfg.Bus, cfg.Kafka)
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to connect to the bus: %v", err)
+	}
+	defer b.Close()
+
+	// With exactly-once, results, chunk events, dead letters and consumed
+	// offsets of a batch all go through one transaction, ended once the
+	// batch's grabs are done.
+	var (
+		sub bus.Subscription
+		txn *bus.Txn
+		out bus.Publisher = b
+	)
+	if cfg.Kafka.Txn.ExactlyOnce {
+		txn, err = b.(*bus.Kafka).Transact(cfg.Kafka.Txn.ID("banner"), cfg.Consumer.Group, cfg.Consumer.Topic)
+		sub, out = txn, txn
+	} else {
+		sub, err = b.Subscribe(cfg.Consumer.Group, cfg.Consumer.Topic)
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to subscribe: %v", err)
+	}
+	health.Register("bus", b.Ping)
+	health.Register("consumer_group", sub.Joined)
+	drain, cancelDrain := shutdown.Drain(ctx, cfg.Runtime.ShutdownTimeout)
+	defer cancelDrain()
+
+	deadLetters := dlq.NewWriter(out, "banner")
+	chunks := newChunkTracker(producer.ProduceChunkComplete)
 
 	log.Println("[INFO] Starting", workerCount, "worker goroutines...")
+	var wg sync.WaitGroup
 	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
 		go func(id int) {
+			defer wg.Done()
 			log.Printf("[WORKER %d] Started", id)
 			for job := range jobQueue {
-				log.Printf("[WORKER %d] Processing job: %s:%s (ScanID: %s)", id, job.IP, job.Port, job.ScanID)
-				grabBanner(job)
+				req, a := job.req, job.ack
+				select {
+				case <-abort:
+					// Left unacked for redelivery.
+					continue
+				default:
+				}
+				log.Printf("[WORKER %d] Processing job: %s:%s (ScanID: %s)", id, req.IP, req.Port, req.ScanID)
+				result, err := banner.Grab(job.ctx, req)
+				health.Progress()
+				if err != nil {
+					log.Printf("[ERROR] %s:%s (ScanID: %s): %v", req.IP, req.Port, req.ScanID, err)
+					chunks.Done(req.ScanID, job.chunk, true)
+					deadLetterJob(deadLetters, cfg.Consumer.Topic, req, dlq.ReasonProcess, err, func() { a.Done(nil) })
+					continue
+				}
+				producer.ProduceResult(job.ctx, &result, func(err error) {
+					chunks.Done(req.ScanID, job.chunk, err != nil)
+					if err != nil {
+						deadLetterJob(deadLetters, cfg.Consumer.Topic, req, dlq.ReasonFor(err), err, func() { a.Done(nil) })
+						return
+					}
+					a.Done(nil)
+				})
 			}
 			log.Printf("[WORKER %d] Exiting", id)
 		}(i)
 	}
 
-	seeds := []string{"redpanda-0.redpanda.kafka.svc.cluster.local:9093"}
-	log.Println("[INFO] Initializing Kafka producer with seeds:", seeds)
-	producer.InitProducer(seeds)
-
-	cl, err := kgo.NewClient(
-		kgo.SeedBrokers(seeds...),
-		kgo.DialTimeout(5*time.Second),
-		kgo.ProduceRequestTimeout(5*time.Second),
-		kgo.ConsumeTopics("ip_scan_result"),
-		kgo.ConsumerGroup("banner-scanner-group"),
-		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
-	)
-	if err != nil {
-		log.Fatalf("[ERROR] Unable to create Kafka client: %v", err)
-	}
-	defer cl.Close()
-	log.Println("[INFO] Kafka consumer started on topic 'ip_scan_result'")
+	dispatched := make(chan struct{})
+	go func() {
+		defer close(dispatched)
+		// The dispatcher is the only sender.
+		defer close(jobQueue)
+		for host := range hostQueue {
+			for _, portStr := range host.ports {
+				if ctx.Err() != nil {
+					// Partly queued: the record never completes, so it
+					// is redelivered whole after restart.
+					break
+				}
+				job := grabJob{
+					req: banner.ServiceScanRequest{
+						ScanID: host.req.ScanID,
+						IP:     host.req.Host,
+						Port:   portStr,
+					},
+					chunk: host.req.Chunk,
+					ack:   host.ack,
+					ctx:   host.ctx,
+				}
+				select {
+				case jobQueue <- job:
+				case <-ctx.Done():
+				}
+			}
+		}
+	}()
 
-	ctx := context.Background()
+	producer.InitProducer(out, cfg.Producer, cfg.Control)
 
-	for {
-		fetches := cl.PollFetches(ctx)
+	tracker := ack.NewTracker(nil)
+	tracker.Bind(sub)
+	flow.Bind(sub)
+	log.Printf("[INFO] Consumer started on topic '%s' (exactly-once: %v)", cfg.Consumer.Topic, txn != nil)
 
-		if errs := fetches.Errors(); len(errs) > 0 {
-			for _, e := range errs {
-				log.Printf("[ERROR] Kafka fetch error: %v", e)
-			}
+	for ctx.Err() == nil {
+		room := flow.Room(ctx)
+		if room == 0 {
+			break
+		}
+		health.Idle()
+		records, err := sub.Poll(ctx, room)
+		health.Beat()
+		if errors.Is(err, bus.ErrClosed) || ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("[ERROR] Fetch error: %v", err)
 			time.Sleep(1 * time.Second)
+		}
+		if len(records) == 0 {
 			continue
 		}
+		metrics.Consumed(cfg.Consumer.Topic, len(records))
+		log.Printf("[INFO] Processing %d records", len(records))
+		var snap map[string]chunkState
+		if txn != nil {
+			snap = chunks.Snapshot()
+		}
+
+		for _, record := range records {
+			log.Printf("[INFO] Consumed message %s/%d: %s", record.Topic, record.Partition, string(record.Value))
 
-		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
-			log.Printf("[INFO] Processing partition %s/%d with %d records", p.Topic, p.Partition, len(p.Records))
-			for _, record := range p.Records {
-				log.Printf("[INFO] Consumed message %s/%d: %s", record.Topic, record.Partition, string(record.Value))
+			health.Beat()
+			a := tracker.Track(record)
+			spanCtx, span := tracing.StartProcess(record)
 
-				var req PortsScanRequest
-				if err := json.Unmarshal(record.Value, &req); err != nil {
-					log.Printf("[WARN] Bad message: %v", err)
+			kind := dlq.HeaderValue(record, model.HeaderMessage)
+			if kind == model.MessageChunkComplete {
+				var marker model.ChunkComplete
+				if err := model.Unmarshal(record.Value, &marker); err != nil {
+					log.Printf("[WARN] Bad chunk marker: %v", err)
+					deadLetters.Send(record, dlq.ReasonDecode, err, func() { a.Done(nil) })
+					tracing.End(span, err)
 					continue
 				}
-
-				ports := strings.Split(req.Ports, ",")
-				log.Printf("[INFO] Queueing %d ports for IP %s (ScanID: %s)", len(ports), req.IP, req.ScanID)
-				for _, portStr := range ports {
-					jobQueue <- ServiceScanRequest{
-						ScanID: req.ScanID,
-						IP:     req.IP,
-						Port:   portStr,
+				// Acked once the banner's completion is delivered.
+				chunks.Marker(spanCtx, marker, func(err error) {
+					if err != nil {
+						deadLetters.Send(record, dlq.ReasonFor(err), err, func() { a.Done(nil) })
+						return
 					}
-				}
+					a.Done(nil)
+				})
+				span.End()
+				continue
+			}
+			if kind != "" {
+				// From a newer scanner; decoding it as HostPorts would
+				// queue a bogus grab.
+				log.Printf("[WARN] Skipping unknown %s message %q", model.HeaderMessage, kind)
+				a.Done(nil)
+				span.End()
+				continue
+			}
+
+			var req model.HostPorts
+			err := model.Unmarshal(record.Value, &req)
+			if err == nil && (req.Host == "" || req.Ports == "") {
+				err = errors.New("host ports without a host or ports")
 			}
-		})
+			if err != nil {
+				log.Printf("[WARN] Bad message: %v", err)
+				deadLetters.Send(record, dlq.ReasonDecode, err, func() { a.Done(nil) })
+				tracing.End(span, err)
+				continue
+			}
+
+			ports := strings.Split(req.Ports, ",")
+			log.Printf("[INFO] Queueing %d ports for IP %s (ScanID: %s)", len(ports), req.Host, req.ScanID)
+			// One reference per port, released by the grab workers;
+			// the record's own reference is dropped once the host is
+			// queued. Counted here rather than by the dispatcher, so a
+			// chunk marker polled next already sees them.
+			a.Add(len(ports))
+			chunks.Queued(req, len(ports))
+			// Never waits: the poll was sized to the queue's room, and
+			// only this loop sends.
+			hostQueue <- hostJob{req: req, ports: ports, ack: a, ctx: spanCtx}
+			a.Done(nil)
+			// The grabs are children of this span and outlive it.
+			span.End()
+			flow.Admit(record.Topic, record.Partition)
+		}
+
+		if txn != nil {
+			// Commits once every grab of the batch is delivered or
+			// dead-lettered; an aborted batch is polled again. On
+			// shutdown it aborts, as the dispatcher skips the grabs it
+			// has not queued yet. The grabs report Progress while
+			// this waits.
+			committed, err := txn.End(drain, ctx.Err() == nil)
+			if err != nil {
+				log.Fatalf("[ERROR] Ending transaction failed: %v", err)
+			}
+			if !committed {
+				chunks.Restore(snap)
+			}
+			metrics.TxnEnded(committed)
+		}
+	}
+
+	log.Printf("[INFO] Shutdown signal received, draining %d queued grabs (deadline %s)", len(jobQueue), cfg.Runtime.ShutdownTimeout)
+	workCtx, finalCtx, cancel := shutdown.Deadlines(cfg.Runtime.ShutdownTimeout)
+	defer cancel()
+
+	// The poll loop was the only sender. The dispatcher skips the hosts
+	// left, as ctx is done, and then closes jobQueue.
+	close(hostQueue)
+	<-dispatched
+	if !shutdown.Wait(workCtx, &wg) {
+		log.Println("[WARN] Drain deadline reached, skipping queued grabs")
+		close(abort)
+		// Grabs in flight still publish and ack; flushing or closing
+		// under them would lose both.
+		if !shutdown.Wait(finalCtx, &wg) {
+			log.Println("[WARN] Grabs still running at the final deadline")
+		}
+	}
+
+	producer.FlushProducer(finalCtx)
+	flushTraces(finalCtx)
+	sub.Close(finalCtx)
+	log.Println("[INFO] Shutdown complete")
+}
+
+// deadLetterJob parks a single failed port as a one-port host message, so a
+// replay only re-grabs what failed rather than the whole host. then is
+// called once it is settled.
+func deadLetterJob(w *dlq.Writer, topic string, job banner.ServiceScanRequest, reason string, cause error, then func()) {
+	msg := model.HostPorts{
+		ScanID:    job.ScanID,
+		Host:      job.IP,
+		Ports:     job.Port,
+		Timestamp: time.Now().Unix(),
+	}
+	value, err := model.Marshal(&msg, model.EncodingJSON)
+	if err != nil {
+		log.Printf("[WARN] Unable to dead-letter %s:%s: %v", job.IP, job.Port, err)
+		then()
+		return
 	}
+	w.SendValue(topic, []byte(job.ScanID), value, reason, cause, then)
 }
@@ -2,49 +2,116 @@ package producer
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"time"
 
+	"github.com/exploravis/bus"
+	"github.com/exploravis/config"
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/dlq"
+	"github.com/exploravis/worker/metrics"
+	"github.com/exploravis/worker/tracing"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-var producer *kgo.Client
+var (
+	producer     bus.Publisher
+	topic        string
+	controlTopic string
+	wireEncoding = model.EncodingJSON
+)
 
-func InitProducer(brokers []string) {
-	cl, err := kgo.NewClient(
-		kgo.SeedBrokers(brokers...),
-		kgo.DialTimeout(5*time.Second),
-		kgo.ProduceRequestTimeout(5*time.Second),
-		kgo.DefaultProduceTopic("not_enriched_finished_scan"),
-		kgo.RecordPartitioner(kgo.RoundRobinPartitioner()),
-	)
+// InitProducer publishes results and chunk events on b: the bus, or its
+// transaction when the stage runs exactly-once.
+func InitProducer(b bus.Publisher, out config.Producer, control config.Control) {
+	enc, err := model.ParseEncoding(out.Encoding)
 	if err != nil {
 		log.Fatalf("failed to create results producer: %v", err)
 	}
+	wireEncoding = enc
+	topic = out.Topic
+	controlTopic = control.Topic
 
-	producer = cl
+	producer = b
 	log.Println("Result producer initialized")
 }
 
-func CloseProducer() {
-	if producer != nil {
-		producer.Close()
+// FlushProducer waits for published results to be delivered (or ctx to
+// end), so their delivery callbacks run and offsets can commit. The bus
+// itself is closed by main.
+func FlushProducer(ctx context.Context) {
+	if producer == nil {
+		return
+	}
+	if err := producer.Flush(ctx); err != nil {
+		log.Printf("flush failed: %v", err)
 	}
 }
 
-func ProduceResult(value []byte) {
+// ProduceResult sends asynchronously and calls done exactly once, when the
+// broker has acked the record or delivery has failed. The trace context of
+// ctx travels with the record.
+func ProduceResult(ctx context.Context, result *model.ServiceScanResult, done func(error)) {
 	if producer == nil {
 		log.Printf("producer not initialized, dropping message")
+		done(fmt.Errorf("%w: producer not initialized", dlq.ErrProduce))
+		return
+	}
+
+	value, err := model.Marshal(result, wireEncoding)
+	if err != nil {
+		log.Printf("marshal error: %v", err)
+		done(err)
 		return
 	}
 
 	record := &kgo.Record{
+		Topic: topic,
 		Value: value,
 	}
+	span := tracing.StartProduce(ctx, record)
 
-	producer.Produce(context.Background(), record, func(_ *kgo.Record, err error) {
+	producer.Publish(context.Background(), record, func(r *kgo.Record, err error) {
+		metrics.Produced(r.Topic, err)
+		tracing.EndProduce(span, r, err)
 		if err != nil {
 			log.Printf("failed to deliver scan result: %v", err)
+			done(fmt.Errorf("%w: %v", dlq.ErrProduce, err))
+			return
+		}
+		done(nil)
+	})
+}
+
+// ProduceChunkComplete publishes the banner's completion of a chunk on the
+// control topic and calls done once, like ProduceResult.
+func ProduceChunkComplete(ctx context.Context, ev *model.ChunkComplete, done func(error)) {
+	if producer == nil {
+		done(fmt.Errorf("%w: producer not initialized", dlq.ErrProduce))
+		return
+	}
+
+	value, err := model.Marshal(ev, wireEncoding)
+	if err != nil {
+		done(err)
+		return
+	}
+
+	record := &kgo.Record{
+		Topic: controlTopic,
+		Key:   []byte(model.ChunkKey(ev.ScanID, ev.Chunk)),
+		Value: value,
+	}
+	span := tracing.StartProduce(ctx, record)
+
+	producer.Publish(context.Background(), record, func(r *kgo.Record, err error) {
+		metrics.Produced(r.Topic, err)
+		tracing.EndProduce(span, r, err)
+		if err != nil {
+			log.Printf("failed to deliver chunk completion: %v", err)
+			done(fmt.Errorf("%w: %v", dlq.ErrProduce, err))
+			return
 		}
+		done(nil)
 	})
 }
@@ -0,0 +1,201 @@
+// Command dlq-replay re-injects dead-lettered records into the topic they
+// originally came from.
+//
+// It reads a stage's DLQ from the beginning (or -from_offset) up to where it
+// ended at startup, so records dead-lettered again during a replay are not
+// picked up in a loop. On Kafka that is the last stable offset of each
+// partition, and only committed records are read: a dead letter from an
+// aborted transaction was retried and written again. It does not join a
+// consumer group; run it again with narrower filters to replay a different
+// selection.
+//
+// On NATS it reads the DLQ stream up to the last sequence stored at startup;
+// the sequence stands in for the offset and every record is on partition 0.
+//
+//	dlq-replay -stage elasticsearch -reason index -dry_run
+//	dlq-replay -stage enrich -error "geoip" -since 24h
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exploravis/bus"
+	"github.com/exploravis/config"
+	"github.com/exploravis/worker/dlq"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type filter struct {
+	reason    string
+	errSubstr string
+	since     time.Time
+	partition int
+}
+
+func (f filter) match(rec *kgo.Record) bool {
+	if f.partition >= 0 && rec.Partition != int32(f.partition) {
+		return false
+	}
+	if f.reason != "" && dlq.HeaderValue(rec, dlq.HeaderReason) != f.reason {
+		return false
+	}
+	if f.errSubstr != "" && !strings.Contains(dlq.HeaderValue(rec, dlq.HeaderError), f.errSubstr) {
+		return false
+	}
+	if !f.since.IsZero() {
+		at, err := time.Parse(time.RFC3339, dlq.HeaderValue(rec, dlq.HeaderFailedAt))
+		if err != nil || at.Before(f.since) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseSince(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// Config is the replay selection. Every field is also a flag named after
+// its yaml key, e.g. -from_offset.
+type Config struct {
+	Bus        config.Bus   `yaml:"bus"`
+	Kafka      config.Kafka `yaml:"kafka"`
+	Stage      string       `yaml:"stage" usage:"stage whose DLQ to replay (scanner, banner, enrich, elasticsearch)"`
+	Topic      string       `yaml:"topic" usage:"DLQ topic to read (default <stage>_dlq)"`
+	Target     string       `yaml:"target" usage:"topic to replay into (default: each record's source topic)"`
+	Reason     string       `yaml:"reason" usage:"only replay records with this reason (decode, process, produce, index)"`
+	Error      string       `yaml:"error" usage:"only replay records whose error contains this text"`
+	Since      string       `yaml:"since" usage:"only replay records dead-lettered after this time (RFC3339 or duration, e.g. 24h)"`
+	Partition  int          `yaml:"partition" default:"-1" usage:"only read this DLQ partition"`
+	FromOffset int64        `yaml:"from_offset" default:"-1" usage:"start reading at this offset on every partition (the stream sequence on NATS)"`
+	Limit      int          `yaml:"limit" usage:"stop after replaying this many records (0 = no limit)"`
+	DryRun     bool         `yaml:"dry_run" usage:"print what would be replayed without producing"`
+}
+
+func (c *Config) Validate() error {
+	if c.Topic == "" && c.Stage == "" {
+		return errors.New("stage or topic is required")
+	}
+	if _, err := parseSince(c.Since); err != nil {
+		return fmt.Errorf("invalid since %q: %w", c.Since, err)
+	}
+	return nil
+}
+
+func main() {
+	var cfg Config
+	config.MustLoad("dlq-replay", &cfg)
+
+	if cfg.Topic == "" {
+		cfg.Topic = dlq.Topic(cfg.Stage)
+	}
+	sinceT, _ := parseSince(cfg.Since)
+	f := filter{reason: cfg.Reason, errSubstr: cfg.Error, since: sinceT, partition: cfg.Partition}
+
+	ctx := context.Background()
+
+	var (
+		src source
+		pub bus.Publisher
+		err error
+	)
+	switch cfg.Bus.Kind {
+	case "nats":
+		var b *bus.NATS
+		b, err = bus.NewNATS(cfg.Bus.NATS)
+		if err == nil {
+			defer b.Close()
+			src, pub = natsSource{b: b, topic: cfg.Topic, from: cfg.FromOffset}, b
+		}
+	default:
+		var b *bus.Kafka
+		b, err = bus.NewKafka(cfg.Kafka)
+		if err == nil {
+			defer b.Close()
+			pub = b
+			src, err = newKafkaSource(ctx, cfg.Kafka, cfg.Topic, cfg.FromOffset, f.partition)
+		}
+	}
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to read %s: %v", cfg.Topic, err)
+	}
+	defer src.close()
+
+	log.Printf("[INFO] Replaying %s (dry-run: %v)", cfg.Topic, cfg.DryRun)
+	scanned, replayed, err := replay(ctx, src, pub, f, cfg)
+	if err != nil {
+		log.Fatalf("[ERROR] %v", err)
+	}
+	log.Printf("[INFO] Scanned %d records, replayed %d", scanned, replayed)
+}
+
+// replay publishes the records of src that f selects back to where they
+// came from, or to cfg.Target, and stops at the first failed publish.
+func replay(ctx context.Context, src source, pub bus.Publisher, f filter, cfg Config) (scanned, replayed int, err error) {
+	readErr := src.each(ctx, func(rec *kgo.Record) bool {
+		scanned++
+		if !f.match(rec) {
+			return true
+		}
+
+		dest := cfg.Target
+		if dest == "" {
+			dest = dlq.HeaderValue(rec, dlq.HeaderSourceTopic)
+		}
+		if dest == "" {
+			log.Printf("[WARN] %d/%d has no source topic header, use -target", rec.Partition, rec.Offset)
+			return true
+		}
+
+		if cfg.DryRun {
+			fmt.Printf("%d/%d -> %s [%s] %s\n", rec.Partition, rec.Offset, dest,
+				dlq.HeaderValue(rec, dlq.HeaderReason), dlq.HeaderValue(rec, dlq.HeaderError))
+		} else {
+			done := make(chan error, 1)
+			pub.Publish(ctx, replayRecord(rec, dest), func(_ *kgo.Record, err error) { done <- err })
+			if perr := <-done; perr != nil {
+				err = fmt.Errorf("replay of %d/%d failed, stopping: %w", rec.Partition, rec.Offset, perr)
+				return false
+			}
+		}
+
+		replayed++
+		return cfg.Limit <= 0 || replayed < cfg.Limit
+	})
+	if err == nil && readErr != nil {
+		err = fmt.Errorf("reading %s: %w", cfg.Topic, readErr)
+	}
+	return scanned, replayed, err
+}
+
+// replayRecord strips the DLQ headers and notes where the record came from,
+// so a record that fails again is recognisable as a replay.
+func replayRecord(rec *kgo.Record, dest string) *kgo.Record {
+	out := &kgo.Record{
+		Topic: dest,
+		Key:   rec.Key,
+		Value: rec.Value,
+	}
+	for _, h := range rec.Headers {
+		if !dlq.IsHeader(h.Key) {
+			out.Headers = append(out.Headers, h)
+		}
+	}
+	out.Headers = append(out.Headers, kgo.RecordHeader{
+		Key:   dlq.HeaderReplayedFrom,
+		Value: []byte(rec.Topic + "/" + strconv.Itoa(int(rec.Partition)) + "/" + strconv.FormatInt(rec.Offset, 10)),
+	})
+	return out
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exploravis/bus"
+	"github.com/exploravis/worker/dlq"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+type fetched struct {
+	offset  int64
+	control bool
+}
+
+func TestPositionsAdvance(t *testing.T) {
+	tests := []struct {
+		name    string
+		end     int64
+		fetched []fetched
+		replay  string
+		done    bool
+	}{
+		{
+			name:    "ends in a record",
+			end:     3,
+			fetched: []fetched{{0, false}, {1, false}, {2, false}},
+			replay:  "[0 1 2]",
+			done:    true,
+		},
+		{
+			// A transactional producer's commit marker is the last offset.
+			name:    "ends in a control marker",
+			end:     3,
+			fetched: []fetched{{0, false}, {1, false}, {2, true}},
+			replay:  "[0 1]",
+			done:    true,
+		},
+		{
+			// An aborted batch is skipped by a read-committed fetch; only
+			// its abort marker comes back.
+			name:    "ends in an aborted transaction",
+			end:     6,
+			fetched: []fetched{{0, false}, {1, true}, {5, true}},
+			replay:  "[0]",
+			done:    true,
+		},
+		{
+			name:    "only markers",
+			end:     2,
+			fetched: []fetched{{1, true}},
+			replay:  "[]",
+			done:    true,
+		},
+		{
+			name:    "records past the end are not replayed",
+			end:     2,
+			fetched: []fetched{{1, false}, {2, false}, {3, false}},
+			replay:  "[1]",
+			done:    true,
+		},
+		{
+			name:    "still short of the end",
+			end:     10,
+			fetched: []fetched{{0, false}, {1, true}},
+			replay:  "[0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pos := positions{0: tt.end}
+			replay := []int64{}
+			for _, f := range tt.fetched {
+				if pos.advance(0, f.offset, f.control) {
+					replay = append(replay, f.offset)
+				}
+			}
+			if got := fmt.Sprint(replay); got != tt.replay {
+				t.Errorf("replayed %s, want %s", got, tt.replay)
+			}
+			if _, open := pos[0]; open == tt.done {
+				t.Errorf("partition done = %v, want %v", !open, tt.done)
+			}
+		})
+	}
+}
+
+func TestPositionsOtherPartition(t *testing.T) {
+	pos := positions{0: 5}
+	if pos.advance(1, 0, false) {
+		t.Error("replayed a record of a partition not being read")
+	}
+}
+
+// sliceSource is a DLQ already read into memory.
+type sliceSource []*kgo.Record
+
+func (s sliceSource) each(_ context.Context, fn func(*kgo.Record) bool) error {
+	for _, rec := range s {
+		if !fn(rec) {
+			break
+		}
+	}
+	return nil
+}
+
+func (sliceSource) close() {}
+
+func deadLetter(offset int64, source, reason string) *kgo.Record {
+	return &kgo.Record{Topic: "enrich_dlq", Offset: offset, Key: []byte("k"), Value: []byte(fmt.Sprint(offset)), Headers: []kgo.RecordHeader{
+		{Key: "traceparent", Value: []byte("00-trace")},
+		{Key: dlq.HeaderSourceTopic, Value: []byte(source)},
+		{Key: dlq.HeaderReason, Value: []byte(reason)},
+	}}
+}
+
+func TestReplay(t *testing.T) {
+	src := sliceSource{
+		deadLetter(0, "banner_results", "process"),
+		deadLetter(1, "banner_results", "decode"),
+		deadLetter(2, "", "process"),
+		deadLetter(3, "banner_results", "process"),
+		deadLetter(4, "banner_results", "process"),
+	}
+	tests := []struct {
+		name     string
+		cfg      Config
+		scanned  int
+		replayed string
+	}{
+		{"everything with a source", Config{}, 5, "[0 1 3 4]"},
+		{"by reason", Config{Reason: "process"}, 5, "[0 3 4]"},
+		{"limit stops reading", Config{Reason: "process", Limit: 2}, 4, "[0 3]"},
+		{"target overrides the source", Config{Target: "banner_results"}, 5, "[0 1 2 3 4]"},
+		{"dry run", Config{DryRun: true}, 5, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := bus.NewMemory()
+			sub, _ := m.Subscribe("check", "banner_results")
+			defer sub.Close(context.Background())
+
+			f := filter{reason: tt.cfg.Reason, partition: -1}
+			scanned, _, err := replay(context.Background(), src, m, f, tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if scanned != tt.scanned {
+				t.Errorf("scanned %d, want %d", scanned, tt.scanned)
+			}
+
+			got := []string{}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			recs, _ := sub.Poll(ctx, 0)
+			for _, rec := range recs {
+				got = append(got, string(rec.Value))
+				if dlq.HeaderValue(rec, dlq.HeaderReason) != "" || dlq.HeaderValue(rec, "traceparent") != "00-trace" {
+					t.Errorf("replayed headers %v, want the DLQ ones stripped and the rest kept", rec.Headers)
+				}
+				if dlq.HeaderValue(rec, dlq.HeaderReplayedFrom) == "" || string(rec.Key) != "k" {
+					t.Errorf("replayed %+v", rec)
+				}
+			}
+			if s := "[" + strings.Join(got, " ") + "]"; s != tt.replayed {
+				t.Errorf("replayed %s, want %s", s, tt.replayed)
+			}
+		})
+	}
+}
+
+// failingPublisher fails every publish.
+type failingPublisher struct{}
+
+func (failingPublisher) Publish(_ context.Context, rec *kgo.Record, done func(*kgo.Record, error)) {
+	done(rec, errors.New("broker down"))
+}
+
+func (failingPublisher) Flush(context.Context) error { return nil }
+
+func TestReplayStopsOnPublishError(t *testing.T) {
+	src := sliceSource{deadLetter(0, "t", "process"), deadLetter(1, "t", "process")}
+	scanned, replayed, err := replay(context.Background(), src, failingPublisher{}, filter{partition: -1}, Config{})
+	if err == nil || !strings.Contains(err.Error(), "broker down") {
+		t.Errorf("err = %v", err)
+	}
+	if scanned != 1 || replayed != 0 {
+		t.Errorf("scanned %d, replayed %d; want to stop at the first", scanned, replayed)
+	}
+}
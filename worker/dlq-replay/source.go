@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/exploravis/bus"
+	"github.com/exploravis/config"
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// source reads a DLQ from its start position up to where it ended when the
+// replay began.
+type source interface {
+	// each calls fn with every record in that range, in order within a
+	// partition, until fn returns false.
+	each(ctx context.Context, fn func(*kgo.Record) bool) error
+	close()
+}
+
+// natsSource reads the DLQ stream by sequence.
+type natsSource struct {
+	b     *bus.NATS
+	topic string
+	from  int64
+}
+
+func (s natsSource) each(ctx context.Context, fn func(*kgo.Record) bool) error {
+	return s.b.Read(ctx, s.topic, s.from, fn)
+}
+
+func (natsSource) close() {}
+
+// kafkaSource reads the DLQ partitions by offset, outside any group.
+type kafkaSource struct {
+	cl        *kgo.Client
+	remaining positions
+}
+
+// newKafkaSource lists where topic's partitions end and starts a client
+// reading them from from, or their start if from < 0. Only partition is
+// read if it is >= 0.
+func newKafkaSource(ctx context.Context, k config.Kafka, topic string, from int64, partition int) (*kafkaSource, error) {
+	opts, err := k.ClientOpts()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, kgo.DialTimeout(5*time.Second))
+
+	admin, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, err
+	}
+	// The last stable offsets: a read-committed fetch returns nothing past
+	// them until the open transactions finish.
+	ends, err := kadm.NewClient(admin).ListCommittedOffsets(ctx, topic)
+	admin.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only partitions with something to read below their current end.
+	start := map[int32]kgo.Offset{}
+	remaining := positions{}
+	ends.Each(func(o kadm.ListedOffset) {
+		if o.Err != nil || o.Offset <= 0 || o.Offset <= from {
+			return
+		}
+		if partition >= 0 && o.Partition != int32(partition) {
+			return
+		}
+		remaining[o.Partition] = o.Offset
+		if from >= 0 {
+			start[o.Partition] = kgo.NewOffset().At(from)
+		} else {
+			start[o.Partition] = kgo.NewOffset().AtStart()
+		}
+	})
+	if len(remaining) == 0 {
+		return &kafkaSource{}, nil
+	}
+
+	cl, err := kgo.NewClient(append(opts,
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{topic: start}),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		// Markers are the only sign a partition's position has passed its
+		// last transaction.
+		kgo.KeepControlRecords(),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	return &kafkaSource{cl: cl, remaining: remaining}, nil
+}
+
+func (s *kafkaSource) each(ctx context.Context, fn func(*kgo.Record) bool) error {
+	stop := false
+	for !stop && len(s.remaining) > 0 {
+		fetches := s.cl.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if errs := fetches.Errors(); len(errs) > 0 {
+			for _, e := range errs {
+				log.Printf("[ERROR] Kafka fetch error: %v", e)
+			}
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		fetches.EachRecord(func(rec *kgo.Record) {
+			if stop || !s.remaining.advance(rec.Partition, rec.Offset, rec.Attrs.IsControl()) {
+				return
+			}
+			stop = !fn(rec)
+		})
+	}
+	return nil
+}
+
+func (s *kafkaSource) close() {
+	if s.cl != nil {
+		s.cl.Close()
+	}
+}
+
+// positions tracks each partition's fetch position towards the offset the
+// replay stops at. Transaction markers take up offsets too, so a partition
+// can end in one rather than in a record.
+type positions map[int32]int64
+
+// advance moves partition past offset and reports whether the record there
+// is to be replayed: below the stop offset and not a control record. The
+// partition is dropped once its position reaches the stop offset.
+func (p positions) advance(partition int32, offset int64, control bool) bool {
+	end, ok := p[partition]
+	if !ok || offset >= end {
+		return false
+	}
+	if offset+1 >= end {
+		delete(p, partition)
+	}
+	return !control
+}
@@ -0,0 +1,155 @@
+// Package dlq sends records a stage could not process to that stage's
+// dead-letter topic, with the failure described in record headers, so they
+// can be inspected and re-injected with dlq-replay once the cause is fixed.
+package dlq
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/exploravis/bus"
+	"github.com/exploravis/worker/metrics"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// Header keys set on every dead-lettered record. The original record's own
+// headers are kept alongside them.
+const (
+	HeaderStage           = "dlq.stage"
+	HeaderReason          = "dlq.reason"
+	HeaderError           = "dlq.error"
+	HeaderSourceTopic     = "dlq.source.topic"
+	HeaderSourcePartition = "dlq.source.partition"
+	HeaderSourceOffset    = "dlq.source.offset"
+	HeaderFailedAt        = "dlq.failed_at"
+	HeaderReplayedFrom    = "dlq.replayed_from"
+)
+
+// Reasons, so replays can select one class of failure.
+const (
+	ReasonDecode  = "decode"
+	ReasonProcess = "process"
+	ReasonProduce = "produce"
+	ReasonIndex   = "index"
+)
+
+// ErrProduce wraps failures to deliver a stage's output, so they are
+// dead-lettered as ReasonProduce rather than ReasonProcess.
+var ErrProduce = errors.New("produce failed")
+
+// ReasonFor picks the reason for a failed unit of work.
+func ReasonFor(err error) string {
+	if errors.Is(err, ErrProduce) {
+		return ReasonProduce
+	}
+	return ReasonProcess
+}
+
+// Topic is the dead-letter topic of a stage, e.g. "banner_dlq".
+func Topic(stage string) string {
+	return stage + "_dlq"
+}
+
+// IsHeader reports whether key is one of the headers added by this package.
+func IsHeader(key string) bool {
+	return strings.HasPrefix(key, "dlq.")
+}
+
+type Writer struct {
+	b     bus.Publisher
+	stage string
+	topic string
+}
+
+// NewWriter dead-letters through b, which the stage also publishes its
+// results on. In a transaction, dead letters commit or abort with the
+// results.
+func NewWriter(b bus.Publisher, stage string) *Writer {
+	topic := Topic(stage)
+	log.Printf("[DLQ] Dead-letter producer initialized for %s", topic)
+	return &Writer{b: b, stage: stage, topic: topic}
+}
+
+// Send dead-letters a consumed record as-is. It does not wait: then, if not
+// nil, is called once the dead letter is acknowledged or has failed, and is
+// where the caller acks the source record.
+func (w *Writer) Send(src *kgo.Record, reason string, cause error, then func()) {
+	rec := &kgo.Record{
+		Topic: w.topic,
+		Key:   src.Key,
+		Value: src.Value,
+	}
+	for _, h := range src.Headers {
+		if !IsHeader(h.Key) {
+			rec.Headers = append(rec.Headers, h)
+		}
+	}
+	rec.Headers = append(rec.Headers,
+		kgo.RecordHeader{Key: HeaderSourceTopic, Value: []byte(src.Topic)},
+		kgo.RecordHeader{Key: HeaderSourcePartition, Value: []byte(strconv.Itoa(int(src.Partition)))},
+		kgo.RecordHeader{Key: HeaderSourceOffset, Value: []byte(strconv.FormatInt(src.Offset, 10))},
+	)
+	w.produce(rec, reason, cause, then)
+}
+
+// SendValue dead-letters a payload that no longer maps to a single consumed
+// record, such as a document rejected by a bulk flush. sourceTopic is where
+// a replay should send it. Like Send, it calls then once the dead letter is
+// settled.
+func (w *Writer) SendValue(sourceTopic string, key, value []byte, reason string, cause error, then func()) {
+	rec := &kgo.Record{
+		Topic: w.topic,
+		Key:   key,
+		Value: value,
+		Headers: []kgo.RecordHeader{
+			{Key: HeaderSourceTopic, Value: []byte(sourceTopic)},
+		},
+	}
+	w.produce(rec, reason, cause, then)
+}
+
+func (w *Writer) produce(rec *kgo.Record, reason string, cause error, then func()) {
+	msg := ""
+	if cause != nil {
+		msg = cause.Error()
+	}
+	rec.Headers = append(rec.Headers,
+		kgo.RecordHeader{Key: HeaderStage, Value: []byte(w.stage)},
+		kgo.RecordHeader{Key: HeaderReason, Value: []byte(reason)},
+		kgo.RecordHeader{Key: HeaderError, Value: []byte(msg)},
+		kgo.RecordHeader{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+
+	// Never waited on here: dead letters are sent from the callbacks of
+	// the stage's own produces, which the client runs one at a time, so
+	// waiting for this one's callback there would never return. The caller
+	// acks the source record in then, once the dead letter is durable.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	w.b.Publish(ctx, rec, func(_ *kgo.Record, err error) {
+		cancel()
+		metrics.Produced(w.topic, err)
+		if err != nil {
+			// Nowhere left to put it; the log line is the last trace.
+			log.Printf("[DLQ] failed to dead-letter record to %s (%s: %s): %v", w.topic, reason, msg, err)
+		} else {
+			metrics.DeadLettered.WithLabelValues(w.stage, reason).Inc()
+		}
+		if then != nil {
+			then()
+		}
+	})
+}
+
+// HeaderValue returns the value of the first header named key.
+func HeaderValue(rec *kgo.Record, key string) string {
+	for _, h := range rec.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package main
+
+import "github.com/exploravis/config"
+
+type Config struct {
+	Bus      config.Bus      `yaml:"bus"`
+	Kafka    config.Kafka    `yaml:"kafka"`
+	Consumer config.Consumer `yaml:"consumer"`
+	Elastic  config.Elastic  `yaml:"elastic"`
+	Index    IndexConfig     `yaml:"index"`
+	Workers  config.Workers  `yaml:"workers"`
+	Runtime  config.Runtime  `yaml:"runtime"`
+	Tracing  config.Tracing  `yaml:"tracing"`
+}
+
+type IndexConfig struct {
+	Mode string `yaml:"mode" env:"ES_INDEX_MODE" default:"history" usage:"what to index: history, latest or both"`
+}
+
+func (c *IndexConfig) Validate() error {
+	_, err := parseIndexMode(c.Mode)
+	return err
+}
+
+func loadConfig() Config {
+	cfg := Config{
+		Consumer: config.Consumer{Topic: "finished_scan", Group: "es-worker-group-1"},
+	}
+	config.MustLoad("elasticsearch-worker", &cfg)
+	return cfg
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/exploravis/model"
+)
+
+// Index modes, picked with index.mode (ES_INDEX_MODE):
+//
+//   - history (default): one document per scan of a service, in the rolling
+//     scans-write alias, keyed on scan_id+ip+port+protocol so redeliveries
+//     and retries overwrite instead of duplicating.
+//   - latest: one document per service in latestIndex, keyed on
+//     ip+port+protocol and replaced by every newer scan of it.
+//   - both: write both.
+//
+// History ids are only unique within a backing index: a redelivery that
+// lands after an ILM rollover is written again in the new index.
+const (
+	modeHistory = "history"
+	modeLatest  = "latest"
+	modeBoth    = "both"
+
+	historyAlias = "scans-write"
+	latestIndex  = "scans-latest"
+)
+
+type indexMode struct {
+	history bool
+	latest  bool
+}
+
+func parseIndexMode(v string) (indexMode, error) {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "", modeHistory:
+		return indexMode{history: true}, nil
+	case modeLatest:
+		return indexMode{latest: true}, nil
+	case modeBoth:
+		return indexMode{history: true, latest: true}, nil
+	default:
+		return indexMode{}, fmt.Errorf("unknown index mode %q (want history, latest or both)", v)
+	}
+}
+
+func hashID(parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func historyDocID(r model.ServiceScanResult) string {
+	return hashID(r.ScanID, r.IP, strconv.Itoa(r.Port), r.Protocol)
+}
+
+func latestDocID(r model.ServiceScanResult) string {
+	return hashID(r.IP, strconv.Itoa(r.Port), r.Protocol)
+}
@@ -4,118 +4,276 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
+	"sync"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/exploravis/bus"
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/ack"
+	"github.com/exploravis/worker/backpressure"
+	"github.com/exploravis/worker/dlq"
+	"github.com/exploravis/worker/health"
+	"github.com/exploravis/worker/metrics"
+	"github.com/exploravis/worker/shutdown"
+	"github.com/exploravis/worker/tracing"
 	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func main() {
-	workerCount := 8
-	jobQueue := make(chan ServiceScanResult, 2000)
+	cfg := loadConfig()
+	workerCount := cfg.Workers.Count
+	jobQueue := make(chan indexJob, cfg.Workers.QueueSize)
+	flow := backpressure.New(func() int { return len(jobQueue) }, cap(jobQueue))
+	metrics.Serve(cfg.Runtime.MetricsAddr, health.Routes(cfg.Runtime.StallTimeout))
+	flushTraces := tracing.Init("elasticsearch-worker", cfg.Tracing)
 
 	es, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: []string{
-			"http://elasticsearch-cluster-master.elasticsearch.svc:9200",
-		},
+		Addresses: []string{cfg.Elastic.URL},
 	})
 	if err != nil {
 		log.Fatalf("Error creating ES client: %v", err)
 	}
 	log.Println("Connected to Elasticsearch cluster")
 
+	b, err := bus.New(cfg.Bus, cfg.Kafka)
+	if err != nil {
+		log.Fatalf("unable to connect to the bus: %v", err)
+	}
+	defer b.Close()
+	deadLetters := dlq.NewWriter(b, "elasticsearch")
+
+	tracker := ack.NewTracker(func(rec *kgo.Record, err error, done func()) {
+		reason := dlq.ReasonIndex
+		if errors.Is(err, errUnindexable) {
+			reason = dlq.ReasonProcess
+		}
+		deadLetters.Send(rec, reason, err, done)
+	})
+
+	// Already validated by loadConfig.
+	mode, _ := parseIndexMode(cfg.Index.Mode)
+	log.Printf("Index mode: history=%v latest=%v", mode.history, mode.latest)
+
 	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
 		Client: es,
-		Index:  "scans-000001",
 	})
 	if err != nil {
 		log.Fatalf("Error creating bulk indexer: %v", err)
 	}
-	defer func() {
-		if err := bi.Close(context.Background()); err != nil {
-			log.Printf("Error closing bulk indexer: %v", err)
-		}
-	}()
+	registerBulkStats(bi)
 
+	var wg sync.WaitGroup
 	for i := range workerCount {
+		wg.Add(1)
 		go func(id int) {
+			defer wg.Done()
 			for job := range jobQueue {
 
-				log.Println("go routine invoked for", job.IP)
-				if err := indexToES(bi, job); err != nil {
+				log.Println("go routine invoked for", job.result.IP)
+				health.Progress()
+				span := trace.SpanFromContext(job.ctx)
+				if err := indexToES(bi, mode, job); err != nil {
 					log.Printf("[worker %d] failed to index: %v", id, err)
+					job.ack.Done(err)
+					tracing.End(span, err)
+					continue
 				}
-				log.Printf("Indexed %s:%d successfully", job.IP, job.Port)
+				// Bulk item spans are children of this one and end on flush.
+				span.End()
+				log.Printf("Queued %s:%d for bulk indexing", job.result.IP, job.result.Port)
 			}
 		}(i)
 	}
 
-	seeds := []string{"redpanda-0.redpanda.kafka.svc.cluster.local:9093"}
-	cl, err := kgo.NewClient(
-		kgo.SeedBrokers(seeds...),
-		kgo.ConsumeTopics("finished_scan"),
-		kgo.ConsumerGroup("es-worker-group-1"),
-		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
-	)
+	sub, err := b.Subscribe(cfg.Consumer.Group, cfg.Consumer.Topic)
 	if err != nil {
-		log.Fatalf("unable to create client: %v", err)
+		log.Fatalf("unable to subscribe: %v", err)
 	}
-	defer cl.Close()
-
-	log.Println("Redpanda consumer started...")
+	health.Register("bus", b.Ping)
+	health.Register("consumer_group", sub.Joined)
+	health.Register("elasticsearch", func(ctx context.Context) error {
+		res, err := es.Ping(es.Ping.WithContext(ctx))
+		if err != nil {
+			return err
+		}
+		defer res.Body.Close()
+		if res.IsError() {
+			return fmt.Errorf("ping: %s", res.Status())
+		}
+		return nil
+	})
+	tracker.Bind(sub)
+	flow.Bind(sub)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	log.Println("Consumer started...")
 
-	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-sig
-		log.Println("Shutdown signal received, closing...")
-		cancel()
-		close(jobQueue)
-	}()
+	ctx, stop := shutdown.OnSignal()
+	defer stop()
+	go flow.Run(ctx)
 
 	log.Println("Starting fetching loop")
-	for {
-		fetches := cl.PollFetches(ctx)
-		if errs := fetches.Errors(); len(errs) > 0 {
-			for _, e := range errs {
-				log.Printf("fetch error: %v", e)
-			}
-			continue
+	for ctx.Err() == nil {
+		room := flow.Room(ctx)
+		if room == 0 {
+			break
+		}
+		health.Idle()
+		records, err := sub.Poll(ctx, room)
+		health.Beat()
+		if errors.Is(err, bus.ErrClosed) || ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("fetch error: %v", err)
+		}
+		if len(records) > 0 {
+			metrics.Consumed(cfg.Consumer.Topic, len(records))
 		}
 
-		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
-			for _, record := range p.Records {
-				var result ServiceScanResult
-				if err := json.Unmarshal(record.Value, &result); err != nil {
-					log.Printf("invalid message: %v", err)
-					continue
-				}
-				log.Println("Fetched 1 message")
-				jobQueue <- result
+		for _, record := range records {
+			health.Beat()
+			a := tracker.Track(record)
+			spanCtx, span := tracing.StartProcess(record)
+
+			var result model.ServiceScanResult
+			if err := model.Unmarshal(record.Value, &result); err != nil {
+				log.Printf("invalid message: %v", err)
+				deadLetters.Send(record, dlq.ReasonDecode, err, func() { a.Done(nil) })
+				tracing.End(span, err)
+				continue
 			}
-		})
+			log.Println("Fetched 1 message")
+			// Never waits: the poll was sized to the queue's room, and
+			// only this loop sends.
+			jobQueue <- indexJob{result: result, ack: a, ctx: spanCtx}
+			flow.Admit(record.Topic, record.Partition)
+		}
+	}
+
+	log.Printf("Shutdown signal received, draining %d queued docs (deadline %s)", len(jobQueue), cfg.Runtime.ShutdownTimeout)
+	workCtx, finalCtx, cancel := shutdown.Deadlines(cfg.Runtime.ShutdownTimeout)
+	defer cancel()
+
+	// Only the fetch loop sends on jobQueue, and it has returned, so closing
+	// here cannot race a send.
+	close(jobQueue)
+	if shutdown.Wait(workCtx, &wg) {
+		// Flushes the last bulk request; its callbacks ack the records and
+		// may still dead-letter rejections, so the bus closes after it.
+		if err := bi.Close(finalCtx); err != nil {
+			log.Printf("Error closing bulk indexer: %v", err)
+		}
+	} else {
+		// Workers may still be adding items, which would panic on a closed
+		// indexer. Unflushed docs are unacked and get redelivered.
+		log.Println("Drain deadline reached with docs still queued; they will be redelivered")
 	}
+	flushTraces(finalCtx)
+	sub.Close(finalCtx)
+	log.Println("Shutdown complete")
+}
+
+// indexJob is a decoded record waiting for its bulk item to flush; the
+// record is acked from the item's OnSuccess/OnFailure callback.
+type indexJob struct {
+	result model.ServiceScanResult
+	ack    *ack.Ack
+	// ctx carries the record's processing span; it is never canceled.
+	ctx context.Context
 }
 
-func indexToES(bi esutil.BulkIndexer, result ServiceScanResult) error {
+// errUnindexable marks documents that could not even be encoded.
+var errUnindexable = errors.New("unindexable document")
+
+// indexToES queues one bulk item per enabled mode; the job's record is
+// done once all of them have flushed.
+func indexToES(bi esutil.BulkIndexer, mode indexMode, job indexJob) error {
 	log.Println("Indexing to Elasticsearch in bulk")
-	data, err := json.Marshal(result)
+	data, err := json.Marshal(job.result)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %v", errUnindexable, err)
 	}
 
-	return bi.Add(context.Background(), esutil.BulkIndexerItem{
-		Action: "index",
-		Body:   bytes.NewReader(data),
-		OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, resp esutil.BulkIndexerResponseItem, err error) {
-			log.Printf("failed indexing doc: %v, resp: %+v", err, resp)
-		},
-	})
+	// One span per bulk item, from queueing until its bulk request returns.
+	spans := map[string]trace.Span{}
+	for index, enabled := range map[string]bool{historyAlias: mode.history, latestIndex: mode.latest} {
+		if enabled {
+			_, spans[index] = tracing.Tracer.Start(job.ctx, "bulk index "+index)
+		}
+	}
+	onSuccess := func(ctx context.Context, item esutil.BulkIndexerItem, resp esutil.BulkIndexerResponseItem) {
+		spans[item.Index].SetAttributes(attribute.String("es.result", resp.Result))
+		spans[item.Index].End()
+		job.ack.Done(nil)
+	}
+	onFailure := func(ctx context.Context, item esutil.BulkIndexerItem, resp esutil.BulkIndexerResponseItem, err error) {
+		span := spans[item.Index]
+		span.SetAttributes(attribute.Int("es.status", resp.Status))
+		// A newer scan of the service is already in the latest index.
+		if err == nil && resp.Status == 409 && item.Index == latestIndex {
+			span.End()
+			job.ack.Done(nil)
+			return
+		}
+		log.Printf("failed indexing doc: %v, resp: %+v", err, resp)
+		if err == nil {
+			err = fmt.Errorf("%s: %s (status %d)", resp.Error.Type, resp.Error.Reason, resp.Status)
+		}
+		tracing.End(span, err)
+		job.ack.Done(err)
+	}
+
+	var items []esutil.BulkIndexerItem
+	if mode.history {
+		items = append(items, esutil.BulkIndexerItem{
+			// Write alias managed by the orchestrator; ILM rolls the index behind it.
+			// RequireAlias stops ES from auto-creating a plain "scans-write" index
+			// if we start before the orchestrator has bootstrapped the alias.
+			Index:        historyAlias,
+			RequireAlias: true,
+			Action:       "index",
+			DocumentID:   historyDocID(job.result),
+			Body:         bytes.NewReader(data),
+			OnSuccess:    onSuccess,
+			OnFailure:    onFailure,
+		})
+	}
+	if mode.latest {
+		// External versioning on the scan timestamp makes the upsert
+		// order-independent: an older scan arriving late is rejected with a
+		// conflict instead of overwriting a newer one.
+		version := job.result.Timestamp
+		items = append(items, esutil.BulkIndexerItem{
+			Index:       latestIndex,
+			Action:      "index",
+			DocumentID:  latestDocID(job.result),
+			Version:     &version,
+			VersionType: "external_gte",
+			Body:        bytes.NewReader(data),
+			OnSuccess:   onSuccess,
+			OnFailure:   onFailure,
+		})
+	}
+
+	// The job arrives holding one reference; take one per extra item.
+	job.ack.Add(len(items) - 1)
+	for i, item := range items {
+		if err := bi.Add(context.Background(), item); err != nil {
+			// Items never queued will not call back; release them here.
+			for _, rest := range items[i:] {
+				tracing.End(spans[rest.Index], err)
+			}
+			for range items[i+1:] {
+				job.ack.Done(nil)
+			}
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// registerBulkStats exposes bi.Stats() on /metrics. Values are read at
+// scrape time, so nothing has to be updated on the indexing path.
+func registerBulkStats(bi esutil.BulkIndexer) {
+	counter := func(name, help string, get func(esutil.BulkIndexerStats) uint64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "exploravis",
+			Subsystem: "es_bulk",
+			Name:      name,
+			Help:      help,
+		}, func() float64 { return float64(get(bi.Stats())) })
+	}
+	counter("items_added_total", "Items added to the bulk indexer.",
+		func(s esutil.BulkIndexerStats) uint64 { return s.NumAdded })
+	counter("items_flushed_total", "Items sent to Elasticsearch in a bulk request.",
+		func(s esutil.BulkIndexerStats) uint64 { return s.NumFlushed })
+	counter("items_failed_total", "Items Elasticsearch rejected or that failed to send.",
+		func(s esutil.BulkIndexerStats) uint64 { return s.NumFailed })
+	counter("items_indexed_total", "Items Elasticsearch indexed.",
+		func(s esutil.BulkIndexerStats) uint64 { return s.NumIndexed })
+	counter("items_created_total", "Indexed items that created a new document.",
+		func(s esutil.BulkIndexerStats) uint64 { return s.NumCreated })
+	counter("items_updated_total", "Indexed items that replaced an existing document.",
+		func(s esutil.BulkIndexerStats) uint64 { return s.NumUpdated })
+	counter("requests_total", "Bulk requests sent.",
+		func(s esutil.BulkIndexerStats) uint64 { return s.NumRequests })
+	counter("flushed_bytes_total", "Bytes of bulk request bodies sent.",
+		func(s esutil.BulkIndexerStats) uint64 { return s.FlushedBytes })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "exploravis",
+		Subsystem: "es_bulk",
+		Name:      "pending_items",
+		Help:      "Items added but not yet flushed: the bulk indexer's backlog.",
+	}, func() float64 {
+		s := bi.Stats()
+		return float64(s.NumAdded) - float64(s.NumFlushed)
+	})
+}
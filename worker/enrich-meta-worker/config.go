@@ -0,0 +1,32 @@
+package main
+
+import "github.com/exploravis/config"
+
+type Config struct {
+	Bus      config.Bus      `yaml:"bus"`
+	Kafka    config.Kafka    `yaml:"kafka"`
+	Consumer config.Consumer `yaml:"consumer"`
+	Producer config.Producer `yaml:"producer"`
+	GeoIP    GeoIPConfig     `yaml:"geoip"`
+	Runtime  config.Runtime  `yaml:"runtime"`
+	Tracing  config.Tracing  `yaml:"tracing"`
+}
+
+// GeoIPConfig points at the MaxMind databases.
+type GeoIPConfig struct {
+	CityDB string `yaml:"city_db" env:"MAXMIND_CITY_DB" usage:"path to GeoLite2-City.mmdb" required:"true"`
+	ASNDB  string `yaml:"asn_db" env:"MAXMIND_ASN_DB" usage:"path to GeoLite2-ASN.mmdb" required:"true"`
+}
+
+func (c *Config) Validate() error {
+	return c.Bus.ValidateTxn(c.Kafka)
+}
+
+func loadConfig() Config {
+	cfg := Config{
+		Consumer: config.Consumer{Topic: "not_enriched_finished_scan", Group: "meta-enrich-group"},
+		Producer: config.Producer{Topic: "finished_scan"},
+	}
+	config.MustLoad("enrich-meta-worker", &cfg)
+	return cfg
+}
@@ -1,30 +1,21 @@
-package main
+// Package enrich adds GeoIP, ASN and reverse DNS metadata to scan results.
+package enrich
 
 import (
+	"context"
 	"log"
 	"net"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/tracing"
 	"github.com/oschwald/geoip2-golang"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type ServiceScanResult struct {
-	ScanID    string         `json:"scan_id,omitempty"`
-	IP        string         `json:"ip"`
-	Port      int            `json:"port"`
-	Timestamp int64          `json:"timestamp"`
-	Protocol  string         `json:"protocol"`
-	Service   string         `json:"service,omitempty"`
-	Banner    string         `json:"banner,omitempty"`
-	TLS       map[string]any `json:"tls,omitempty"`
-	HTTP      map[string]any `json:"http,omitempty"`
-	SSH       map[string]any `json:"ssh,omitempty"`
-	RawTCP    string         `json:"raw_tcp,omitempty"`
-	Meta      map[string]any `json:"meta,omitempty"`
-}
-
 type ttlCache struct {
 	mu    sync.Mutex
 	items map[string]cacheEntry
@@ -82,18 +73,30 @@ type Enricher struct {
 	ttl     time.Duration
 }
 
+// NewEnricher opens the MaxMind databases. An empty path skips that
+// database, leaving only reverse DNS, which is what dev mode runs with when
+// no databases are at hand.
 func NewEnricher(geoPath, asnPath string) (*Enricher, error) {
-	log.Printf("[INIT] Loading Geo DB: %s", geoPath)
-	geoDB, err := geoip2.Open(geoPath)
-	if err != nil {
-		return nil, err
+	var geoDB, asnDB *geoip2.Reader
+	if geoPath == "" {
+		log.Println("[WARN] No Geo DB configured, results get no geo metadata")
+	} else {
+		log.Printf("[INIT] Loading Geo DB: %s", geoPath)
+		db, err := geoip2.Open(geoPath)
+		if err != nil {
+			return nil, err
+		}
+		geoDB = db
 	}
 
-	log.Printf("[INIT] Loading ASN DB: %s", asnPath)
-	asnDB, err := geoip2.Open(asnPath)
-	if err != nil {
-		log.Printf("[WARN] Failed to load ASN DB: %v", err)
-		asnDB = nil
+	if asnPath != "" {
+		log.Printf("[INIT] Loading ASN DB: %s", asnPath)
+		db, err := geoip2.Open(asnPath)
+		if err != nil {
+			log.Printf("[WARN] Failed to load ASN DB: %v", err)
+		} else {
+			asnDB = db
+		}
 	}
 
 	return &Enricher{
@@ -115,7 +118,9 @@ func (e *Enricher) Close() {
 }
 
 func (e *Enricher) reverseDNS(ip string) (string, error) {
-	if v, ok := e.rdns.Get(ip); ok {
+	v, ok := e.rdns.Get(ip)
+	cacheLookups.WithLabelValues("rdns", cacheResult(ok)).Inc()
+	if ok {
 		return v, nil
 	}
 	names, err := net.LookupAddr(ip)
@@ -164,11 +169,13 @@ func (e *Enricher) geoASNLookup(ip string) (map[string]any, map[string]any) {
 func (e *Enricher) lookupIPMeta(ip string) (geo, asn map[string]any, hostname string) {
 	now := time.Now()
 	e.mu.RLock()
-	if meta, ok := e.ipCache[ip]; ok && meta.Expires.After(now) {
-		e.mu.RUnlock()
+	meta, ok := e.ipCache[ip]
+	e.mu.RUnlock()
+	hit := ok && meta.Expires.After(now)
+	cacheLookups.WithLabelValues("ip_meta", cacheResult(hit)).Inc()
+	if hit {
 		return meta.Geo, meta.ASN, meta.Hostname
 	}
-	e.mu.RUnlock()
 
 	geo, asn = e.geoASNLookup(ip)
 	hn, _ := e.reverseDNS(ip)
@@ -185,7 +192,14 @@ func (e *Enricher) lookupIPMeta(ip string) (geo, asn map[string]any, hostname st
 	return geo, asn, hn
 }
 
-func (e *Enricher) enrichMessage(src ServiceScanResult) (ServiceScanResult, error) {
+// Enrich returns a copy of src with meta.geo, meta.asn and meta.hostname
+// filled in from whatever is known about its IP.
+func (e *Enricher) Enrich(ctx context.Context, src model.ServiceScanResult) (model.ServiceScanResult, error) {
+	start := time.Now()
+	defer func() { enrichDuration.Observe(time.Since(start).Seconds()) }()
+	_, span := tracing.Tracer.Start(ctx, "enrich", trace.WithAttributes(semconv.NetworkPeerAddress(src.IP)))
+	defer span.End()
+
 	out := src
 	if out.Meta == nil {
 		out.Meta = map[string]any{}
@@ -0,0 +1,31 @@
+package enrich
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// Hit rate is hit / (hit + miss) per cache.
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "exploravis",
+		Subsystem: "enrich",
+		Name:      "cache_lookups_total",
+		Help:      "Enricher cache lookups by cache (ip_meta, rdns) and result (hit or miss).",
+	}, []string{"cache", "result"})
+	enrichDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "exploravis",
+		Subsystem: "enrich",
+		Name:      "duration_seconds",
+		Help:      "Time to enrich one result, including GeoIP and reverse DNS lookups.",
+		// Cache hits are microseconds, rDNS misses can take seconds.
+		Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+	})
+)
+
+func cacheResult(hit bool) string {
+	if hit {
+		return "hit"
+	}
+	return "miss"
+}
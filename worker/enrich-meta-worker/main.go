@@ -2,85 +2,158 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
-	"os"
-	"strings"
+	"sync/atomic"
 
 	"github.com/joho/godotenv"
 	"github.com/twmb/franz-go/pkg/kgo"
 
+	"github.com/exploravis/bus"
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/ack"
+	"github.com/exploravis/worker/dlq"
+	"github.com/exploravis/worker/enrich-meta-worker/enrich"
 	"github.com/exploravis/worker/enrich-meta-worker/producer"
+	"github.com/exploravis/worker/health"
+	"github.com/exploravis/worker/metrics"
+	"github.com/exploravis/worker/shutdown"
+	"github.com/exploravis/worker/tracing"
 )
 
 func main() {
 	godotenv.Load()
-	seedsEnv := os.Getenv("KAFKA_SEEDS")
-	seeds := []string{"redpanda-0.redpanda.kafka.svc.cluster.local:9093"}
-	if seedsEnv != "" {
-		seeds = strings.Split(seedsEnv, ",")
-	}
-
-	producer.InitProducer(seeds)
-
-	geoPath := os.Getenv("MAXMIND_CITY_DB")
-	asnPath := os.Getenv("MAXMIND_ASN_DB")
-	log.Println("MAXMIND_CITY_DB:", geoPath)
-	log.Println("MAXMIND_ASN_DB:", asnPath)
+	cfg := loadConfig()
+	metrics.Serve(cfg.Runtime.MetricsAddr, health.Routes(cfg.Runtime.StallTimeout))
+	flushTraces := tracing.Init("enrich-meta-worker", cfg.Tracing)
 
-	enricher, err := NewEnricher(geoPath, asnPath)
+	b, err := bus.New(cfg.Bus, cfg.Kafka)
 	if err != nil {
-		log.Fatalf("[FATAL] Enricher init failed: %v", err)
+		log.Fatalf("[FATAL] Bus init failed: %v", err)
 	}
-	defer enricher.Close()
-
-	client, err := kgo.NewClient(
-		kgo.SeedBrokers(seeds...),
-		kgo.ConsumeTopics("not_enriched_finished_scan"),
-		kgo.ConsumerGroup("meta-enrich-group"),
-		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	defer b.Close()
+
+	// With exactly-once, results, dead letters and consumed offsets of a
+	// batch all go through one transaction.
+	var (
+		sub bus.Subscription
+		txn *bus.Txn
+		out bus.Publisher = b
 	)
+	if cfg.Kafka.Txn.ExactlyOnce {
+		txn, err = b.(*bus.Kafka).Transact(cfg.Kafka.Txn.ID("enrich"), cfg.Consumer.Group, cfg.Consumer.Topic)
+		sub, out = txn, txn
+	} else {
+		sub, err = b.Subscribe(cfg.Consumer.Group, cfg.Consumer.Topic)
+	}
 	if err != nil {
-		log.Fatalf("[FATAL] Kafka init failed: %v", err)
+		log.Fatalf("[FATAL] Subscribe failed: %v", err)
 	}
-	defer client.Close()
-
-	log.Println("[READY] Metadata Enrichment Worker Running...")
+	health.Register("bus", b.Ping)
+	health.Register("consumer_group", sub.Joined)
 
-	ctx := context.Background()
+	producer.InitProducer(out, cfg.Producer)
+	deadLetters := dlq.NewWriter(out, "enrich")
 
-	for {
-		fetches := client.PollFetches(ctx)
+	log.Println("MAXMIND_CITY_DB:", cfg.GeoIP.CityDB)
+	log.Println("MAXMIND_ASN_DB:", cfg.GeoIP.ASNDB)
 
-		if errs := fetches.Errors(); len(errs) > 0 {
-			for _, e := range errs {
-				log.Printf("[KAFKA][ERR] %v", e)
-			}
-			continue
+	var geoLoaded atomic.Bool
+	health.Register("geoip", func(context.Context) error {
+		if !geoLoaded.Load() {
+			return errors.New("databases still loading")
+		}
+		return nil
+	})
+	enricher, err := enrich.NewEnricher(cfg.GeoIP.CityDB, cfg.GeoIP.ASNDB)
+	if err != nil {
+		log.Fatalf("[FATAL] Enricher init failed: %v", err)
+	}
+	defer enricher.Close()
+	geoLoaded.Store(true)
+
+	tracker := ack.NewTracker(func(rec *kgo.Record, err error, done func()) {
+		deadLetters.Send(rec, dlq.ReasonFor(err), err, done)
+	})
+	tracker.Bind(sub)
+
+	log.Printf("[READY] Metadata Enrichment Worker Running (exactly-once: %v)...", txn != nil)
+
+	ctx, stop := shutdown.OnSignal()
+	defer stop()
+	drain, cancelDrain := shutdown.Drain(ctx, cfg.Runtime.ShutdownTimeout)
+	defer cancelDrain()
+
+	for ctx.Err() == nil {
+		health.Idle()
+		recs, err := sub.Poll(ctx, 0)
+		health.Beat()
+		if errors.Is(err, bus.ErrClosed) || ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("[BUS][ERR] %v", err)
+		}
+		if len(recs) > 0 {
+			metrics.Consumed(cfg.Consumer.Topic, len(recs))
 		}
 
-		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
-			for _, rec := range p.Records {
+		interrupted := false
+		for _, rec := range recs {
+			if ctx.Err() != nil {
+				// The rest of the batch is redelivered after restart.
+				interrupted = true
+				break
+			}
+			health.Beat()
+			a := tracker.Track(rec)
+			spanCtx, span := tracing.StartProcess(rec)
+
+			var src model.ServiceScanResult
+			if err := model.Unmarshal(rec.Value, &src); err != nil {
+				log.Printf("[ERROR] Invalid message: %v", err)
+				deadLetters.Send(rec, dlq.ReasonDecode, err, func() { a.Done(nil) })
+				tracing.End(span, err)
+				continue
+			}
 
-				var src ServiceScanResult
-				if err := json.Unmarshal(rec.Value, &src); err != nil {
-					log.Printf("[ERROR] Invalid message: %v", err)
-					continue
-				}
+			log.Println("ScanID:", src.ScanID)
 
-				log.Println("ScanID:", src.ScanID)
+			enriched, err := enricher.Enrich(spanCtx, src)
+			if err != nil {
+				log.Printf("[ERROR] Enrichment failed: %v", err)
+				a.Done(err)
+				tracing.End(span, err)
+				continue
+			}
 
-				enriched, err := enricher.enrichMessage(src)
-				if err != nil {
-					log.Printf("[ERROR] Enrichment failed: %v", err)
-					continue
-				}
+			// Acked once finished_scan has the enriched copy.
+			producer.ProduceResult(spanCtx, &enriched, a.Done)
+			span.End()
 
-				bytes, _ := json.Marshal(enriched)
-				producer.ProduceResult(bytes)
+			log.Printf("[DONE] Enriched %s:%d", src.IP, src.Port)
+		}
 
-				log.Printf("[DONE] Enriched %s:%d", src.IP, src.Port)
+		if txn != nil {
+			// Commits once every result is delivered; an aborted batch
+			// is polled again.
+			committed, err := txn.End(drain, !interrupted)
+			if err != nil {
+				log.Fatalf("[FATAL] Ending transaction failed: %v", err)
+			}
+			if len(recs) > 0 {
+				metrics.TxnEnded(committed)
 			}
-		})
+		}
 	}
+
+	log.Printf("[INFO] Shutdown signal received, flushing (deadline %s)", cfg.Runtime.ShutdownTimeout)
+	_, finalCtx, cancel := shutdown.Deadlines(cfg.Runtime.ShutdownTimeout)
+	defer cancel()
+
+	// Records are enriched inline, so only deliveries are still in flight.
+	producer.FlushProducer(finalCtx)
+	flushTraces(finalCtx)
+	sub.Close(finalCtx)
+	log.Println("[INFO] Shutdown complete")
 }
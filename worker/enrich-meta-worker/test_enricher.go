@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
+
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/enrich-meta-worker/enrich"
 )
 
 func testEnricherStandalone() {
 	geoPath := os.Getenv("MAXMIND_CITY_DB")
 	asnPath := os.Getenv("MAXMIND_ASN_DB")
 
-	enricher, err := NewEnricher(geoPath, asnPath)
+	enricher, err := enrich.NewEnricher(geoPath, asnPath)
 	if err != nil {
 		log.Fatalf("failed to init enricher: %v", err)
 	}
@@ -23,13 +27,13 @@ func testEnricherStandalone() {
 	}
 
 	for _, ip := range samples {
-		src := ServiceScanResult{
+		src := model.ServiceScanResult{
 			IP:   ip,
 			Port: 80,
 			Meta: map[string]any{}, // so enricher fills geo/asn
 		}
 
-		enriched, err := enricher.enrichMessage(src)
+		enriched, err := enricher.Enrich(context.Background(), src)
 		if err != nil {
 			log.Printf("error enriching %s: %v", ip, err)
 			continue
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/tracing"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newAPIServer serves the subset of the orchestrator's API the UI needs, in
+// the same shapes, backed by the pipeline and its store.
+func newAPIServer(cfg DevConfig, p *pipeline) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("POST /scan", devScanHandler(p))
+	mux.Handle("GET /scan/{id}/stream", devStreamHandler(p))
+	mux.Handle("GET /scans", devScansHandler(p.store))
+	mux.Handle("GET /health", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok","mode":"dev"}`))
+	}))
+
+	srv := &http.Server{Addr: ":" + cfg.HTTP.Port, Handler: cors(mux)}
+	// Streams never end on their own; closing them lets Shutdown finish.
+	srv.RegisterOnShutdown(p.watchers.closeAll)
+	return srv
+}
+
+func cors(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// devScanHandler queues the whole range as one request; the orchestrator's
+// split into /24 chunks only matters for spreading work over partitions.
+func devScanHandler(p *pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req model.ScanRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+		if req.IPRange == "" {
+			http.Error(w, "ip_range required", http.StatusBadRequest)
+			return
+		}
+		req.ScanID = uuid.NewString()
+
+		_, span := tracing.Tracer.Start(r.Context(), "scan submit", trace.WithAttributes(
+			attribute.String("scan.id", req.ScanID),
+			attribute.String("scan.ip_range", req.IPRange),
+			attribute.String("scan.ports", req.Ports),
+		))
+		defer span.End()
+
+		// The scan outlives this request; only its trace carries over.
+		if err := p.submit(trace.ContextWithSpan(context.Background(), span), req); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("[INFO] Scan %s queued for %s", req.ScanID, req.IPRange)
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"queued","scan_id":"` + req.ScanID + `"}`))
+	})
+}
+
+// devScansHandler supports scan_id, size and from; full-text queries and
+// aggregations need Elasticsearch.
+func devScansHandler(store Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		q := r.URL.Query()
+		size, from := 20, 0
+		if v, err := strconv.Atoi(q.Get("size")); err == nil && v > 0 {
+			size = min(v, 1000)
+		}
+		if v, err := strconv.Atoi(q.Get("from")); err == nil && v > 0 {
+			from = v
+		}
+
+		results, total, err := store.Search(q.Get("scan_id"), from+size)
+		if err != nil {
+			http.Error(w, "search failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results = results[min(from, len(results)):]
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"total":   total,
+			"results": results,
+			"took_ms": time.Since(start).Milliseconds(),
+		})
+	})
+}
+
+// devStreamHandler sends a scan's results as server-sent events as they are
+// stored, in the orchestrator's event format. It does not resume: results
+// stored while disconnected are in /scans.
+func devStreamHandler(p *pipeline) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scanID := r.PathValue("id")
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+		ch := p.watchers.subscribe(scanID)
+		defer p.watchers.unsubscribe(ch)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, "retry: 3000\n\n")
+		flusher.Flush()
+
+		heartbeat := time.NewTicker(15 * time.Second)
+		defer heartbeat.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": ping\n\n")
+			case res, ok := <-ch:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(res)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "event: result\ndata: %s\n\n", data)
+			}
+			flusher.Flush()
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/exploravis/config"
+)
+
+// DevConfig is the settings of `exploravis dev`. Nothing in it points at an
+// outside service.
+type DevConfig struct {
+	HTTP     HTTPConfig     `yaml:"http"`
+	Store    StoreConfig    `yaml:"store"`
+	Scanners int            `yaml:"scanners" env:"SCANNER_COUNT" default:"2" usage:"concurrent naabu scans"`
+	Workers  config.Workers `yaml:"workers"`
+	GeoIP    GeoIPConfig    `yaml:"geoip"`
+	Runtime  config.Runtime `yaml:"runtime"`
+	Tracing  config.Tracing `yaml:"tracing"`
+}
+
+type HTTPConfig struct {
+	Port string `yaml:"port" env:"PORT" default:"8089" usage:"API listen port, the orchestrator's by default so the UI works unchanged"`
+}
+
+// StoreConfig picks what stands in for Elasticsearch.
+type StoreConfig struct {
+	Kind string `yaml:"kind" env:"DEV_STORE" default:"memory" usage:"result store: memory or file"`
+	Path string `yaml:"path" env:"DEV_STORE_PATH" default:"exploravis-results.jsonl" usage:"JSON lines file the file store appends to"`
+}
+
+func (s *StoreConfig) Validate() error {
+	switch s.Kind {
+	case "memory", "file":
+		return nil
+	}
+	return errors.New("store.kind must be memory or file")
+}
+
+// GeoIPConfig is optional in dev mode; without databases results only get
+// reverse DNS metadata.
+type GeoIPConfig struct {
+	CityDB string `yaml:"city_db" env:"MAXMIND_CITY_DB" usage:"path to GeoLite2-City.mmdb"`
+	ASNDB  string `yaml:"asn_db" env:"MAXMIND_ASN_DB" usage:"path to GeoLite2-ASN.mmdb"`
+}
+
+func (c *DevConfig) Validate() error {
+	if c.Scanners < 1 {
+		return errors.New("scanners must be at least 1")
+	}
+	return nil
+}
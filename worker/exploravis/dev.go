@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/banner-worker/banner"
+	"github.com/exploravis/worker/enrich-meta-worker/enrich"
+	"github.com/exploravis/worker/metrics"
+	"github.com/exploravis/worker/scanner-worker/scanner"
+	"github.com/exploravis/worker/shutdown"
+	"github.com/exploravis/worker/tracing"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// envelope is a message between two stages with the trace it belongs to,
+// which the deployed workers carry in Kafka record headers.
+type envelope[T any] struct {
+	ctx context.Context
+	msg T
+}
+
+// pipeline runs every stage in one process. Each channel stands in for the
+// topic named next to it; a full channel blocks the stage feeding it, which
+// is the in-process version of backpressure.
+type pipeline struct {
+	requests chan envelope[model.ScanRequest]         // ip_scan_request
+	hosts    chan envelope[model.HostPorts]           // ip_scan_result
+	grabs    chan envelope[banner.ServiceScanRequest] // one port of a host
+	grabbed  chan envelope[model.ServiceScanResult]   // not_enriched_finished_scan
+	finished chan envelope[model.ServiceScanResult]   // finished_scan
+
+	enricher *enrich.Enricher
+	store    Store
+	watchers *watchers
+
+	// scanCtx outlives shutdown's signal so in-flight scans can finish
+	// draining; abort cancels it once the deadline passes.
+	scanCtx context.Context
+	abort   context.CancelFunc
+
+	// closed is set under the write lock when requests is closed; submit
+	// holds the read lock while sending.
+	mu     sync.RWMutex
+	closed bool
+
+	// One group per stage, closed and waited on in pipeline order.
+	scanners, splitters, grabbers, enrichers, sinks sync.WaitGroup
+}
+
+func newPipeline(cfg DevConfig, enricher *enrich.Enricher, store Store) *pipeline {
+	scanCtx, abort := context.WithCancel(context.Background())
+	return &pipeline{
+		requests: make(chan envelope[model.ScanRequest], cfg.Workers.QueueSize),
+		hosts:    make(chan envelope[model.HostPorts], cfg.Workers.QueueSize),
+		grabs:    make(chan envelope[banner.ServiceScanRequest], cfg.Workers.QueueSize),
+		grabbed:  make(chan envelope[model.ServiceScanResult], cfg.Workers.QueueSize),
+		finished: make(chan envelope[model.ServiceScanResult], cfg.Workers.QueueSize),
+		enricher: enricher,
+		store:    store,
+		watchers: newWatchers(),
+		scanCtx:  scanCtx,
+		abort:    abort,
+	}
+}
+
+// start launches every stage's goroutines.
+func (p *pipeline) start(cfg DevConfig) {
+	for i := range cfg.Scanners {
+		p.scanners.Add(1)
+		go p.scan(i)
+	}
+	p.splitters.Add(1)
+	go p.split()
+	for i := range cfg.Workers.Count {
+		p.grabbers.Add(1)
+		go p.grab(i)
+	}
+	p.enrichers.Add(1)
+	go p.enrich()
+	p.sinks.Add(1)
+	go p.sink()
+}
+
+// submit queues a scan request, or fails if shutdown has begun.
+func (p *pipeline) submit(ctx context.Context, req model.ScanRequest) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return errShuttingDown
+	}
+	select {
+	case p.requests <- envelope[model.ScanRequest]{ctx: ctx, msg: req}:
+		return nil
+	case <-p.scanCtx.Done():
+		return errShuttingDown
+	}
+}
+
+var errShuttingDown = errors.New("pipeline is shutting down")
+
+func (p *pipeline) scan(id int) {
+	defer p.scanners.Done()
+	for e := range p.requests {
+		req := e.msg
+		if p.scanCtx.Err() != nil {
+			continue
+		}
+		log.Printf("[SCANNER %d] Scanning %s:%s (ScanID: %s)", id, req.IPRange, req.Ports, req.ScanID)
+		_, span := tracing.Tracer.Start(e.ctx, "scanner process")
+		// Scans run on scanCtx, not the submitting request's context, but
+		// keep its trace.
+		err := scanner.RunScan(trace.ContextWithSpan(p.scanCtx, span), req, func(ctx context.Context, msg *model.HostPorts) {
+			p.hosts <- envelope[model.HostPorts]{ctx: ctx, msg: *msg}
+		})
+		tracing.End(span, err)
+		if err != nil {
+			log.Printf("[ERROR] ScanID %s failed: %v", req.ScanID, err)
+		}
+	}
+}
+
+// split fans a host's ports out to the grab workers, as banner-worker's
+// poll loop does.
+func (p *pipeline) split() {
+	defer p.splitters.Done()
+	for e := range p.hosts {
+		for _, port := range strings.Split(e.msg.Ports, ",") {
+			p.grabs <- envelope[banner.ServiceScanRequest]{ctx: e.ctx, msg: banner.ServiceScanRequest{
+				ScanID: e.msg.ScanID,
+				IP:     e.msg.Host,
+				Port:   port,
+			}}
+		}
+	}
+}
+
+func (p *pipeline) grab(id int) {
+	defer p.grabbers.Done()
+	for e := range p.grabs {
+		req := e.msg
+		if p.scanCtx.Err() != nil {
+			continue
+		}
+		result, err := banner.Grab(e.ctx, req)
+		if err != nil {
+			log.Printf("[GRABBER %d] %s:%s (ScanID: %s): %v", id, req.IP, req.Port, req.ScanID, err)
+			continue
+		}
+		p.grabbed <- envelope[model.ServiceScanResult]{ctx: e.ctx, msg: result}
+	}
+}
+
+func (p *pipeline) enrich() {
+	defer p.enrichers.Done()
+	for e := range p.grabbed {
+		enriched, err := p.enricher.Enrich(e.ctx, e.msg)
+		if err != nil {
+			log.Printf("[ERROR] Enrichment of %s:%d failed: %v", e.msg.IP, e.msg.Port, err)
+			continue
+		}
+		p.finished <- envelope[model.ServiceScanResult]{ctx: e.ctx, msg: enriched}
+	}
+}
+
+// sink is elasticsearch-worker's place: it stores results and hands them to
+// the open result streams.
+func (p *pipeline) sink() {
+	defer p.sinks.Done()
+	for e := range p.finished {
+		_, span := tracing.Tracer.Start(e.ctx, "store "+e.msg.IP+":"+strconv.Itoa(e.msg.Port))
+		err := p.store.Put(e.msg)
+		tracing.End(span, err)
+		if err != nil {
+			log.Printf("[ERROR] Storing %s:%d failed: %v", e.msg.IP, e.msg.Port, err)
+			continue
+		}
+		p.watchers.publish(e.msg)
+		log.Printf("[DONE] %s:%d %s (ScanID: %s)", e.msg.IP, e.msg.Port, e.msg.Protocol, e.msg.ScanID)
+	}
+}
+
+// drain stops taking requests and lets queued work flow through to the
+// store until ctx ends, after which in-flight scans and queued grabs are
+// dropped. Each stage's input is closed once the stage before it is done.
+func (p *pipeline) drain(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		p.abort()
+	}()
+
+	p.mu.Lock()
+	p.closed = true
+	close(p.requests)
+	p.mu.Unlock()
+	p.scanners.Wait()
+	close(p.hosts)
+	p.splitters.Wait()
+	close(p.grabs)
+	p.grabbers.Wait()
+	close(p.grabbed)
+	p.enrichers.Wait()
+	close(p.finished)
+	p.sinks.Wait()
+}
+
+// watchers are the open /scan/{id}/stream clients.
+type watchers struct {
+	mu   sync.Mutex
+	subs map[chan model.ServiceScanResult]string
+}
+
+func newWatchers() *watchers {
+	return &watchers{subs: map[chan model.ServiceScanResult]string{}}
+}
+
+func (w *watchers) subscribe(scanID string) chan model.ServiceScanResult {
+	ch := make(chan model.ServiceScanResult, 64)
+	w.mu.Lock()
+	w.subs[ch] = scanID
+	w.mu.Unlock()
+	return ch
+}
+
+func (w *watchers) unsubscribe(ch chan model.ServiceScanResult) {
+	w.mu.Lock()
+	if _, ok := w.subs[ch]; ok {
+		delete(w.subs, ch)
+		close(ch)
+	}
+	w.mu.Unlock()
+}
+
+// publish never blocks the sink: a client too slow to keep up misses
+// results, which it can still fetch from /scans.
+func (w *watchers) publish(res model.ServiceScanResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch, scanID := range w.subs {
+		if scanID != res.ScanID {
+			continue
+		}
+		select {
+		case ch <- res:
+		default:
+		}
+	}
+}
+
+func (w *watchers) closeAll() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for ch := range w.subs {
+		delete(w.subs, ch)
+		close(ch)
+	}
+}
+
+// runDev implements `exploravis dev`.
+func runDev(args []string) {
+	var cfg DevConfig
+	if err := loadDevConfig(&cfg, args); err != nil {
+		log.Fatal(err)
+	}
+	metrics.Serve(cfg.Runtime.MetricsAddr)
+	flushTraces := tracing.Init("exploravis-dev", cfg.Tracing)
+
+	store, err := newStore(cfg.Store)
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to open %s store: %v", cfg.Store.Kind, err)
+	}
+	enricher, err := enrich.NewEnricher(cfg.GeoIP.CityDB, cfg.GeoIP.ASNDB)
+	if err != nil {
+		log.Fatalf("[ERROR] Enricher init failed: %v", err)
+	}
+	defer enricher.Close()
+
+	p := newPipeline(cfg, enricher, store)
+	p.start(cfg)
+
+	srv := newAPIServer(cfg, p)
+	go func() {
+		log.Printf("[INFO] Dev API listening on :%s (store: %s)", cfg.HTTP.Port, cfg.Store.Kind)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("[ERROR] API server failed: %v", err)
+		}
+	}()
+
+	ctx, stop := shutdown.OnSignal()
+	defer stop()
+	<-ctx.Done()
+
+	log.Printf("[INFO] Shutdown signal received, draining the pipeline (deadline %s)", cfg.Runtime.ShutdownTimeout)
+	workCtx, finalCtx, cancel := shutdown.Deadlines(cfg.Runtime.ShutdownTimeout)
+	defer cancel()
+
+	// Stop taking scans first; open streams are ended by the server's
+	// shutdown hook.
+	_ = srv.Shutdown(workCtx)
+	drained := make(chan struct{})
+	go func() {
+		p.drain(workCtx)
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-finalCtx.Done():
+		log.Println("[WARN] Drain deadline reached, dropping queued work")
+	}
+	if err := store.Close(); err != nil {
+		log.Printf("[WARN] Closing store: %v", err)
+	}
+	flushTraces(finalCtx)
+	log.Println("[INFO] Shutdown complete")
+}
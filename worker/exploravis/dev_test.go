@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/exploravis/config"
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/enrich-meta-worker/enrich"
+)
+
+func testDevConfig() DevConfig {
+	return DevConfig{
+		Scanners: 1,
+		Workers:  config.Workers{Count: 2, QueueSize: 16},
+		Store:    StoreConfig{Kind: "memory"},
+	}
+}
+
+// newTestPipeline runs every stage but the scanners, which need naabu;
+// work is fed in on p.hosts, where the scanners would put it.
+func newTestPipeline(t *testing.T, store Store) *pipeline {
+	t.Helper()
+	enricher, err := enrich.NewEnricher("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(enricher.Close)
+	cfg := testDevConfig()
+	cfg.Scanners = 0
+	p := newPipeline(cfg, enricher, store)
+	p.start(cfg)
+	return p
+}
+
+// bannerServer is a TCP service that greets every connection.
+func bannerServer(t *testing.T, greeting string) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(greeting))
+			time.Sleep(100 * time.Millisecond)
+			conn.Close()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// A host the scanner found flows through grab, enrich and the store to an
+// open stream, and drain waits for it.
+func TestPipelineHostToStore(t *testing.T) {
+	port := bannerServer(t, "220 exploravis test service\r\n")
+	store := &memoryStore{}
+	p := newTestPipeline(t, store)
+	stream := p.watchers.subscribe("s1")
+
+	p.hosts <- envelope[model.HostPorts]{ctx: context.Background(), msg: model.HostPorts{
+		ScanID: "s1", Host: "127.0.0.1", Ports: strconv.Itoa(port),
+	}}
+
+	var res model.ServiceScanResult
+	select {
+	case res = <-stream:
+	case <-time.After(30 * time.Second):
+		t.Fatal("no result streamed")
+	}
+	if res.ScanID != "s1" || res.IP != "127.0.0.1" || res.Port != port {
+		t.Errorf("streamed %+v", res)
+	}
+	if !strings.Contains(res.Banner+res.RawTCP, "exploravis test service") {
+		t.Errorf("banner %q / raw %q lacks the greeting", res.Banner, res.RawTCP)
+	}
+	if res.Meta == nil {
+		t.Error("result was not enriched")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	p.drain(ctx)
+	if got, total, _ := store.Search("s1", 10); total != 1 || got[0].Port != port {
+		t.Errorf("store has %d results: %+v", total, got)
+	}
+	if err := p.submit(context.Background(), model.ScanRequest{IPRange: "127.0.0.1"}); err != errShuttingDown {
+		t.Errorf("submit after drain = %v, want errShuttingDown", err)
+	}
+}
+
+func TestScanHandler(t *testing.T) {
+	enricher, _ := enrich.NewEnricher("", "")
+	defer enricher.Close()
+	// Not started: queued requests stay on p.requests.
+	p := newPipeline(testDevConfig(), enricher, &memoryStore{})
+	srv := httptest.NewServer(newAPIServer(testDevConfig(), p).Handler)
+	defer srv.Close()
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"ip_range":"127.0.0.1/32","ports":"22,80"}`, http.StatusAccepted},
+		{`{"ports":"22"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(srv.URL+"/scan", "application/json", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out map[string]string
+		json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("POST %s = %d, want %d", tt.body, resp.StatusCode, tt.status)
+		}
+		if tt.status != http.StatusAccepted {
+			continue
+		}
+		select {
+		case e := <-p.requests:
+			if e.msg.ScanID == "" || e.msg.ScanID != out["scan_id"] || e.msg.IPRange != "127.0.0.1/32" || e.msg.Ports != "22,80" {
+				t.Errorf("queued %+v for response %v", e.msg, out)
+			}
+		default:
+			t.Error("nothing queued")
+		}
+	}
+
+	p.drain(context.Background())
+	resp, err := http.Post(srv.URL+"/scan", "application/json", strings.NewReader(tests[0].body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("POST while shutting down = %d, want 503", resp.StatusCode)
+	}
+}
+
+func TestScansHandler(t *testing.T) {
+	store := &memoryStore{}
+	for i := range 5 {
+		store.Put(model.ServiceScanResult{ScanID: "a", IP: "10.0.0.1", Port: i})
+	}
+	store.Put(model.ServiceScanResult{ScanID: "b", IP: "10.0.0.2", Port: 99})
+
+	tests := []struct {
+		query string
+		total int
+		ports []int
+	}{
+		{"scan_id=a", 5, []int{4, 3, 2, 1, 0}},
+		{"scan_id=a&size=2", 5, []int{4, 3}},
+		{"scan_id=a&size=2&from=2", 5, []int{2, 1}},
+		{"scan_id=a&from=10", 5, nil},
+		{"scan_id=b", 1, []int{99}},
+		{"", 6, []int{99, 4, 3, 2, 1, 0}},
+		{"scan_id=none", 0, nil},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		devScansHandler(store).ServeHTTP(rec, httptest.NewRequest("GET", "/scans?"+tt.query, nil))
+		var out struct {
+			Total   int                       `json:"total"`
+			Results []model.ServiceScanResult `json:"results"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		var ports []int
+		for _, r := range out.Results {
+			ports = append(ports, r.Port)
+		}
+		if out.Total != tt.total || !equalInts(ports, tt.ports) {
+			t.Errorf("%q: total %d, ports %v; want %d, %v", tt.query, out.Total, ports, tt.total, tt.ports)
+		}
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStreamHandler(t *testing.T) {
+	enricher, _ := enrich.NewEnricher("", "")
+	defer enricher.Close()
+	p := newPipeline(testDevConfig(), enricher, &memoryStore{})
+	srv := httptest.NewServer(newAPIServer(testDevConfig(), p).Handler)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/scan/s1/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("content type %q", ct)
+	}
+	r := bufio.NewReader(resp.Body)
+	// The retry hint is flushed once the client is subscribed.
+	if line, _ := r.ReadString('\n'); line != "retry: 3000\n" {
+		t.Fatalf("first line %q", line)
+	}
+
+	p.watchers.publish(model.ServiceScanResult{ScanID: "other", Port: 1})
+	p.watchers.publish(model.ServiceScanResult{ScanID: "s1", Port: 2})
+	var data string
+	for found := false; !found; {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, found = strings.CutPrefix(strings.TrimSpace(line), "data: ")
+	}
+	var res model.ServiceScanResult
+	json.Unmarshal([]byte(data), &res)
+	if res.ScanID != "s1" || res.Port != 2 {
+		t.Errorf("streamed %+v, want only s1's result", res)
+	}
+
+	// Shutdown ends open streams.
+	p.watchers.closeAll()
+	ended := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, r)
+		ended <- err
+	}()
+	select {
+	case err := <-ended:
+		if err != nil {
+			t.Errorf("stream ended with %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("stream still open after closeAll")
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	s, err := openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put(model.ServiceScanResult{ScanID: "a", IP: "10.0.0.1", Port: 22})
+	s.Put(model.ServiceScanResult{ScanID: "a", IP: "10.0.0.1", Port: 80})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A later run sees what this one wrote.
+	s, err = openFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	got, total, _ := s.Search("a", 1)
+	if total != 2 || len(got) != 1 || got[0].Port != 80 {
+		t.Errorf("reopened store: total %d, newest %+v", total, got)
+	}
+}
@@ -0,0 +1,54 @@
+// Command exploravis runs Exploravis tooling that doesn't belong to a single
+// worker. For now that is dev mode:
+//
+//	exploravis dev [--store.kind file] [--geoip.city_db GeoLite2-City.mmdb]
+//
+// runs the orchestrator's scan API, scanner, banner grabber, enricher and a
+// results store in one process, joined by channels instead of Kafka and
+// with a memory or file store in place of Elasticsearch. It needs no outside
+// services, so the whole flow can be tried on a laptop:
+//
+//	curl -XPOST localhost:8089/scan -d '{"ip_range":"127.0.0.1/32","ports":"22,80"}'
+//	curl localhost:8089/scan/<scan_id>/stream
+//	curl 'localhost:8089/scans?scan_id=<scan_id>'
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/exploravis/config"
+	"github.com/joho/godotenv"
+)
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: exploravis dev [flags]")
+	fmt.Fprintln(os.Stderr, "run `exploravis dev -h` for the dev mode flags")
+}
+
+func main() {
+	_ = godotenv.Load()
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "dev":
+		runDev(os.Args[2:])
+	default:
+		usage()
+		log.Fatalf("unknown command %q", os.Args[1])
+	}
+}
+
+func loadDevConfig(cfg *DevConfig, args []string) error {
+	err := config.Load("exploravis dev", cfg, args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	return err
+}
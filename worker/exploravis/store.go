@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/exploravis/model"
+)
+
+// Store stands in for Elasticsearch in dev mode: the sink stage puts every
+// enriched result in it and the API searches it.
+type Store interface {
+	Put(res model.ServiceScanResult) error
+	// Search returns the newest results first, at most limit of them, and
+	// how many matched in total. An empty scanID matches every scan.
+	Search(scanID string, limit int) ([]model.ServiceScanResult, int, error)
+	Close() error
+}
+
+// newStore opens the store named by cfg.Kind.
+func newStore(cfg StoreConfig) (Store, error) {
+	switch cfg.Kind {
+	case "memory":
+		return &memoryStore{}, nil
+	case "file":
+		return openFileStore(cfg.Path)
+	}
+	return nil, fmt.Errorf("unknown store %q", cfg.Kind)
+}
+
+// memoryStore keeps results for the life of the process.
+type memoryStore struct {
+	mu      sync.RWMutex
+	results []model.ServiceScanResult
+}
+
+func (s *memoryStore) Put(res model.ServiceScanResult) error {
+	s.mu.Lock()
+	s.results = append(s.results, res)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *memoryStore) Search(scanID string, limit int) ([]model.ServiceScanResult, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := []model.ServiceScanResult{}
+	total := 0
+	for i := len(s.results) - 1; i >= 0; i-- {
+		res := s.results[i]
+		if scanID != "" && res.ScanID != scanID {
+			continue
+		}
+		total++
+		if len(out) < limit {
+			out = append(out, res)
+		}
+	}
+	return out, total, nil
+}
+
+func (s *memoryStore) Close() error { return nil }
+
+// fileStore appends results to a JSON lines file and serves searches from
+// memory. Reopening the file loads what earlier runs wrote.
+type fileStore struct {
+	memoryStore
+	f *os.File
+}
+
+func openFileStore(path string) (*fileStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &fileStore{f: f}
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var res model.ServiceScanResult
+		if err := model.Unmarshal([]byte(line), &res); err != nil {
+			log.Printf("[WARN] Skipping unreadable line in %s: %v", path, err)
+			continue
+		}
+		s.results = append(s.results, res)
+	}
+	if err := sc.Err(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	log.Printf("[INFO] Loaded %d results from %s", len(s.results), path)
+	return s, nil
+}
+
+func (s *fileStore) Put(res model.ServiceScanResult) error {
+	line, err := model.Marshal(&res, model.EncodingJSON)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.results = append(s.results, res)
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	return s.f.Close()
+}
@@ -0,0 +1,148 @@
+// Package health answers the workers' Kubernetes probes, next to /metrics.
+//
+// /healthz is liveness: it fails once the worker has stopped making
+// progress, which only a restart fixes. The poll loop calls Beat as it works
+// and Idle before polling, the one wait that may rightly last forever, on an
+// empty topic. Anything else it waits on, such as room in the job queue or a
+// transaction's acks, depends on the workers, which call Progress as they
+// finish jobs: a slow pool keeps liveness up, a wedged one fails it.
+//
+// /readyz is readiness: it fails until the loop first polls, then while any
+// registered check does, e.g. while the consumer is out of its group or a
+// dependency is unreachable.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Check reports an error while a dependency is unavailable.
+type Check func(ctx context.Context) error
+
+// checkTimeout bounds each readiness check; probes time out after a second
+// by default, so a slow dependency counts as down.
+const checkTimeout = 800 * time.Millisecond
+
+var (
+	mu     sync.Mutex
+	checks = map[string]Check{}
+
+	lastBeat atomic.Int64 // unix nanoseconds
+	idle     atomic.Bool
+	started  atomic.Bool // set by the first Idle
+)
+
+func init() {
+	Beat()
+}
+
+// Register adds a readiness check under name, replacing any of that name.
+func Register(name string, c Check) {
+	mu.Lock()
+	defer mu.Unlock()
+	checks[name] = c
+}
+
+// Beat records that the loop made progress.
+func Beat() {
+	idle.Store(false)
+	lastBeat.Store(time.Now().UnixNano())
+}
+
+// Progress records that a worker finished a job. Unlike Beat it leaves an
+// Idle poll idle, so workers call it concurrently with the loop.
+func Progress() {
+	lastBeat.Store(time.Now().UnixNano())
+}
+
+// Idle marks the loop as polling for records, which is not a stall however
+// long it lasts. The next Beat ends it.
+func Idle() {
+	lastBeat.Store(time.Now().UnixNano())
+	idle.Store(true)
+	started.Store(true)
+}
+
+// Routes returns the probe handlers for metrics.Serve. Liveness fails after
+// stall without a Beat or Progress while not idle.
+func Routes(stall time.Duration) func(*http.ServeMux) {
+	return func(mux *http.ServeMux) {
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			since := time.Since(time.Unix(0, lastBeat.Load()))
+			status := http.StatusOK
+			body := map[string]any{"status": "ok", "idle": idle.Load(), "last_beat_seconds": since.Seconds()}
+			if !idle.Load() && since > stall {
+				status = http.StatusServiceUnavailable
+				body["status"] = "stalled"
+			}
+			writeJSON(w, status, body)
+		})
+		mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+			if !started.Load() {
+				writeJSON(w, http.StatusServiceUnavailable, map[string]any{"status": "starting"})
+				return
+			}
+			results := runChecks(r.Context())
+			status := http.StatusOK
+			body := map[string]any{"status": "ok", "checks": results}
+			for _, res := range results {
+				if res != "ok" {
+					status = http.StatusServiceUnavailable
+					body["status"] = "unavailable"
+				}
+			}
+			writeJSON(w, status, body)
+		})
+	}
+}
+
+// runChecks runs every check concurrently and returns "ok" or the error
+// for each.
+func runChecks(ctx context.Context) map[string]string {
+	mu.Lock()
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fns := make([]Check, len(names))
+	for i, name := range names {
+		fns[i] = checks[name]
+	}
+	mu.Unlock()
+
+	out := make([]string, len(names))
+	var wg sync.WaitGroup
+	for i, check := range fns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			if err := check(ctx); err != nil {
+				out[i] = err.Error()
+			} else {
+				out[i] = "ok"
+			}
+		}()
+	}
+	wg.Wait()
+
+	results := make(map[string]string, len(names))
+	for i, name := range names {
+		results[name] = out[i]
+	}
+	return results
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,107 @@
+// Package metrics holds the Prometheus metrics shared by the workers and
+// serves them on /metrics.
+package metrics
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "exploravis"
+
+var (
+	QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_queue_depth",
+		Help:      "Jobs waiting in the worker's in-memory queue.",
+	})
+	QueueCapacity = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_queue_capacity",
+		Help:      "Size of the worker's in-memory queue.",
+	})
+	PausedPartitions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_paused_partitions",
+		Help:      "Partitions whose fetching is paused by backpressure.",
+	})
+	FetchPaused = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "worker_fetch_paused",
+		Help:      "1 while backpressure has paused fetching, 0 otherwise.",
+	})
+	FetchPauses = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "worker_fetch_pauses_total",
+		Help:      "Times backpressure paused fetching.",
+	})
+
+	RecordsConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_consumed_total",
+		Help:      "Records fetched from Kafka, by topic.",
+	}, []string{"topic"})
+	RecordsProduced = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "records_produced_total",
+		Help:      "Records produced to Kafka, by topic and outcome (ok or error).",
+	}, []string{"topic", "outcome"})
+	DeadLettered = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_lettered_total",
+		Help:      "Records sent to a dead-letter topic, by stage and reason.",
+	}, []string{"stage", "reason"})
+	Transactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "transactions_total",
+		Help:      "Exactly-once batches ended, by outcome (committed or aborted).",
+	}, []string{"outcome"})
+)
+
+// Consumed counts n records fetched from topic.
+func Consumed(topic string, n int) {
+	RecordsConsumed.WithLabelValues(topic).Add(float64(n))
+}
+
+// Produced counts one delivery to topic, successful or not.
+func Produced(topic string, err error) {
+	RecordsProduced.WithLabelValues(topic, Outcome(err)).Inc()
+}
+
+// TxnEnded counts one transaction ended, committed or not.
+func TxnEnded(committed bool) {
+	outcome := "aborted"
+	if committed {
+		outcome = "committed"
+	}
+	Transactions.WithLabelValues(outcome).Inc()
+}
+
+// Outcome is the outcome label for err: "ok" or "error".
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Serve exposes /metrics on addr in the background, along with whatever
+// routes adds, e.g. the health probes.
+func Serve(addr string, routes ...func(*http.ServeMux)) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	for _, add := range routes {
+		add(mux)
+	}
+	go func() {
+		log.Printf("[INFO] Serving metrics on %s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("[WARN] Metrics server stopped: %v", err)
+		}
+	}()
+}
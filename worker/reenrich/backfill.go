@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/exploravis/model"
+)
+
+// checkpoint is the progress saved after each batch. After holds the sort
+// values of the last document done; the next page starts after it.
+type checkpoint struct {
+	Selection string            `json:"selection"`
+	After     []json.RawMessage `json:"after,omitempty"`
+	Scanned   int               `json:"scanned"`
+	Updated   int               `json:"updated"`
+	Unchanged int               `json:"unchanged"`
+	Conflicts int               `json:"conflicts"`
+	Failed    int               `json:"failed"`
+	Finished  bool              `json:"finished"`
+	SavedAt   time.Time         `json:"saved_at"`
+}
+
+// loadCheckpoint returns nil when there is no checkpoint yet.
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("checkpoint %s is corrupt: %w", path, err)
+	}
+	return &cp, nil
+}
+
+// save replaces the checkpoint file in one rename, so a crash leaves either
+// the old position or the new one.
+func (c *checkpoint) save(path string) error {
+	c.SavedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// backfillSort is the history document key plus the timestamp, so pages
+// are stable without a scroll context that would expire between runs.
+var backfillSort = []any{
+	map[string]any{"timestamp": "asc"},
+	map[string]any{"scan_id.keyword": "asc"},
+	map[string]any{"ip.keyword": "asc"},
+	map[string]any{"port": "asc"},
+	map[string]any{"protocol.keyword": "asc"},
+}
+
+// replaceMeta swaps meta wholesale; a partial "doc" update would merge and
+// keep fields the current enricher no longer sets.
+const replaceMeta = "ctx._source.meta = params.meta"
+
+// enrichedMeta are the meta keys the enricher owns. They are dropped before
+// enriching again, so an IP the current databases no longer know loses them
+// rather than keeping the stale ones.
+var enrichedMeta = []string{"geo", "asn", "hostname"}
+
+// withoutEnrichedMeta returns src with a copy of its meta minus the
+// enricher's keys.
+func withoutEnrichedMeta(src model.ServiceScanResult) model.ServiceScanResult {
+	meta := make(map[string]any, len(src.Meta))
+	for k, v := range src.Meta {
+		meta[k] = v
+	}
+	for _, k := range enrichedMeta {
+		delete(meta, k)
+	}
+	src.Meta = meta
+	return src
+}
+
+type backfill struct {
+	cfg      Config
+	es       *elasticsearch.Client
+	enricher metaEnricher
+	cp       checkpoint
+}
+
+// metaEnricher is the part of enrich.Enricher a backfill uses.
+type metaEnricher interface {
+	Enrich(ctx context.Context, src model.ServiceScanResult) (model.ServiceScanResult, error)
+}
+
+type backfillHit struct {
+	Index       string                  `json:"_index"`
+	ID          string                  `json:"_id"`
+	SeqNo       *int64                  `json:"_seq_no"`
+	PrimaryTerm *int64                  `json:"_primary_term"`
+	Source      model.ServiceScanResult `json:"_source"`
+	Sort        []json.RawMessage       `json:"sort"`
+}
+
+// update is one document whose meta changed.
+type update struct {
+	hit  backfillHit
+	meta map[string]any
+}
+
+func (b *backfill) query() map[string]any {
+	var filters []any
+	if b.cfg.ScanID != "" {
+		filters = append(filters, map[string]any{"term": map[string]any{"scan_id.keyword": b.cfg.ScanID}})
+	}
+	if b.cfg.Query != "" {
+		filters = append(filters, map[string]any{"query_string": map[string]any{"query": b.cfg.Query}})
+	}
+	if len(filters) == 0 {
+		return map[string]any{"match_all": map[string]any{}}
+	}
+	return map[string]any{"bool": map[string]any{"filter": filters}}
+}
+
+// run processes pages until the index is exhausted or ctx is done. A batch
+// that started is always finished, so the checkpoint matches the index.
+func (b *backfill) run(ctx context.Context) error {
+	log.Printf("[INFO] Backfilling %s (dry-run: %v, rate: %v/s)", b.cfg.Index, b.cfg.DryRun, b.cfg.Rate)
+	start := time.Now()
+	done := 0
+	for ctx.Err() == nil {
+		hits, err := b.page()
+		if err != nil {
+			return err
+		}
+		if len(hits) == 0 {
+			b.cp.Finished = true
+			return b.save()
+		}
+
+		var updates []update
+		for _, hit := range hits {
+			before, _ := json.Marshal(hit.Source.Meta)
+			enriched, err := b.enricher.Enrich(context.Background(), withoutEnrichedMeta(hit.Source))
+			if err != nil {
+				log.Printf("[WARN] Enriching %s/%s failed: %v", hit.Index, hit.ID, err)
+				b.cp.Failed++
+				continue
+			}
+			after, _ := json.Marshal(enriched.Meta)
+			if bytes.Equal(before, after) {
+				b.cp.Unchanged++
+				continue
+			}
+			updates = append(updates, update{hit: hit, meta: enriched.Meta})
+		}
+
+		if b.cfg.DryRun {
+			b.cp.Updated += len(updates)
+		} else if err := b.write(updates); err != nil {
+			// Not checkpointed: the next run redoes this batch, which is
+			// harmless since the updates are idempotent.
+			return err
+		}
+
+		b.cp.Scanned += len(hits)
+		b.cp.After = hits[len(hits)-1].Sort
+		if err := b.save(); err != nil {
+			return err
+		}
+		log.Printf("[INFO] %d scanned, %d updated, %d unchanged", b.cp.Scanned, b.cp.Updated, b.cp.Unchanged)
+
+		done += len(hits)
+		throttle(ctx, start, done, b.cfg.Rate)
+	}
+	return nil
+}
+
+// save writes the checkpoint, except on dry runs, which must not move a
+// real run's position.
+func (b *backfill) save() error {
+	if b.cfg.DryRun {
+		return nil
+	}
+	if err := b.cp.save(b.cfg.Checkpoint); err != nil {
+		return fmt.Errorf("saving checkpoint: %w", err)
+	}
+	return nil
+}
+
+func (b *backfill) page() ([]backfillHit, error) {
+	body := map[string]any{
+		"size":                b.cfg.Batch,
+		"query":               b.query(),
+		"sort":                backfillSort,
+		"seq_no_primary_term": true,
+	}
+	if len(b.cp.After) > 0 {
+		body["search_after"] = b.cp.After
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var out struct {
+		Hits struct {
+			Hits []backfillHit `json:"hits"`
+		} `json:"hits"`
+	}
+	res, err := b.es.Search(
+		b.es.Search.WithContext(ctx),
+		b.es.Search.WithIndex(b.cfg.Index),
+		b.es.Search.WithBody(jsonBody(body)),
+	)
+	if err := esDecode(res, err, &out); err != nil {
+		return nil, fmt.Errorf("searching %s: %w", b.cfg.Index, err)
+	}
+	return out.Hits.Hits, nil
+}
+
+// write bulk-updates the batch. Each update only applies if the document is
+// unchanged since it was read; a conflict means something newer was written
+// meanwhile, by the pipeline with the current enricher, so it is skipped.
+// Throttling and server errors fail the batch so it is retried; anything
+// else is counted and logged.
+func (b *backfill) write(updates []update) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, u := range updates {
+		action := map[string]any{"_index": u.hit.Index, "_id": u.hit.ID}
+		if u.hit.SeqNo != nil && u.hit.PrimaryTerm != nil {
+			action["if_seq_no"] = *u.hit.SeqNo
+			action["if_primary_term"] = *u.hit.PrimaryTerm
+		}
+		enc.Encode(map[string]any{"update": action})
+		enc.Encode(map[string]any{
+			"script": map[string]any{
+				"lang":   "painless",
+				"source": replaceMeta,
+				"params": map[string]any{"meta": u.meta},
+			},
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	var out struct {
+		Items []map[string]struct {
+			ID     string          `json:"_id"`
+			Status int             `json:"status"`
+			Error  json.RawMessage `json:"error"`
+		} `json:"items"`
+	}
+	res, err := b.es.Bulk(&buf, b.es.Bulk.WithContext(ctx))
+	if err := esDecode(res, err, &out); err != nil {
+		return fmt.Errorf("bulk update: %w", err)
+	}
+
+	var updated, conflicts, failed int
+	for _, item := range out.Items {
+		res := item["update"]
+		switch {
+		case res.Status < 300:
+			updated++
+		case res.Status == 409:
+			conflicts++
+		case res.Status == 429 || res.Status >= 500:
+			return fmt.Errorf("bulk update of %s: status %d: %s", res.ID, res.Status, res.Error)
+		default:
+			log.Printf("[WARN] Update of %s failed: status %d: %s", res.ID, res.Status, res.Error)
+			failed++
+		}
+	}
+	b.cp.Updated += updated
+	b.cp.Conflicts += conflicts
+	b.cp.Failed += failed
+	return nil
+}
+
+// esDecode closes res, turns ES errors into Go errors and decodes the body
+// into out.
+func esDecode(res *esapi.Response, err error, out any) error {
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("%s", res.String())
+	}
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+func jsonBody(v any) *bytes.Reader {
+	data, _ := json.Marshal(v)
+	return bytes.NewReader(data)
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/exploravis/model"
+)
+
+// fakeES serves _search over docs, sorted by their position, and _bulk.
+type fakeES struct {
+	mu       sync.Mutex
+	docs     []model.ServiceScanResult
+	status   map[string]int // bulk status by _id, 200 if unset
+	searches int
+	updates  []map[string]any // update actions received
+	metas    map[string]any   // meta written by _id
+}
+
+func docID(i int) string { return fmt.Sprintf("d%d", i) }
+
+func (f *fakeES) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("X-Elastic-Product", "Elasticsearch")
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/_search"):
+		f.searches++
+		var body struct {
+			Size        int   `json:"size"`
+			SearchAfter []int `json:"search_after"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		from := 0
+		if len(body.SearchAfter) > 0 {
+			from = body.SearchAfter[0] + 1
+		}
+		hits := []map[string]any{}
+		for i := from; i < len(f.docs) && len(hits) < body.Size; i++ {
+			hits = append(hits, map[string]any{
+				"_index": "scans-000001", "_id": docID(i), "_seq_no": i, "_primary_term": 1,
+				"_source": f.docs[i], "sort": []int{i},
+			})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"hits": map[string]any{"hits": hits}})
+
+	case r.URL.Path == "/_bulk":
+		var items []map[string]any
+		sc := bufio.NewScanner(r.Body)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			var action map[string]map[string]any
+			json.Unmarshal(sc.Bytes(), &action)
+			sc.Scan()
+			var doc struct {
+				Script struct {
+					Source string         `json:"source"`
+					Params map[string]any `json:"params"`
+				} `json:"script"`
+			}
+			json.Unmarshal(sc.Bytes(), &doc)
+
+			u := action["update"]
+			f.updates = append(f.updates, u)
+			id := u["_id"].(string)
+			status := 200
+			if s, ok := f.status[id]; ok {
+				status = s
+			}
+			if status < 300 && doc.Script.Source == replaceMeta {
+				f.metas[id] = doc.Script.Params["meta"]
+			}
+			items = append(items, map[string]any{"update": map[string]any{"_id": id, "status": status}})
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": items})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// fakeEnricher fills in meta like enrich.Enricher: it keeps what is there
+// and only sets geo when the IP is known. It sets the country to DE, knows
+// nothing about 192.0.2.50 and fails for 192.0.2.99.
+type fakeEnricher struct{}
+
+func (fakeEnricher) Enrich(_ context.Context, r model.ServiceScanResult) (model.ServiceScanResult, error) {
+	switch r.IP {
+	case "192.0.2.99":
+		return r, errors.New("lookup failed")
+	case "192.0.2.50":
+	default:
+		if r.Meta == nil {
+			r.Meta = map[string]any{}
+		}
+		r.Meta["geo"] = map[string]any{"country": "DE"}
+	}
+	return r, nil
+}
+
+func enriched() map[string]any {
+	return map[string]any{"geo": map[string]any{"country": "DE"}}
+}
+
+// newBackfill serves five documents: d1 is already enriched, d3 fails to
+// enrich and d4's update conflicts.
+func newBackfill(t *testing.T, cfg Config) (*backfill, *fakeES) {
+	t.Helper()
+	f := &fakeES{status: map[string]int{"d4": 409}, metas: map[string]any{}}
+	for i := range 5 {
+		doc := model.ServiceScanResult{ScanID: "s1", IP: fmt.Sprintf("192.0.2.%d", i), Port: 80}
+		switch i {
+		case 1:
+			doc.Meta = enriched()
+		case 3:
+			doc.IP = "192.0.2.99"
+		}
+		f.docs = append(f.docs, doc)
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	es, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{srv.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Index == "" {
+		cfg.Index = "scans-read"
+	}
+	if cfg.Batch == 0 {
+		cfg.Batch = 2
+	}
+	cfg.Checkpoint = filepath.Join(t.TempDir(), "reenrich.checkpoint")
+	return &backfill{cfg: cfg, es: es, enricher: fakeEnricher{}, cp: checkpoint{Selection: cfg.selection()}}, f
+}
+
+func TestBackfillRun(t *testing.T) {
+	b, f := newBackfill(t, Config{})
+	if err := b.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	want := checkpoint{Scanned: 5, Updated: 2, Unchanged: 1, Conflicts: 1, Failed: 1, Finished: true}
+	got := b.cp
+	if got.Scanned != want.Scanned || got.Updated != want.Updated || got.Unchanged != want.Unchanged ||
+		got.Conflicts != want.Conflicts || got.Failed != want.Failed || !got.Finished {
+		t.Errorf("checkpoint = %+v, want counts %+v", got, want)
+	}
+	if f.searches != 4 {
+		t.Errorf("%d searches, want 3 pages and an empty one", f.searches)
+	}
+	if len(f.updates) != 3 {
+		t.Fatalf("%d updates sent, want d0, d2 and d4", len(f.updates))
+	}
+	for _, u := range f.updates {
+		if u["_index"] != "scans-000001" || u["if_seq_no"] == nil || u["if_primary_term"] == nil {
+			t.Errorf("update action %v lacks its index or concurrency check", u)
+		}
+	}
+	for _, id := range []string{"d0", "d2"} {
+		if !reflect.DeepEqual(f.metas[id], enriched()) {
+			t.Errorf("%s meta = %v", id, f.metas[id])
+		}
+	}
+
+	saved, err := loadCheckpoint(b.cfg.Checkpoint)
+	if err != nil || saved == nil {
+		t.Fatalf("checkpoint not saved: %v", err)
+	}
+	if !saved.Finished || saved.Scanned != 5 || string(saved.After[0]) != "4" {
+		t.Errorf("saved checkpoint = %+v", saved)
+	}
+}
+
+// An IP dropped from the databases loses the geo, asn and hostname it had;
+// other meta stays.
+func TestBackfillDropsStaleMeta(t *testing.T) {
+	b, f := newBackfill(t, Config{})
+	f.docs = []model.ServiceScanResult{{ScanID: "s1", IP: "192.0.2.50", Port: 80, Meta: map[string]any{
+		"geo":        map[string]any{"country": "RU"},
+		"asn":        map[string]any{"number": 64500},
+		"hostname":   "stale.example",
+		"bytes_read": 12,
+	}}}
+	if err := b.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"bytes_read": float64(12)}
+	if b.cp.Updated != 1 || !reflect.DeepEqual(f.metas["d0"], want) {
+		t.Errorf("updated %d, meta = %v, want %v", b.cp.Updated, f.metas["d0"], want)
+	}
+}
+
+func TestBackfillResume(t *testing.T) {
+	b, f := newBackfill(t, Config{})
+	b.cp.After = []json.RawMessage{json.RawMessage("2")}
+	b.cp.Scanned = 3
+	if err := b.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b.cp.Scanned != 5 || b.cp.Failed != 1 || b.cp.Conflicts != 1 {
+		t.Errorf("checkpoint = %+v, want only d3 and d4 done", b.cp)
+	}
+	if len(f.updates) != 1 || f.updates[0]["_id"] != "d4" {
+		t.Errorf("updates = %v, want d4 only", f.updates)
+	}
+}
+
+func TestBackfillDryRun(t *testing.T) {
+	b, f := newBackfill(t, Config{DryRun: true})
+	if err := b.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.updates) != 0 {
+		t.Errorf("dry run sent %d updates", len(f.updates))
+	}
+	if b.cp.Updated != 3 || b.cp.Unchanged != 1 {
+		t.Errorf("checkpoint = %+v, want 3 that would change", b.cp)
+	}
+	if _, err := os.Stat(b.cfg.Checkpoint); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("dry run saved a checkpoint: %v", err)
+	}
+}
+
+func TestBackfillRetriesThrottledBatch(t *testing.T) {
+	b, f := newBackfill(t, Config{})
+	f.status["d2"] = 429
+	err := b.run(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "status 429") {
+		t.Fatalf("run = %v, want the throttled batch to fail", err)
+	}
+	saved, _ := loadCheckpoint(b.cfg.Checkpoint)
+	if saved == nil || saved.Scanned != 2 || string(saved.After[0]) != "1" {
+		t.Errorf("saved checkpoint = %+v, want the position before the failed batch", saved)
+	}
+}
+
+func TestBackfillStopsBetweenBatches(t *testing.T) {
+	b, f := newBackfill(t, Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if f.searches != 0 || b.cp.Finished {
+		t.Errorf("a canceled run searched %d times, finished %v", f.searches, b.cp.Finished)
+	}
+}
+
+func TestBackfillQuery(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want string
+	}{
+		{"everything", Config{}, `{"match_all":{}}`},
+		{"scan", Config{ScanID: "s1"}, `{"bool":{"filter":[{"term":{"scan_id.keyword":"s1"}}]}}`},
+		{"query", Config{Query: "port:22"}, `{"bool":{"filter":[{"query_string":{"query":"port:22"}}]}}`},
+		{"both", Config{ScanID: "s1", Query: "port:22"}, `{"bool":{"filter":[{"term":{"scan_id.keyword":"s1"}},{"query_string":{"query":"port:22"}}]}}`},
+	}
+	for _, tt := range tests {
+		b := &backfill{cfg: tt.cfg}
+		got, _ := json.Marshal(b.query())
+		if string(got) != tt.want {
+			t.Errorf("%s: query = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckpointFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cp")
+
+	if cp, err := loadCheckpoint(path); cp != nil || err != nil {
+		t.Errorf("missing checkpoint = %v, %v; want nil, nil", cp, err)
+	}
+
+	cp := &checkpoint{Selection: "sel", After: []json.RawMessage{json.RawMessage("1717243200"), json.RawMessage(`"s1"`)}, Scanned: 10, Updated: 4}
+	if err := cp.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("temporary file left behind")
+	}
+	got, err := loadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Selection != "sel" || got.Scanned != 10 || got.Updated != 4 || len(got.After) != 2 || string(got.After[1]) != `"s1"` || got.SavedAt.IsZero() {
+		t.Errorf("loaded %+v", got)
+	}
+
+	os.WriteFile(path, []byte("{not json"), 0o644)
+	if _, err := loadCheckpoint(path); err == nil || !strings.Contains(err.Error(), "corrupt") {
+		t.Errorf("corrupt checkpoint = %v", err)
+	}
+}
+
+func TestSelection(t *testing.T) {
+	base := Config{Index: "scans-read", Query: "q", ScanID: "s"}
+	for _, other := range []Config{
+		{Index: "scans-latest", Query: "q", ScanID: "s"},
+		{Index: "scans-read", Query: "q2", ScanID: "s"},
+		{Index: "scans-read", Query: "q", ScanID: ""},
+		{Index: "scans-read", Query: "q\x00s", ScanID: ""},
+	} {
+		if other.selection() == base.selection() {
+			t.Errorf("%+v has the same selection as %+v", other, base)
+		}
+	}
+	same := base
+	same.Rate, same.Batch, same.DryRun = 5, 10, true
+	if same.selection() != base.selection() {
+		t.Error("rate, batch or dry_run changed the selection")
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		rate     float64
+		cancel   bool
+		min, max time.Duration
+	}{
+		{name: "unthrottled", n: 1000, rate: 0, max: 50 * time.Millisecond},
+		{name: "within rate", n: 1, rate: 1000, max: 50 * time.Millisecond},
+		{name: "ahead of rate", n: 10, rate: 200, min: 40 * time.Millisecond, max: time.Second},
+		{name: "canceled", n: 1000, rate: 1, cancel: true, max: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			start := time.Now()
+			throttle(ctx, start, tt.n, tt.rate)
+			if took := time.Since(start); took < tt.min || took > tt.max {
+				t.Errorf("throttle took %s, want %s to %s", took, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	valid := Config{Index: "scans-read", Batch: 500, Rate: 0, Checkpoint: "cp"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("valid config: %v", err)
+	}
+	for _, bad := range []func(*Config){
+		func(c *Config) { c.Index = "" },
+		func(c *Config) { c.Batch = 0 },
+		func(c *Config) { c.Batch = 10001 },
+		func(c *Config) { c.Rate = -1 },
+		func(c *Config) { c.Checkpoint = "" },
+	} {
+		c := valid
+		bad(&c)
+		if c.Validate() == nil {
+			t.Errorf("%+v passed validation", c)
+		}
+	}
+}
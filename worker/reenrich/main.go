@@ -0,0 +1,147 @@
+// Command reenrich runs already-indexed scan results through the current
+// enricher again, for when a new MaxMind database comes out or an enricher
+// is added. Each document's meta is recomputed from its IP and written back
+// with a bulk update; documents whose meta comes out the same are skipped.
+//
+// It pages through the index sorted on the history document key, saving the
+// position in -checkpoint after every batch, so an interrupted run picks up
+// where it stopped. -rate caps documents per second so a backfill doesn't
+// starve the live indexer.
+//
+//	reenrich -dry_run
+//	reenrich -scan_id 3f2a... -rate 200
+//	reenrich -query_string 'meta.geo.country:RU OR NOT _exists_:meta.geo' -index scans-latest
+//
+// -query_string is Elasticsearch query_string syntax over the stored fields,
+// not the /scans q language.
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/exploravis/config"
+	"github.com/exploravis/worker/enrich-meta-worker/enrich"
+)
+
+// Config is the backfill selection. Every field is also a flag named after
+// its yaml key, e.g. -scan_id.
+type Config struct {
+	Elastic    config.Elastic `yaml:"elastic"`
+	GeoIP      GeoIPConfig    `yaml:"geoip"`
+	Index      string         `yaml:"index" default:"scans-read" usage:"index or alias to backfill (scans-read covers the whole history)"`
+	Query      string         `yaml:"query_string" usage:"only backfill documents matching this Elasticsearch query_string query"`
+	ScanID     string         `yaml:"scan_id" usage:"only backfill documents of this scan"`
+	Batch      int            `yaml:"batch" default:"500" usage:"documents per search page and bulk request"`
+	Rate       float64        `yaml:"rate" default:"1000" usage:"most documents per second (0 = unthrottled)"`
+	Checkpoint string         `yaml:"checkpoint" default:"reenrich.checkpoint" usage:"file the position is saved to after each batch"`
+	Restart    bool           `yaml:"restart" usage:"ignore an existing checkpoint and start from the beginning"`
+	DryRun     bool           `yaml:"dry_run" usage:"count what would change without writing"`
+}
+
+// GeoIPConfig points at the MaxMind databases, under the same variables as
+// enrich-meta-worker so a backfill uses what the pipeline uses.
+type GeoIPConfig struct {
+	CityDB string `yaml:"city_db" env:"MAXMIND_CITY_DB" usage:"path to GeoLite2-City.mmdb" required:"true"`
+	ASNDB  string `yaml:"asn_db" env:"MAXMIND_ASN_DB" usage:"path to GeoLite2-ASN.mmdb" required:"true"`
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Index == "" {
+		errs = append(errs, errors.New("index is required"))
+	}
+	if c.Batch < 1 || c.Batch > 10000 {
+		errs = append(errs, errors.New("batch must be between 1 and 10000"))
+	}
+	if c.Rate < 0 {
+		errs = append(errs, errors.New("rate must not be negative"))
+	}
+	if c.Checkpoint == "" {
+		errs = append(errs, errors.New("checkpoint is required"))
+	}
+	return errors.Join(errs...)
+}
+
+// selection identifies what a checkpoint was taken over, so one is never
+// resumed with different filters.
+func (c *Config) selection() string {
+	sum := sha1.Sum([]byte(c.Index + "\x00" + c.Query + "\x00" + c.ScanID))
+	return hex.EncodeToString(sum[:])
+}
+
+func main() {
+	var cfg Config
+	config.MustLoad("reenrich", &cfg)
+
+	es, err := elasticsearch.NewClient(elasticsearch.Config{
+		Addresses: []string{cfg.Elastic.URL},
+	})
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to create ES client: %v", err)
+	}
+
+	enricher, err := enrich.NewEnricher(cfg.GeoIP.CityDB, cfg.GeoIP.ASNDB)
+	if err != nil {
+		log.Fatalf("[ERROR] Enricher init failed: %v", err)
+	}
+	defer enricher.Close()
+
+	cp := checkpoint{Selection: cfg.selection()}
+	if !cfg.Restart {
+		saved, err := loadCheckpoint(cfg.Checkpoint)
+		switch {
+		case err != nil:
+			log.Fatalf("[ERROR] %v", err)
+		case saved == nil:
+		case saved.Selection != cp.Selection:
+			log.Fatalf("[ERROR] %s was saved for a different index, query_string or scan_id; pass -restart or another -checkpoint", cfg.Checkpoint)
+		case saved.Finished:
+			log.Printf("[INFO] %s says this backfill already finished; pass -restart to run it again", cfg.Checkpoint)
+			return
+		default:
+			cp = *saved
+			log.Printf("[INFO] Resuming after %d documents (%d updated)", cp.Scanned, cp.Updated)
+		}
+	}
+
+	// Stop between batches, so the checkpoint always matches what was
+	// written.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b := &backfill{cfg: cfg, es: es, enricher: enricher, cp: cp}
+	if err := b.run(ctx); err != nil {
+		log.Fatalf("[ERROR] Backfill stopped after %d documents: %v", b.cp.Scanned, err)
+	}
+	if ctx.Err() != nil {
+		log.Printf("[INFO] Interrupted after %d documents; run again to resume", b.cp.Scanned)
+		return
+	}
+	log.Printf("[INFO] Backfill complete: scanned %d, updated %d, unchanged %d, conflicts %d, failed %d (dry-run: %v)",
+		b.cp.Scanned, b.cp.Updated, b.cp.Unchanged, b.cp.Conflicts, b.cp.Failed, cfg.DryRun)
+}
+
+// throttle sleeps until n documents since start are within rate.
+func throttle(ctx context.Context, start time.Time, n int, rate float64) {
+	if rate <= 0 {
+		return
+	}
+	due := start.Add(time.Duration(float64(n) / rate * float64(time.Second)))
+	wait := time.Until(due)
+	if wait <= 0 {
+		return
+	}
+	select {
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+}
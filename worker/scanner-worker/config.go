@@ -0,0 +1,23 @@
+package main
+
+import "github.com/exploravis/config"
+
+type Config struct {
+	Bus      config.Bus      `yaml:"bus"`
+	Kafka    config.Kafka    `yaml:"kafka"`
+	Consumer config.Consumer `yaml:"consumer"`
+	Producer config.Producer `yaml:"producer"`
+	Control  config.Control  `yaml:"control"`
+	Workers  config.Workers  `yaml:"workers"`
+	Runtime  config.Runtime  `yaml:"runtime"`
+	Tracing  config.Tracing  `yaml:"tracing"`
+}
+
+func loadConfig() Config {
+	cfg := Config{
+		Consumer: config.Consumer{Topic: "ip_scan_request", Group: "scanner-group"},
+		Producer: config.Producer{Topic: "ip_scan_result"},
+	}
+	config.MustLoad("scanner-worker", &cfg)
+	return cfg
+}
@@ -2,74 +2,177 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/exploravis/bus"
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/ack"
+	"github.com/exploravis/worker/backpressure"
+	"github.com/exploravis/worker/dlq"
+	"github.com/exploravis/worker/health"
+	"github.com/exploravis/worker/metrics"
 	"github.com/exploravis/worker/scanner-worker/scanner"
+	"github.com/exploravis/worker/shutdown"
+	"github.com/exploravis/worker/tracing"
 	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/trace"
 )
 
+type scanJob struct {
+	req model.ScanRequest
+	ack *ack.Ack
+}
+
 func main() {
-	workerCount := 8
-	jobQueue := make(chan scanner.ScanRequest, 2000)
+	cfg := loadConfig()
+	workerCount := cfg.Workers.Count
+	jobQueue := make(chan scanJob, cfg.Workers.QueueSize)
+	flow := backpressure.New(func() int { return len(jobQueue) }, cap(jobQueue))
+	metrics.Serve(cfg.Runtime.MetricsAddr, health.Routes(cfg.Runtime.StallTimeout))
+	flushTraces := tracing.Init("scanner-worker", cfg.Tracing)
+
+	ctx, stop := shutdown.OnSignal()
+	defer stop()
+	go flow.Run(ctx)
+
+	// scanCtx outlives ctx: in-flight scans keep running through the drain
+	// and are only aborted if the deadline passes.
+	scanCtx, abortScans := context.WithCancel(context.Background())
+	defer abortScans()
+
+	log.Printf("[INFO] Connecting to the %s bus", cfg.Bus.Kind)
+	b, err := bus.New(cfg.Bus, cfg.Kafka)
+	if err != nil {
+		log.Fatalf("[ERROR] Unable to connect to the bus: %v", err)
+	}
+	defer b.Close()
+	deadLetters := dlq.NewWriter(b, "scanner")
 
+	tracker := ack.NewTracker(func(rec *kgo.Record, err error, done func()) {
+		deadLetters.Send(rec, dlq.ReasonFor(err), err, done)
+	})
+
+	var wg sync.WaitGroup
 	for i := range workerCount {
+		wg.Add(1)
 		go func(id int) {
+			defer wg.Done()
 			log.Printf("[WORKER %d] Started", id)
 			for job := range jobQueue {
-				log.Printf("[WORKER %d] Processing job: %s:%+v (ScanID: %s)", id, job.Cidr, job.Ports, job.ScanID)
-				scanner.RunScan(job)
-				log.Printf("[WORKER FINISHED] ScanID %s completed.", job.ScanID)
+				req := job.req
+				if scanCtx.Err() != nil {
+					// Aborted: leave it unacked for redelivery.
+					continue
+				}
+				log.Printf("[WORKER %d] Processing job: %s:%+v (ScanID: %s)", id, req.IPRange, req.Ports, req.ScanID)
+				_, span := tracing.StartProcess(job.ack.Record())
+				spanCtx := trace.ContextWithSpan(scanCtx, span)
+				// The request is acked once every found host, and then
+				// the chunk's completion, is delivered.
+				chunk := scanner.NewChunk(req)
+				err := scanner.RunScan(spanCtx, req, func(ctx context.Context, msg *model.HostPorts) {
+					health.Progress()
+					chunk.Found(msg)
+					job.ack.Add(1)
+					scanner.ProduceResult(ctx, msg, job.ack.Done)
+				})
+				if errors.Is(err, context.Canceled) {
+					tracing.End(span, err)
+					log.Printf("[WARN] ScanID %s interrupted, left unacked for redelivery", req.ScanID)
+					continue
+				}
+				if err != nil {
+					log.Printf("[ERROR] ScanID %s failed: %v", req.ScanID, err)
+				}
+				// Published after the hosts with the same key, so it
+				// follows them on their partition.
+				job.ack.Add(1)
+				scanner.ProduceChunkComplete(spanCtx, chunk.Complete(err), job.ack.Done)
+				tracing.End(span, err)
+				job.ack.Done(err)
+				health.Progress()
 			}
 			log.Printf("[WORKER %d] Exiting", id)
 		}(i)
 	}
 
-	seeds := []string{"redpanda-0.redpanda.kafka.svc.cluster.local:9093"}
-	log.Println("[INFO] Initializing Kafka producer with seeds:", seeds)
-	scanner.InitProducer(seeds)
-
-	cl, err := kgo.NewClient(
-		kgo.SeedBrokers(seeds...),
-		kgo.DialTimeout(5*time.Second),
-		kgo.ProduceRequestTimeout(5*time.Second),
-		kgo.ConsumeTopics("ip_scan_request"),
-		kgo.ConsumerGroup("scanner-group"),
-		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
-	)
+	scanner.InitProducer(b, cfg.Producer, cfg.Control)
+
+	sub, err := b.Subscribe(cfg.Consumer.Group, cfg.Consumer.Topic)
 	if err != nil {
-		log.Fatalf("[ERROR] Unable to create Kafka client: %v", err)
+		log.Fatalf("[ERROR] Unable to subscribe: %v", err)
 	}
-	defer cl.Close()
+	health.Register("bus", b.Ping)
+	health.Register("consumer_group", sub.Joined)
+	tracker.Bind(sub)
+	flow.Bind(sub)
 
-	log.Println("[INFO] Kafka consumer started on topic 'ip_scan_request'")
+	log.Printf("[INFO] Consumer started on topic '%s'", cfg.Consumer.Topic)
 
-	ctx := context.Background()
-
-	for {
-		fetches := cl.PollFetches(ctx)
-		if errs := fetches.Errors(); len(errs) > 0 {
-			for _, e := range errs {
-				log.Printf("[ERROR] Kafka fetch error: %v", e)
-			}
+	for ctx.Err() == nil {
+		// A full queue waits for a scan to finish, which the workers
+		// report as Progress; a scan runs for at most scanner.ScanTimeout.
+		room := flow.Room(ctx)
+		if room == 0 {
+			break
+		}
+		health.Idle()
+		records, err := sub.Poll(ctx, room)
+		health.Beat()
+		if errors.Is(err, bus.ErrClosed) || ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			log.Printf("[ERROR] Fetch error: %v", err)
 			time.Sleep(500 * time.Millisecond)
+		}
+		if len(records) == 0 {
 			continue
 		}
+		metrics.Consumed(cfg.Consumer.Topic, len(records))
+		log.Printf("[INFO] Processing %d records", len(records))
 
-		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
-			log.Printf("[INFO] Processing partition %s/%d with %d records", p.Topic, p.Partition, len(p.Records))
-			for _, record := range p.Records {
-				log.Printf("[INFO] Consumed message %s/%d: %s", record.Topic, record.Partition, string(record.Value))
+		for _, record := range records {
+			log.Printf("[INFO] Consumed message %s/%d: %s", record.Topic, record.Partition, string(record.Value))
 
-				var req scanner.ScanRequest
-				if err := json.Unmarshal(record.Value, &req); err != nil {
-					log.Printf("[WARN] Bad message: %v", err)
-					continue
-				}
+			health.Beat()
+			a := tracker.Track(record)
 
-				jobQueue <- req
+			var req model.ScanRequest
+			if err := model.Unmarshal(record.Value, &req); err != nil {
+				log.Printf("[WARN] Bad message: %v", err)
+				deadLetters.Send(record, dlq.ReasonDecode, err, func() { a.Done(nil) })
+				continue
 			}
-		})
+
+			// Never waits: the poll was sized to the queue's room, and
+			// only this loop sends.
+			jobQueue <- scanJob{req: req, ack: a}
+			flow.Admit(record.Topic, record.Partition)
+		}
 	}
+
+	log.Printf("[INFO] Shutdown signal received, draining %d queued scans (deadline %s)", len(jobQueue), cfg.Runtime.ShutdownTimeout)
+	workCtx, finalCtx, cancel := shutdown.Deadlines(cfg.Runtime.ShutdownTimeout)
+	defer cancel()
+
+	// The poll loop was the only sender.
+	close(jobQueue)
+	if !shutdown.Wait(workCtx, &wg) {
+		log.Println("[WARN] Drain deadline reached, aborting in-flight scans")
+		abortScans()
+		// Aborted scans still publish their chunk events and ack; flushing
+		// or closing under them would lose both.
+		if !shutdown.Wait(finalCtx, &wg) {
+			log.Println("[WARN] Scans still running at the final deadline")
+		}
+	}
+
+	scanner.FlushProducer(finalCtx)
+	flushTraces(finalCtx)
+	sub.Close(finalCtx)
+	log.Println("[INFO] Shutdown complete")
 }
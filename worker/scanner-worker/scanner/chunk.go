@@ -0,0 +1,66 @@
+package scanner
+
+import (
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/exploravis/model"
+)
+
+// Chunk tallies the scan of one request for its ChunkComplete. Found is
+// called from naabu's goroutines.
+type Chunk struct {
+	req     model.ScanRequest
+	start   time.Time
+	hostsUp atomic.Int64
+	ports   atomic.Int64
+}
+
+func NewChunk(req model.ScanRequest) *Chunk {
+	return &Chunk{req: req, start: time.Now()}
+}
+
+func (c *Chunk) Found(msg *model.HostPorts) {
+	c.hostsUp.Add(1)
+	if msg.Ports != "" {
+		c.ports.Add(int64(strings.Count(msg.Ports, ",") + 1))
+	}
+}
+
+// Complete builds the scanner's ChunkComplete; err is what the scan
+// returned.
+func (c *Chunk) Complete(err error) *model.ChunkComplete {
+	ev := &model.ChunkComplete{
+		ScanID:       c.req.ScanID,
+		Chunk:        c.req.IPRange,
+		Stage:        "scanner",
+		HostsScanned: hostCount(c.req.IPRange),
+		HostsUp:      int(c.hostsUp.Load()),
+		Ports:        int(c.ports.Load()),
+		DurationMS:   time.Since(c.start).Milliseconds(),
+		Timestamp:    time.Now().Unix(),
+	}
+	if err != nil {
+		ev.Errors = 1
+		ev.Error = err.Error()
+	}
+	return ev
+}
+
+// hostCount is the number of addresses in a CIDR block, 1 for a single
+// address and 0 for anything else naabu accepts, such as a hostname.
+func hostCount(ipRange string) int {
+	if prefix, err := netip.ParsePrefix(ipRange); err == nil {
+		bits := prefix.Addr().BitLen() - prefix.Bits()
+		if bits >= 62 {
+			return 1 << 62
+		}
+		return 1 << bits
+	}
+	if _, err := netip.ParseAddr(ipRange); err == nil {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,86 @@
+package scanner
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/exploravis/model"
+)
+
+func TestHostCount(t *testing.T) {
+	tests := []struct {
+		ipRange string
+		want    int
+	}{
+		{"10.0.0.0/24", 256},
+		{"10.0.0.0/30", 4},
+		{"10.0.0.7/32", 1},
+		{"10.0.0.7", 1},
+		{"0.0.0.0/0", 1 << 32},
+		{"2001:db8::/120", 256},
+		{"2001:db8::1", 1},
+		{"2001:db8::/64", 1 << 62},
+		{"::/0", 1 << 62},
+		{"scanme.example.org", 0},
+		{"10.0.0.0/33", 0},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		if got := hostCount(tt.ipRange); got != tt.want {
+			t.Errorf("hostCount(%q) = %d, want %d", tt.ipRange, got, tt.want)
+		}
+	}
+}
+
+func TestChunkComplete(t *testing.T) {
+	req := model.ScanRequest{ScanID: "s1", IPRange: "192.0.2.0/28"}
+	tests := []struct {
+		name    string
+		found   []string // Ports of each HostPorts found
+		err     error
+		hostsUp int
+		ports   int
+	}{
+		{name: "nothing up", hostsUp: 0, ports: 0},
+		{name: "hosts and ports", found: []string{"22,80,443", "8080", ""}, hostsUp: 3, ports: 4},
+		{name: "failed scan", found: []string{"22"}, err: errors.New("scan of 192.0.2.0/28 timed out"), hostsUp: 1, ports: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChunk(req)
+			for _, ports := range tt.found {
+				c.Found(&model.HostPorts{Ports: ports})
+			}
+			ev := c.Complete(tt.err)
+			if ev.ScanID != "s1" || ev.Chunk != req.IPRange || ev.Stage != "scanner" || ev.HostsScanned != 16 {
+				t.Errorf("event = %+v", ev)
+			}
+			if ev.HostsUp != tt.hostsUp || ev.Ports != tt.ports {
+				t.Errorf("hosts up %d, ports %d; want %d, %d", ev.HostsUp, ev.Ports, tt.hostsUp, tt.ports)
+			}
+			if tt.err == nil && (ev.Errors != 0 || ev.Error != "") {
+				t.Errorf("clean scan reports errors: %+v", ev)
+			}
+			if tt.err != nil && (ev.Errors != 1 || ev.Error != tt.err.Error()) {
+				t.Errorf("failed scan reports %d errors, %q", ev.Errors, ev.Error)
+			}
+		})
+	}
+}
+
+func TestChunkFoundConcurrently(t *testing.T) {
+	c := NewChunk(model.ScanRequest{IPRange: "10.0.0.0/16"})
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Found(&model.HostPorts{Ports: "80,443"})
+		}()
+	}
+	wg.Wait()
+	if ev := c.Complete(nil); ev.HostsUp != 50 || ev.Ports != 100 {
+		t.Errorf("hosts up %d, ports %d; want 50, 100", ev.HostsUp, ev.Ports)
+	}
+}
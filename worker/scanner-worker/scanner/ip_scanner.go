@@ -2,31 +2,23 @@ package scanner
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"log"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/metrics"
+	"github.com/exploravis/worker/tracing"
 	"github.com/projectdiscovery/goflags"
 	"github.com/projectdiscovery/naabu/v2/pkg/port"
 	"github.com/projectdiscovery/naabu/v2/pkg/result"
 	"github.com/projectdiscovery/naabu/v2/pkg/runner"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-type ScanRequest struct {
-	ScanID string `json:"scan_id"`
-	Cidr   string `json:"ip_range"`
-	Ports  string `json:"ports"`
-}
-
-type ScanResult struct {
-	ScanID string `json:"scan_id"`
-	Host   string `json:"host"`
-	Ports  string `json:"ports"`
-	Time   int64  `json:"timestamp"`
-}
-
 func portsToString(ports []*port.Port) string {
 
 	if len(ports) == 0 {
@@ -40,9 +32,13 @@ func portsToString(ports []*port.Port) string {
 	return strings.Join(out, ",")
 }
 
-func buildOptions(req ScanRequest) *runner.Options {
+// Emit hands one found host to the next stage. ctx carries the scan's
+// trace.
+type Emit func(ctx context.Context, msg *model.HostPorts)
+
+func buildOptions(ctx context.Context, req model.ScanRequest, emit Emit) *runner.Options {
 	return &runner.Options{
-		Host:     goflags.StringSlice{req.Cidr},
+		Host:     goflags.StringSlice{req.IPRange},
 		Ports:    req.Ports,
 		ScanType: "c",
 
@@ -55,42 +51,71 @@ func buildOptions(req ScanRequest) *runner.Options {
 		Threads:           10,
 		Stream:            true,
 		OnResult: func(hr *result.HostResult) {
-			println("OS FINGERPRINT:", hr.OS)
-			msg := ScanResult{
-				ScanID: req.ScanID,
-				Host:   hr.Host,
-				Ports:  portsToString(hr.Ports),
-				Time:   time.Now().Unix(),
+			msg := model.HostPorts{
+				ScanID:    req.ScanID,
+				Host:      hr.Host,
+				Ports:     portsToString(hr.Ports),
+				Timestamp: time.Now().Unix(),
+				Chunk:     req.IPRange,
 			}
 
-			value, err := json.Marshal(msg)
-			if err != nil {
-				// log.Printf("marshal error: %v", err)
-				return
-			}
+			hostsFound.Inc()
+			portsFound.Add(float64(len(hr.Ports)))
 
 			// fmt.Printf("[RESULT] %s -> %+v, ", hr.Host, hr.Ports)
-			ProduceResult(value)
+			emit(ctx, &msg)
 
 		},
 	}
 }
 
-func RunScan(req ScanRequest) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+// ScanTimeout bounds one naabu run.
+const ScanTimeout = 10 * time.Minute
+
+// RunScan calls emit for every host found, from naabu's goroutines, as the
+// scan streams. The Kafka worker holds the request's Ack open until each
+// emitted host is delivered; see ProduceResult.
+//
+// Canceling ctx stops the scan early and RunScan returns the context's
+// error; hosts already emitted stay emitted. A scan still running after
+// ScanTimeout is stopped the same way and returns an error wrapping
+// context.DeadlineExceeded. Each emitted host carries ctx's trace, under a
+// span for the naabu run.
+func RunScan(ctx context.Context, req model.ScanRequest, emit Emit) (err error) {
+	start := time.Now()
+	defer func() {
+		scanDuration.WithLabelValues(metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	}()
+
+	ctx, span := tracing.Tracer.Start(ctx, "naabu scan", trace.WithAttributes(
+		attribute.String("scan.id", req.ScanID),
+		attribute.String("scan.ip_range", req.IPRange),
+		attribute.String("scan.ports", req.Ports),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	scanCtx, cancel := context.WithTimeout(ctx, ScanTimeout)
 	defer cancel()
 
-	opts := buildOptions(req)
+	opts := buildOptions(ctx, req, emit)
 
 	r, err := runner.NewRunner(opts)
 	if err != nil {
-		log.Printf("failed to create naabu runner: %v", err)
-		return
+		return fmt.Errorf("failed to create naabu runner: %w", err)
 	}
 
 	log.Printf("Naabu runner created succ")
 	defer r.Close()
 
-	r.RunEnumeration(ctx)
+	r.RunEnumeration(scanCtx)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("scan %s interrupted: %w", req.ScanID, err)
+	}
+	if err := scanCtx.Err(); err != nil {
+		// Only part of the chunk was scanned; the chunk's completion
+		// carries the error.
+		return fmt.Errorf("scan %s timed out after %s: %w", req.ScanID, ScanTimeout, err)
+	}
 	log.Printf("[WORKER FINISHED] ScanID %s completed.", req.ScanID)
+	return nil
 }
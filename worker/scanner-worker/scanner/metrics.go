@@ -0,0 +1,29 @@
+package scanner
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	hostsFound = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "exploravis",
+		Subsystem: "scanner",
+		Name:      "hosts_found_total",
+		Help:      "Hosts naabu reported with at least one open port.",
+	})
+	portsFound = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "exploravis",
+		Subsystem: "scanner",
+		Name:      "ports_found_total",
+		Help:      "Open ports naabu reported, across all hosts.",
+	})
+	scanDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "exploravis",
+		Subsystem: "scanner",
+		Name:      "scan_duration_seconds",
+		Help:      "Wall time of one scan request, by outcome (ok or error).",
+		// Requests are /24s capped at 10 minutes.
+		Buckets: prometheus.ExponentialBuckets(1, 2, 11),
+	}, []string{"outcome"})
+)
@@ -2,51 +2,130 @@ package scanner
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"time"
+	"sync"
 
+	"github.com/exploravis/bus"
+	"github.com/exploravis/config"
+	"github.com/exploravis/model"
+	"github.com/exploravis/worker/dlq"
+	"github.com/exploravis/worker/metrics"
+	"github.com/exploravis/worker/tracing"
 	"github.com/twmb/franz-go/pkg/kgo"
 )
 
-var producer *kgo.Client
+var (
+	producer     bus.Bus
+	topic        string
+	controlTopic string
+	wireEncoding = model.EncodingJSON
+)
 
-func InitProducer(brokers []string) {
-	cl, err := kgo.NewClient(
-		kgo.SeedBrokers(brokers...),
-		kgo.DialTimeout(5*time.Second),
-		kgo.ProduceRequestTimeout(5*time.Second),
-		kgo.DefaultProduceTopic("ip_scan_result"),
-		kgo.RecordPartitioner(kgo.RoundRobinPartitioner()),
-	)
+func InitProducer(b bus.Bus, out config.Producer, control config.Control) {
+	enc, err := model.ParseEncoding(out.Encoding)
 	if err != nil {
 		log.Fatalf("failed to create results producer: %v", err)
 	}
+	wireEncoding = enc
+	topic = out.Topic
+	controlTopic = control.Topic
 
-	producer = cl
+	producer = b
 	log.Println("Result producer initialized")
 }
 
-// Close when application shuts down
-func CloseProducer() {
-	if producer != nil {
-		producer.Close()
+// FlushProducer waits for published results to be delivered (or ctx to
+// end), so their delivery callbacks run and offsets can commit. The bus
+// itself is closed by main.
+func FlushProducer(ctx context.Context) {
+	if producer == nil {
+		return
+	}
+	if err := producer.Flush(ctx); err != nil {
+		log.Printf("flush failed: %v", err)
 	}
 }
 
-// Send result asynchronously
-func ProduceResult(value []byte) {
+// ProduceResult sends asynchronously and calls done exactly once, when the
+// broker has acked the record or delivery has failed. The trace context of
+// ctx travels with the record.
+func ProduceResult(ctx context.Context, msg *model.HostPorts, done func(error)) {
 	if producer == nil {
 		log.Printf("producer not initialized, dropping message")
+		done(fmt.Errorf("%w: producer not initialized", dlq.ErrProduce))
+		return
+	}
+
+	value, err := model.Marshal(msg, wireEncoding)
+	if err != nil {
+		log.Printf("marshal error: %v", err)
+		done(err)
 		return
 	}
 
+	// Keyed by chunk so its completion marker follows it.
 	record := &kgo.Record{
+		Topic: topic,
+		Key:   []byte(model.ChunkKey(msg.ScanID, msg.Chunk)),
 		Value: value,
 	}
+	span := tracing.StartProduce(ctx, record)
 
-	producer.Produce(context.Background(), record, func(_ *kgo.Record, err error) {
+	producer.Publish(context.Background(), record, func(r *kgo.Record, err error) {
+		metrics.Produced(r.Topic, err)
+		tracing.EndProduce(span, r, err)
 		if err != nil {
 			log.Printf("failed to deliver scan result: %v", err)
+			done(fmt.Errorf("%w: %v", dlq.ErrProduce, err))
+			return
 		}
+		done(nil)
 	})
 }
+
+// ProduceChunkComplete publishes ev on the control topic, and as a marker on
+// the results topic behind the chunk's hosts, where banner-worker picks it
+// up. done is called once, when both are delivered or either has failed.
+func ProduceChunkComplete(ctx context.Context, ev *model.ChunkComplete, done func(error)) {
+	if producer == nil {
+		done(fmt.Errorf("%w: producer not initialized", dlq.ErrProduce))
+		return
+	}
+
+	value, err := model.Marshal(ev, wireEncoding)
+	if err != nil {
+		done(err)
+		return
+	}
+
+	key := []byte(model.ChunkKey(ev.ScanID, ev.Chunk))
+	records := []*kgo.Record{
+		{Topic: topic, Key: key, Value: value, Headers: []kgo.RecordHeader{
+			{Key: model.HeaderMessage, Value: []byte(model.MessageChunkComplete)},
+		}},
+		{Topic: controlTopic, Key: key, Value: value},
+	}
+
+	var (
+		mu      sync.Mutex
+		pending = len(records)
+		first   error
+	)
+	for _, record := range records {
+		span := tracing.StartProduce(ctx, record)
+		producer.Publish(context.Background(), record, func(r *kgo.Record, err error) {
+			metrics.Produced(r.Topic, err)
+			tracing.EndProduce(span, r, err)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && first == nil {
+				log.Printf("failed to deliver chunk completion: %v", err)
+				first = fmt.Errorf("%w: %v", dlq.ErrProduce, err)
+			}
+			if pending--; pending == 0 {
+				done(first)
+			}
+		})
+	}
+}
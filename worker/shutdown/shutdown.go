@@ -0,0 +1,61 @@
+// Package shutdown holds the pieces every worker uses to drain on SIGTERM:
+// stop fetching, let in-flight work finish, flush the bus, commit and leave
+// the consumer group, all within one deadline.
+package shutdown
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// OnSignal returns a context that is canceled on SIGINT or SIGTERM. Workers
+// poll with it, so cancellation is what stops fetching.
+func OnSignal() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// Deadlines starts the drain clock for timeout. In-flight work must finish
+// by work; the rest of the deadline is reserved for flushing producers,
+// committing and leaving the group, which must finish by final.
+func Deadlines(timeout time.Duration) (work, final context.Context, cancel context.CancelFunc) {
+	reserve := min(5*time.Second, timeout/5)
+	final, cancelFinal := context.WithTimeout(context.Background(), timeout)
+	work, cancelWork := context.WithTimeout(final, timeout-reserve)
+	return work, final, func() {
+		cancelWork()
+		cancelFinal()
+	}
+}
+
+// Drain returns a context that ends timeout after ctx does, for work that
+// should finish after a signal rather than be cut off by it.
+func Drain(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drain, cancel := context.WithCancel(context.Background())
+	stop := context.AfterFunc(ctx, func() {
+		time.AfterFunc(timeout, cancel)
+	})
+	return drain, func() {
+		stop()
+		cancel()
+	}
+}
+
+// Wait waits for wg until ctx is done and reports whether everything
+// finished in time.
+func Wait(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,159 @@
+// Package tracing sets up OpenTelemetry for a worker and carries trace
+// context across Kafka in record headers, so one trace follows a scan from
+// the orchestrator's request to the documents the bulk indexer writes.
+//
+// Context is propagated even with the exporter off: a stage that doesn't
+// export still forwards the upstream traceparent unchanged.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/exploravis/config"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer is shared by every stage; span names say which stage they are.
+var Tracer = otel.Tracer("github.com/exploravis/worker")
+
+// Init installs the propagator and, unless cfg.Exporter is "none", a tracer
+// provider for service. The returned func flushes buffered spans.
+func Init(service string, cfg config.Tracing) func(context.Context) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var (
+		exp  sdktrace.SpanExporter
+		file *os.File
+		err  error
+	)
+	switch cfg.Exporter {
+	case "otlp":
+		exp, err = otlptracehttp.New(context.Background())
+	case "file":
+		file, err = os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err == nil {
+			exp, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		}
+	default:
+		return func(context.Context) {}
+	}
+	if err != nil {
+		log.Printf("[WARN] Tracing disabled, unable to create %s exporter: %v", cfg.Exporter, err)
+		return func(context.Context) {}
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(service))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	log.Printf("[INFO] Exporting traces via %s", cfg.Exporter)
+
+	return func(ctx context.Context) {
+		if err := tp.Shutdown(ctx); err != nil {
+			log.Printf("[WARN] Flushing traces failed: %v", err)
+		}
+		if file != nil {
+			file.Close()
+		}
+	}
+}
+
+// headers adapts a record's headers to propagation.TextMapCarrier.
+type headers struct{ rec *kgo.Record }
+
+func (h headers) Get(key string) string {
+	for _, hd := range h.rec.Headers {
+		if hd.Key == key {
+			return string(hd.Value)
+		}
+	}
+	return ""
+}
+
+func (h headers) Set(key, value string) {
+	for i, hd := range h.rec.Headers {
+		if hd.Key == key {
+			h.rec.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	h.rec.Headers = append(h.rec.Headers, kgo.RecordHeader{Key: key, Value: []byte(value)})
+}
+
+func (h headers) Keys() []string {
+	keys := make([]string, len(h.rec.Headers))
+	for i, hd := range h.rec.Headers {
+		keys[i] = hd.Key
+	}
+	return keys
+}
+
+// Inject writes the trace context of ctx into rec's headers.
+func Inject(ctx context.Context, rec *kgo.Record) {
+	otel.GetTextMapPropagator().Inject(ctx, headers{rec})
+}
+
+// Extract returns ctx carrying the trace context found in rec's headers.
+func Extract(ctx context.Context, rec *kgo.Record) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, headers{rec})
+}
+
+// StartProcess starts the span for a stage's handling of a consumed record,
+// as a child of whatever produced it.
+func StartProcess(rec *kgo.Record) (context.Context, trace.Span) {
+	ctx := Extract(context.Background(), rec)
+	return Tracer.Start(ctx, rec.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingDestinationName(rec.Topic),
+			semconv.MessagingDestinationPartitionID(fmt.Sprint(rec.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(rec.Offset)),
+		),
+	)
+}
+
+// StartProduce starts a span for producing rec and injects it into rec's
+// headers, so the next stage's span is its child. End it with EndProduce
+// from the delivery callback.
+func StartProduce(ctx context.Context, rec *kgo.Record) trace.Span {
+	ctx, span := Tracer.Start(ctx, "produce",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(semconv.MessagingSystemKafka),
+	)
+	Inject(ctx, rec)
+	return span
+}
+
+// EndProduce ends a StartProduce span once rec's delivery is known.
+func EndProduce(span trace.Span, rec *kgo.Record, err error) {
+	span.SetName(rec.Topic + " publish")
+	span.SetAttributes(
+		semconv.MessagingDestinationName(rec.Topic),
+		semconv.MessagingKafkaMessageOffset(int(rec.Offset)),
+	)
+	End(span, err)
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
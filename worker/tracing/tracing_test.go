@@ -0,0 +1,156 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/exploravis/config"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// exportedSpan is the part of a stdouttrace span the tests check.
+type exportedSpan struct {
+	Name        string
+	SpanContext struct{ TraceID, SpanID string }
+	Parent      struct{ TraceID, SpanID string }
+	SpanKind    int
+	Status      struct{ Code, Description string }
+	Resource    []struct {
+		Key   string
+		Value struct{ Value any }
+	}
+}
+
+func readSpans(t *testing.T, path string) map[string]exportedSpan {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	spans := map[string]exportedSpan{}
+	dec := json.NewDecoder(f)
+	for {
+		var s exportedSpan
+		if err := dec.Decode(&s); errors.Is(err, io.EOF) {
+			return spans
+		} else if err != nil {
+			t.Fatalf("reading %s: %v", path, err)
+		}
+		spans[s.Name] = s
+	}
+}
+
+// A scan request through two stages, as the file exporter records it.
+func TestFileExporterTrace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.jsonl")
+	flush := Init("test-stage", config.Tracing{Exporter: "file", File: path, SampleRatio: 1})
+
+	ctx, root := Tracer.Start(context.Background(), "POST /scan")
+	req := &kgo.Record{Topic: "scan_requests", Value: []byte("{}")}
+	span := StartProduce(ctx, req)
+	req.Offset = 7
+	EndProduce(span, req, nil)
+	root.End()
+
+	// The next stage, from nothing but the record.
+	ctx, process := StartProcess(req)
+	result := &kgo.Record{Topic: "scan_results"}
+	span = StartProduce(ctx, result)
+	EndProduce(span, result, errors.New("broker down"))
+	process.End()
+
+	flush(context.Background())
+	spans := readSpans(t, path)
+
+	names := []string{"POST /scan", "scan_requests publish", "scan_requests process", "scan_results publish"}
+	for _, name := range names {
+		if _, ok := spans[name]; !ok {
+			t.Fatalf("no %q span in %v", name, spans)
+		}
+	}
+	traceID := spans["POST /scan"].SpanContext.TraceID
+	for _, name := range names {
+		if got := spans[name].SpanContext.TraceID; got != traceID {
+			t.Errorf("%s is in trace %s, want %s", name, got, traceID)
+		}
+	}
+	// Each span is the child of the one before it.
+	for i := 1; i < len(names); i++ {
+		if got, want := spans[names[i]].Parent.SpanID, spans[names[i-1]].SpanContext.SpanID; got != want {
+			t.Errorf("%s has parent %s, want %s (%s)", names[i], got, want, names[i-1])
+		}
+	}
+
+	if k := spans["scan_requests process"].SpanKind; k != int(trace.SpanKindConsumer) {
+		t.Errorf("process span kind = %d", k)
+	}
+	if k := spans["scan_results publish"].SpanKind; k != int(trace.SpanKindProducer) {
+		t.Errorf("publish span kind = %d", k)
+	}
+	if s := spans["scan_results publish"].Status; s.Code != "Error" || s.Description != "broker down" {
+		t.Errorf("failed publish status = %+v", s)
+	}
+	service := ""
+	for _, attr := range spans["POST /scan"].Resource {
+		if attr.Key == "service.name" {
+			service, _ = attr.Value.Value.(string)
+		}
+	}
+	if service != "test-stage" {
+		t.Errorf("service.name = %q", service)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	Init("test-stage", config.Tracing{Exporter: "none"})
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	ctx := trace.ContextWithSpanContext(context.Background(), sc)
+
+	rec := &kgo.Record{Headers: []kgo.RecordHeader{
+		{Key: "dlq.reason", Value: []byte("x")},
+		{Key: "traceparent", Value: []byte("00-stale-stale-00")},
+	}}
+	Inject(ctx, rec)
+	if len(rec.Headers) != 2 {
+		t.Errorf("headers = %v, want traceparent replaced in place", rec.Headers)
+	}
+	if got := (headers{rec}).Get("traceparent"); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("traceparent = %q", got)
+	}
+
+	got := trace.SpanContextFromContext(Extract(context.Background(), rec))
+	if got.TraceID() != traceID || got.SpanID() != spanID || !got.IsSampled() || !got.IsRemote() {
+		t.Errorf("extracted %+v", got)
+	}
+
+	if keys := (headers{rec}).Keys(); len(keys) != 2 || keys[0] != "dlq.reason" {
+		t.Errorf("keys = %v", keys)
+	}
+}
+
+// With the exporter off a stage still forwards its upstream trace.
+func TestForwardWithoutExporter(t *testing.T) {
+	Init("test-stage", config.Tracing{Exporter: "none"})
+	in := &kgo.Record{Topic: "scan_requests", Headers: []kgo.RecordHeader{
+		{Key: "traceparent", Value: []byte("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")},
+	}}
+	ctx, span := StartProcess(in)
+	defer span.End()
+	out := &kgo.Record{}
+	Inject(ctx, out)
+	// Unchanged with no provider installed; a child span of the same trace
+	// once an earlier test has installed one, as Tracer keeps it.
+	if got := (headers{out}).Get("traceparent"); !strings.HasPrefix(got, "00-4bf92f3577b34da6a3ce929d0e0e4736-") {
+		t.Errorf("forwarded traceparent %q is not in the upstream trace", got)
+	}
+}